@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"open-statistic/internal/parser"
+)
+
+var base = time.Date(2024, 2, 23, 10, 0, 0, 0, time.UTC)
+
+func snapshot(minute int) ingestRequest {
+	return ingestRequest{Instance: "agent1", Status: &parser.Status{UpdatedAt: base.Add(time.Duration(minute) * time.Minute)}}
+}
+
+// TestSpool снимки отдаются от старых к новым, повтор перезаписывает файл, сверх лимита
+// удаляются самые старые
+func TestSpool(t *testing.T) {
+	sp, err := newSpool(t.TempDir(), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range []int{2, 0, 1, 1, 3} {
+		if err := sp.put(snapshot(m)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	names, err := sp.list()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []time.Time
+	for _, name := range names {
+		data, err := sp.read(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var req ingestRequest
+		if err := json.Unmarshal(data, &req); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, req.Status.UpdatedAt)
+	}
+	want := []time.Time{base.Add(time.Minute), base.Add(2 * time.Minute), base.Add(3 * time.Minute)}
+	if len(got) != len(want) {
+		t.Fatalf("в очереди %v, ожидалось %v", got, want)
+	}
+	for i := range want {
+		if !got[i].Equal(want[i]) {
+			t.Errorf("%d: %v, ожидалось %v", i, got[i], want[i])
+		}
+	}
+}
+
+// TestFlush временная ошибка сервера оставляет очередь целиком, окончательный отказ
+// удаляет только отклонённый снимок
+func TestFlush(t *testing.T) {
+	tests := []struct {
+		name      string
+		codes     []int // ответы сервера по порядку запросов, дальше — 200
+		wantSent  int   // запросов к серверу
+		wantQueue int   // снимков осталось
+	}{
+		{"доставлено", nil, 3, 0},
+		{"сервер недоступен", []int{http.StatusServiceUnavailable}, 1, 3},
+		{"ограничение частоты", []int{http.StatusOK, http.StatusTooManyRequests}, 2, 2},
+		{"неверный токен", []int{http.StatusUnauthorized}, 1, 3},
+		{"снимок отклонён", []int{http.StatusBadRequest}, 3, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var updated []time.Time
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				defer mu.Unlock()
+				if r.URL.Path != "/ingest" || r.Header.Get("Authorization") != "Bearer secret" {
+					t.Errorf("запрос %s, Authorization %q", r.URL.Path, r.Header.Get("Authorization"))
+				}
+				var req ingestRequest
+				body, _ := io.ReadAll(r.Body)
+				if err := json.Unmarshal(body, &req); err != nil {
+					t.Error(err)
+				}
+				updated = append(updated, req.Status.UpdatedAt)
+				code := http.StatusOK
+				if n := len(updated); n <= len(tt.codes) {
+					code = tt.codes[n-1]
+				}
+				w.WriteHeader(code)
+			}))
+			defer srv.Close()
+
+			sp, err := newSpool(t.TempDir(), 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for m := 2; m >= 0; m-- {
+				if err := sp.put(snapshot(m)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			a := &agent{instance: "agent1", spool: sp, client: newClient(srv.URL+"/", "secret", time.Second)}
+			a.flush(context.Background())
+
+			if len(updated) != tt.wantSent {
+				t.Errorf("отправлено %d, ожидалось %d", len(updated), tt.wantSent)
+			}
+			for i, at := range updated {
+				if !at.Equal(base.Add(time.Duration(i) * time.Minute)) {
+					t.Errorf("%d-й отправлен снимок %v", i, at)
+				}
+			}
+			if names, _ := sp.list(); len(names) != tt.wantQueue {
+				t.Errorf("в очереди %d, ожидалось %d", len(names), tt.wantQueue)
+			}
+		})
+	}
+}
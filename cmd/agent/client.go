@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// client отправляет снимки на POST /ingest центрального сервера
+type client struct {
+	url   string
+	token string
+	http  *http.Client
+}
+
+func newClient(server, token string, timeout time.Duration) *client {
+	return &client{
+		url:   strings.TrimRight(server, "/") + "/ingest",
+		token: token,
+		http:  &http.Client{Timeout: timeout},
+	}
+}
+
+// rejectedError сервер отклонил снимок окончательно (4xx): повтор не поможет
+type rejectedError struct {
+	code int
+	body string
+}
+
+func (e *rejectedError) Error() string {
+	return fmt.Sprintf("HTTP %d: %s", e.code, e.body)
+}
+
+// isTemporary ошибку стоит повторить позже (сеть, 5xx, 408, 429)
+func isTemporary(err error) bool {
+	var rej *rejectedError
+	return !errors.As(err, &rej)
+}
+
+func (c *client) send(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	switch {
+	case resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusRequestTimeout, resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode >= 500:
+		return fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	case resp.StatusCode == http.StatusUnauthorized:
+		// Неверный токен или /ingest не включён — ошибки настройки, снимки сохраняются до их исправления
+		return fmt.Errorf("HTTP 401: проверьте -token")
+	case resp.StatusCode == http.StatusNotFound:
+		return fmt.Errorf("HTTP 404: на сервере не включён /ingest (INGEST_TOKEN)")
+	default:
+		return &rejectedError{code: resp.StatusCode, body: strings.TrimSpace(string(msg))}
+	}
+}
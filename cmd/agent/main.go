@@ -0,0 +1,175 @@
+// Агент для удалённых OpenVPN-серверов: читает status-файл и отправляет снимки
+// центральному open-statistic (POST /ingest). Пока центральный сервер недоступен,
+// снимки копятся в spool-директории и досылаются по порядку.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"open-statistic/internal/parser"
+)
+
+func getEnv(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func getEnvInt(key string, fallback int) int {
+	if n, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		return n
+	}
+	return fallback
+}
+
+func mustParseDuration(s string) time.Duration {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 60 * time.Second
+	}
+	return d
+}
+
+func hostname() string {
+	if h, err := os.Hostname(); err == nil && h != "" {
+		return strings.SplitN(h, ".", 2)[0]
+	}
+	return "agent"
+}
+
+func main() {
+	server := flag.String("server", getEnv("OPENSTAT_URL", ""), "адрес центрального open-statistic, например https://stats.example.com")
+	token := flag.String("token", getEnv("INGEST_TOKEN", ""), "токен для POST /ingest (INGEST_TOKEN или API_KEY центрального сервера)")
+	statusPath := flag.String("status", getEnv("STATUS_PATH", "/var/log/openvpn/status.log"), "путь к OpenVPN status-файлу")
+	instance := flag.String("instance", getEnv("INSTANCE", hostname()), "имя инстанса на центральном сервере")
+	interval := flag.Duration("interval", mustParseDuration(getEnv("INTERVAL", "60s")), "интервал чтения status-файла")
+	timeout := flag.Duration("timeout", mustParseDuration(getEnv("TIMEOUT", "10s")), "таймаут запроса к центральному серверу")
+	spoolDir := flag.String("spool", getEnv("SPOOL_DIR", "./spool"), "директория для снимков, ещё не доставленных на сервер")
+	statusTZ := flag.String("status-tz", getEnv("STATUS_TZ", ""), "часовой пояс строкового времени в status-файле, например Europe/Moscow (пусто — локальный)")
+	spoolMax := flag.Int("spool-max", getEnvInt("SPOOL_MAX", 10000), "сколько недоставленных снимков хранить (старые удаляются)")
+	flag.Parse()
+
+	if *statusTZ != "" {
+		loc, err := time.LoadLocation(*statusTZ)
+		if err != nil {
+			log.Fatalf("STATUS_TZ: %v", err)
+		}
+		parser.Location = loc
+	}
+	if *server == "" {
+		log.Fatal("не задан адрес сервера (-server или OPENSTAT_URL)")
+	}
+	if *instance == "" {
+		log.Fatal("не задано имя инстанса (-instance или INSTANCE)")
+	}
+	sp, err := newSpool(*spoolDir, *spoolMax)
+	if err != nil {
+		log.Fatalf("Spool: %v", err)
+	}
+	a := &agent{
+		instance: *instance,
+		path:     *statusPath,
+		spool:    sp,
+		client:   newClient(*server, *token, *timeout),
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	fmt.Printf("Агент [%s]: %s → %s (каждые %s)\n", *instance, *statusPath, *server, *interval)
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+	for {
+		a.tick(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+type agent struct {
+	instance string
+	path     string
+	spool    *spool
+	client   *client
+
+	lastUpdated time.Time
+}
+
+// tick читает status-файл, кладёт новый снимок в spool и досылает накопившееся
+func (a *agent) tick(ctx context.Context) {
+	if status, err := a.read(); err != nil {
+		log.Printf("Status-файл: %v", err)
+	} else if !status.Incomplete() && !status.UpdatedAt.Equal(a.lastUpdated) {
+		// Неизменившийся и недописанный файлы не отправляем
+		if err := a.spool.put(ingestRequest{Instance: a.instance, Status: status}); err != nil {
+			log.Printf("Spool: %v", err)
+		} else {
+			a.lastUpdated = status.UpdatedAt
+		}
+	}
+	a.flush(ctx)
+}
+
+// read парсит status-файл; без строки Updated временем снимка считается время изменения файла
+// (сервер не принимает снимки без времени)
+func (a *agent) read() (*parser.Status, error) {
+	status, err := parser.ParseFile(a.path)
+	if err != nil {
+		return nil, err
+	}
+	if status.UpdatedAt.IsZero() {
+		fi, err := os.Stat(a.path)
+		if err != nil {
+			return nil, err
+		}
+		status.UpdatedAt = fi.ModTime().UTC()
+	}
+	return status, nil
+}
+
+// flush отправляет снимки из spool от старых к новым. На временной ошибке останавливается,
+// чтобы не нарушить порядок; снимок, отклонённый сервером окончательно, удаляется
+func (a *agent) flush(ctx context.Context) {
+	names, err := a.spool.list()
+	if err != nil {
+		log.Printf("Spool: %v", err)
+		return
+	}
+	for _, name := range names {
+		if ctx.Err() != nil {
+			return
+		}
+		body, err := a.spool.read(name)
+		if err != nil {
+			log.Printf("Spool: %v", err)
+			a.spool.remove(name)
+			continue
+		}
+		err = a.client.send(ctx, body)
+		if err != nil && isTemporary(err) {
+			if len(names) > 1 {
+				log.Printf("Отправка: %v (в очереди %d)", err, len(names))
+			} else {
+				log.Printf("Отправка: %v", err)
+			}
+			return
+		}
+		if err != nil {
+			log.Printf("Снимок %s отклонён: %v", name, err)
+		}
+		a.spool.remove(name)
+	}
+}
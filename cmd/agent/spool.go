@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"open-statistic/internal/parser"
+)
+
+// ingestRequest тело POST /ingest (формат api.IngestRequest)
+type ingestRequest struct {
+	Instance string         `json:"instance"`
+	Status   *parser.Status `json:"status"`
+}
+
+// spool очередь недоставленных снимков на диске: файл на снимок, имя — время Updated,
+// поэтому сортировка имён даёт порядок отправки, а повтор того же снимка перезаписывает файл
+type spool struct {
+	dir string
+	max int
+}
+
+func newSpool(dir string, max int) (*spool, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	if max <= 0 {
+		max = 1
+	}
+	return &spool{dir: dir, max: max}, nil
+}
+
+func (s *spool) put(req ingestRequest) error {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%020d.json", req.Status.UpdatedAt.UnixNano())
+	tmp, err := os.CreateTemp(s.dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(s.dir, name)); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return s.trim()
+}
+
+// trim удаляет самые старые снимки сверх лимита
+func (s *spool) trim() error {
+	names, err := s.list()
+	if err != nil {
+		return err
+	}
+	for len(names) > s.max {
+		log.Printf("Spool переполнен (%d), удаляется %s", len(names), names[0])
+		s.remove(names[0])
+		names = names[1:]
+	}
+	return nil
+}
+
+// list имена снимков от старых к новым
+func (s *spool) list() ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		if e.Type().IsRegular() && strings.HasSuffix(e.Name(), ".json") && !strings.HasPrefix(e.Name(), ".") {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (s *spool) read(name string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(s.dir, name))
+}
+
+func (s *spool) remove(name string) {
+	if err := os.Remove(filepath.Join(s.dir, name)); err != nil && !os.IsNotExist(err) {
+		log.Printf("Spool: %v", err)
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"open-statistic/internal/database"
+	"open-statistic/internal/parser"
+)
+
+// archiveFile status-файл из архива: время Updated и хеш содержимого (после распаковки)
+type archiveFile struct {
+	path      string
+	updatedAt time.Time
+	hash      string
+}
+
+// runImport — openstat import: воспроизводит архивы status-файлов по возрастанию Updated
+func runImport(args []string) int {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	dbPath := flags.String("db", getEnv("DB_PATH", "./openstat.db"), "путь к SQLite БД")
+	instance := flags.String("instance", getEnv("INSTANCE", database.DefaultInstance), "инстанс, под которым сохраняются снимки")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Использование: openstat import [-db path] [-instance name] <файлы, каталоги, шаблоны>...")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	paths, err := expandImportPaths(flags.Args())
+	if err != nil {
+		log.Printf("Импорт: %v", err)
+		return 1
+	}
+
+	// Первый проход только узнаёт время снимков: архив за месяцы целиком в память не нужен
+	var files []archiveFile
+	failed := 0
+	for _, path := range paths {
+		status, hash, err := readArchive(path)
+		if err != nil {
+			log.Printf("Пропущен %s: %v", path, err)
+			failed++
+			continue
+		}
+		files = append(files, archiveFile{path: path, updatedAt: status.UpdatedAt, hash: hash})
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		if !files[i].updatedAt.Equal(files[j].updatedAt) {
+			return files[i].updatedAt.Before(files[j].updatedAt)
+		}
+		return files[i].path < files[j].path
+	})
+
+	db, err := database.New(*dbPath)
+	if err != nil {
+		log.Printf("БД: %v", err)
+		return 1
+	}
+	defer db.Close()
+
+	counts := make(map[string]int)
+	for i, f := range files {
+		status, _, err := readArchive(f.path)
+		if err != nil {
+			log.Printf("Пропущен %s: %v", f.path, err)
+			failed++
+			continue
+		}
+		result, err := db.ImportSnapshot(*instance, status, f.hash, f.path)
+		if err != nil {
+			log.Printf("Импорт %s: %v", f.path, err)
+			failed++
+			continue
+		}
+		counts[result]++
+		if result != database.ImportOK {
+			log.Printf("Пропущен %s (%s): %s", f.path, f.updatedAt.Format(time.RFC3339), result)
+		}
+		if (i+1)%100 == 0 {
+			log.Printf("Импорт: %d из %d", i+1, len(files))
+		}
+	}
+	fmt.Printf("Импортировано: %d, уже импортированы: %d, старше импортированных: %d, пересекаются с живым сбором: %d, ошибок: %d\n",
+		counts[database.ImportOK], counts[database.ImportDuplicate], counts[database.ImportOlder], counts[database.ImportOverlap], failed)
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// expandImportPaths раскрывает шаблоны и каталоги (рекурсивно) в список файлов без повторов
+func expandImportPaths(args []string) ([]string, error) {
+	var paths []string
+	seen := make(map[string]bool)
+	add := func(p string) {
+		if !seen[p] {
+			seen[p] = true
+			paths = append(paths, p)
+		}
+	}
+	for _, arg := range args {
+		matches := []string{arg}
+		if strings.ContainsAny(arg, "*?[") {
+			var err error
+			if matches, err = filepath.Glob(arg); err != nil {
+				return nil, fmt.Errorf("шаблон %q: %w", arg, err)
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("по шаблону %q файлов нет", arg)
+			}
+		}
+		for _, m := range matches {
+			fi, err := os.Stat(m)
+			if err != nil {
+				return nil, err
+			}
+			if !fi.IsDir() {
+				add(m)
+				continue
+			}
+			err = filepath.WalkDir(m, func(p string, d fs.DirEntry, err error) error {
+				if err != nil {
+					return err
+				}
+				if d.Type().IsRegular() {
+					add(p)
+				}
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return paths, nil
+}
+
+// readArchive читает status-файл (в том числе сжатый gzip) и разбирает его. Без строки Updated
+// временем снимка считается время изменения файла
+func readArchive(path string) (*parser.Status, string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(data) >= 2 && data[0] == 0x1f && data[1] == 0x8b {
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, "", err
+		}
+		data, err = io.ReadAll(zr)
+		zr.Close()
+		if err != nil {
+			return nil, "", fmt.Errorf("gzip: %w", err)
+		}
+	}
+	status, err := parser.ParseBytes(data)
+	if err != nil {
+		return nil, "", err
+	}
+	if status.Incomplete() {
+		return nil, "", fmt.Errorf("пустой или неполный status-файл")
+	}
+	if status.UpdatedAt.IsZero() {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return nil, "", err
+		}
+		status.UpdatedAt = fi.ModTime().UTC()
+	}
+	sum := sha256.Sum256(data)
+	return status, hex.EncodeToString(sum[:]), nil
+}
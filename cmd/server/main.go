@@ -14,13 +14,22 @@ import (
 	"syscall"
 	"time"
 
+	"open-statistic/internal/alerts"
 	"open-statistic/internal/api"
+	"open-statistic/internal/collector"
 	"open-statistic/internal/database"
+	"open-statistic/internal/events"
+	"open-statistic/internal/geoip"
 	"open-statistic/internal/parser"
+	"open-statistic/internal/quota"
+	"open-statistic/internal/webhook"
 
 	"github.com/gin-gonic/gin"
 )
 
+// eventsHistory сколько последних событий хранится для клиентов /events, переподключившихся с Last-Event-ID
+const eventsHistory = 1024
+
 func getEnv(key, fallback string) string {
 	if v := os.Getenv(key); v != "" {
 		return v
@@ -36,12 +45,55 @@ func mustParseDuration(s string) time.Duration {
 	return d
 }
 
-func mustParseInt(s string, fallback int) int {
-	n, err := strconv.Atoi(s)
+// parseRetention разбирает срок хранения: Go-длительность или число дней с суффиксом d ("7d").
+// "0", "" и "forever" — хранить всегда
+func parseRetention(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	switch s {
+	case "", "0", "forever":
+		return 0, nil
+	}
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("неверный срок хранения %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("неверный срок хранения %q", s)
+	}
+	return d, nil
+}
+
+// retentionFlag флаг со сроком хранения в формате parseRetention
+type retentionFlag struct{ d time.Duration }
+
+func (f *retentionFlag) String() string {
+	if f.d%(24*time.Hour) == 0 {
+		return fmt.Sprintf("%dd", f.d/(24*time.Hour))
+	}
+	return f.d.String()
+}
+
+func (f *retentionFlag) Set(s string) error {
+	d, err := parseRetention(s)
 	if err != nil {
-		return fallback
+		return err
+	}
+	f.d = d
+	return nil
+}
+
+func newRetentionFlag(name, env, fallback, usage string) *retentionFlag {
+	f := &retentionFlag{}
+	if err := f.Set(getEnv(env, fallback)); err != nil {
+		log.Printf("%s: %v, используется %s", env, err, fallback)
+		f.Set(fallback)
 	}
-	return n
+	flag.Var(f, name, usage)
+	return f
 }
 
 func splitPaths(s string) []string {
@@ -55,61 +107,211 @@ func splitPaths(s string) []string {
 	return out
 }
 
+// statusSource источник одного OpenVPN-инстанса: путь к status-файлу или адрес management-интерфейса
+type statusSource struct {
+	name string
+	path string
+}
+
+// parseSources разбирает список источников: "udp1=/var/log/openvpn/udp.log,tcp1=tcp://127.0.0.1:7505".
+// Источник без имени получает имя defaultName
+func parseSources(s, defaultName string) ([]statusSource, error) {
+	var sources []statusSource
+	seen := make(map[string]bool)
+	for _, item := range splitPaths(s) {
+		src := statusSource{name: defaultName, path: item}
+		if name, path, ok := strings.Cut(item, "="); ok {
+			src = statusSource{name: strings.TrimSpace(name), path: strings.TrimSpace(path)}
+		}
+		if src.name == "" || src.path == "" {
+			return nil, fmt.Errorf("неверный источник %q: ожидается имя=путь или путь", item)
+		}
+		if seen[src.name] {
+			return nil, fmt.Errorf("инстанс %q указан дважды", src.name)
+		}
+		seen[src.name] = true
+		sources = append(sources, src)
+	}
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("не задан ни один status-файл")
+	}
+	return sources, nil
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "import":
+			os.Exit(runImport(os.Args[2:]))
+		case "rebuild":
+			os.Exit(runRebuild(os.Args[2:]))
+		}
+	}
 	dbPath := flag.String("db", getEnv("DB_PATH", "./openstat.db"), "путь к SQLite БД")
-	statusPath := flag.String("status", getEnv("STATUS_PATH", "/var/log/openvpn/status.log"), "путь к OpenVPN status-файлу")
+	statusPaths := flag.String("status", getEnv("STATUS_PATH", "/var/log/openvpn/status.log"), "OpenVPN status-файлы или management-интерфейсы (tcp://host:port, unix:///path): один или список имя=источник через запятую")
+	managementPassword := flag.String("management-password", getEnv("MANAGEMENT_PASSWORD", ""), "пароль management-интерфейса OpenVPN")
+	killManagement := flag.String("management", getEnv("MANAGEMENT_ADDR", ""), "management-интерфейсы для отключения клиентов инстансов, собираемых из status-файла: имя=tcp://host:port через запятую")
+	statusTZ := flag.String("status-tz", getEnv("STATUS_TZ", ""), "часовой пояс строкового времени в status-файле, например Europe/Moscow (пусто — локальный)")
+	clientAuth := flag.Bool("management-client-auth", getEnv("MANAGEMENT_CLIENT_AUTH", "") == "1", "отвечать client-auth-nt на >CLIENT:CONNECT (для OpenVPN с management-client-auth)")
+	bytecount := flag.Duration("bytecount", mustParseDuration(getEnv("BYTECOUNT", "0s")), "период уведомлений >BYTECOUNT_CLI от management-интерфейса (0 — выключены)")
+	instance := flag.String("instance", getEnv("INSTANCE", database.DefaultInstance), "имя инстанса для status-файла, указанного без имени")
 	addr := flag.String("addr", ":"+getEnv("PORT", "8080"), "адрес HTTP-сервера")
 	interval := flag.Duration("interval", mustParseDuration(getEnv("INTERVAL", "60s")), "интервал сбора статистики")
-	retention := flag.Int("retention", mustParseInt(getEnv("RETENTION", "1000"), 1000), "хранить последние N снимков (0 = без ограничения)")
+	watch := flag.Bool("watch", getEnv("WATCH", "") == "1", "собирать сразу после перезаписи status-файла (inotify), иначе опрос с -interval")
+	debounce := flag.Duration("debounce", mustParseDuration(getEnv("DEBOUNCE", "500ms")), "пауза после последнего изменения status-файла перед сбором")
+	webhookSecret := flag.String("webhook-secret", getEnv("WEBHOOK_SECRET", ""), "ключ HMAC-подписи webhook-ов (заголовок X-Openstat-Signature)")
+	webhookHosts := flag.String("webhook-allowed-hosts", getEnv("WEBHOOK_ALLOWED_HOSTS", ""), "хосты, на которые можно отправлять webhook-и, через запятую: host, host:port, *.domain (пусто — любые)")
+	geoipPath := flag.String("geoip", getEnv("GEOIP_CSV", ""), "CSV с диапазонами IP и кодами стран для алерта new_country")
+	healthStatusAge := flag.Duration("health-status-age", mustParseDuration(getEnv("HEALTH_STATUS_AGE", "5m")), "/health отвечает 503, если время Updated последнего снимка старше (0 — не проверять)")
+	healthCollectAge := flag.String("health-collect-age", getEnv("HEALTH_COLLECT_AGE", ""), "/health отвечает 503, если успешного сбора не было дольше (пусто — 3 × interval, но не меньше 1m; 0 — не проверять)")
+	retentionRaw := newRetentionFlag("retention-raw", "RETENTION_RAW", "7d", "срок хранения снимков и приращений (7d, 36h; 0 = всегда)")
+	retentionHourly := newRetentionFlag("retention-hourly", "RETENTION_HOURLY", "90d", "срок хранения почасовых данных, затем свёртка в дневные (0 = всегда)")
+	retentionDaily := newRetentionFlag("retention-daily", "RETENTION_DAILY", "0", "срок хранения дневных данных (0 = всегда)")
 	flag.Parse()
 
+	if *statusTZ != "" {
+		loc, err := time.LoadLocation(*statusTZ)
+		if err != nil {
+			log.Fatalf("STATUS_TZ: %v", err)
+		}
+		parser.Location = loc
+	}
+
 	db, err := database.New(*dbPath)
 	if err != nil {
 		log.Fatalf("БД: %v", err)
 	}
 	defer db.Close()
+	if err := db.SetRetention(database.RetentionPolicy{Raw: retentionRaw.d, Hourly: retentionHourly.d, Daily: retentionDaily.d}); err != nil {
+		log.Fatalf("Политика хранения: %v", err)
+	}
 
-	collect := func(path string) error {
-		data, err := os.ReadFile(path)
+	sources, err := parseSources(*statusPaths, *instance)
+	if err != nil {
+		log.Fatalf("Status-файлы: %v", err)
+	}
+	registry := collector.NewRegistry(db)
+	cols := make([]*collector.Collector, 0, len(sources))
+	runners := make([]collector.Source, 0, len(sources))
+	for _, src := range sources {
+		col, err := registry.AddLocal(src.name)
 		if err != nil {
-			return err
+			log.Fatalf("Status-файлы: %v", err)
 		}
-		status, err := parser.ParseBytes(data)
+		cols = append(cols, col)
+		if collector.IsManagementAddr(src.path) {
+			col.SetManagement(src.path, *managementPassword)
+			runners = append(runners, collector.ManagementSource{Addr: src.path, Password: *managementPassword, Interval: *interval, Bytecount: *bytecount, ClientAuth: *clientAuth})
+		} else {
+			runners = append(runners, collector.FileSource{Path: src.path, Options: collector.RunOptions{Interval: *interval, Watch: *watch, Debounce: *debounce}})
+		}
+	}
+	if *killManagement != "" {
+		mgmts, err := parseSources(*killManagement, *instance)
 		if err != nil {
-			return err
+			log.Fatalf("Management: %v", err)
+		}
+		for _, m := range mgmts {
+			col := registry.Get(m.name)
+			if col == nil {
+				log.Fatalf("Management: инстанс %q не собирается этим сервером", m.name)
+			}
+			col.SetManagement(m.path, *managementPassword)
+		}
+	}
+	// Без явного инстанса снимок относится к тому, чей это status-файл (иначе — к первому)
+	collect := func(instance, path string) (collector.Result, error) {
+		if instance == "" {
+			instance = sources[0].name
+			for _, src := range sources {
+				if src.path == path {
+					instance = src.name
+					break
+				}
+			}
+		}
+		col := registry.Get(instance)
+		if col == nil || col.Remote() {
+			return collector.Result{}, fmt.Errorf("неизвестный инстанс %q", instance)
+		}
+		return col.CollectFile(path)
+	}
+
+	var geo *geoip.DB
+	if *geoipPath != "" {
+		if geo, err = geoip.Load(*geoipPath); err != nil {
+			log.Fatalf("GeoIP: %v", err)
+		}
+		log.Printf("GeoIP: %d диапазонов", geo.Len())
+	}
+	// Квоты и алерты проверяются после каждого снимка, в том числе от агентов
+	webhooks := webhook.New(10*time.Second, *webhookSecret)
+	webhooks.SetAllowedHosts(splitPaths(*webhookHosts))
+	quotas := quota.New(db, registry, webhooks)
+	alertEngine := alerts.New(db, registry, webhooks, geo)
+	broker := events.NewBroker(eventsHistory)
+	publisher := events.NewPublisher(db, broker)
+	registry.OnSave(func(instance string, status *parser.Status) {
+		quotas.Check(instance, status)
+		alertEngine.OnSave(instance, status)
+		publisher.OnSave(instance, status)
+	})
+
+	maxCollectAge := 3 * *interval
+	if maxCollectAge < time.Minute {
+		maxCollectAge = time.Minute
+	}
+	if *healthCollectAge != "" {
+		if maxCollectAge, err = time.ParseDuration(*healthCollectAge); err != nil {
+			log.Fatalf("-health-collect-age: %v", err)
 		}
-		return db.SaveSnapshot(status)
 	}
 
 	h := api.New(db)
 	h.SetCollectFn(collect)
+	h.SetHealth(api.HealthConfig{MaxStatusAge: *healthStatusAge, MaxCollectAge: maxCollectAge})
+	h.SetCollectors(registry)
+	h.SetEvents(broker)
+	h.SetWebhooks(webhooks)
 
-	// Первичный сбор
-	if _, err := os.Stat(*statusPath); err == nil {
-		if err := collect(*statusPath); err != nil {
-			log.Printf("Первый сбор: %v", err)
+	// Первичный сбор (management-источник снимает status 3 сразу после подключения)
+	for i, src := range sources {
+		if collector.IsManagementAddr(src.path) {
+			continue
+		}
+		if _, err := os.Stat(src.path); err == nil {
+			if _, err := cols[i].CollectFile(src.path); err != nil {
+				log.Printf("Первый сбор [%s]: %v", src.name, err)
+			}
 		}
 	}
 
-	// Периодический сбор
+	// Периодический сбор, у каждого инстанса свой
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
+	for i, src := range runners {
+		go src.Run(ctx, cols[i])
+	}
+	go alertEngine.Run(ctx)
+
+	// Очистка по срокам хранения (со свёрткой почасовых данных в дневные): при старте и раз в час
 	go func() {
-		ticker := time.NewTicker(*interval)
+		ticker := time.NewTicker(time.Hour)
 		defer ticker.Stop()
 		for {
+			removed, err := db.ApplyRetention(time.Now())
+			if err != nil {
+				log.Printf("Очистка: %v", err)
+			}
+			for table, n := range removed {
+				if n > 0 {
+					log.Printf("Очистка: %s — удалено %d строк", table, n)
+				}
+			}
 			select {
 			case <-ctx.Done():
 				return
 			case <-ticker.C:
-				if _, err := os.Stat(*statusPath); err == nil {
-					if err := collect(*statusPath); err != nil {
-						log.Printf("Сбор: %v", err)
-					}
-					if *retention > 0 {
-						db.CleanupOldSnapshots(*retention)
-					}
-				}
 			}
 		}
 	}()
@@ -118,34 +320,91 @@ func main() {
 	allowedPaths := []string{"/var/log/openvpn"}
 	if p := getEnv("ALLOWED_PATHS", ""); p != "" {
 		allowedPaths = splitPaths(p)
-	} else if dir := filepath.Dir(*statusPath); dir != "." {
-		allowedPaths = append(allowedPaths, dir)
+	} else {
+		for _, src := range sources {
+			if collector.IsManagementAddr(src.path) {
+				continue
+			}
+			if dir := filepath.Dir(src.path); dir != "." {
+				allowedPaths = append(allowedPaths, dir)
+			}
+		}
 	}
 	h.SetAllowedPaths(allowedPaths)
 
 	gin.SetMode(gin.ReleaseMode)
 	r := gin.New()
 	r.Use(gin.Recovery(), api.SecurityHeaders())
-	r.Use(api.APIKeyAuth(apiKey))
+	pathKeys := map[string]string{}
+	if token := getEnv("METRICS_TOKEN", ""); token != "" {
+		pathKeys["/metrics"] = token
+	}
+	ingestToken := getEnv("INGEST_TOKEN", "")
+	if ingestToken != "" {
+		pathKeys["/ingest"] = ingestToken
+	}
+	r.Use(api.APIKeyAuth(apiKey, pathKeys))
 
-	r.GET("/health", func(c *gin.Context) { c.JSON(http.StatusOK, gin.H{"status": "ok"}) })
+	r.GET("/health", h.Health)
+	r.GET("/health/details", h.HealthDetails)
 	r.GET("/stats", h.GetStats)
+	r.GET("/metrics", h.GetMetrics)
 	r.GET("/users", h.GetUsers)
 	r.GET("/users/:name/traffic", h.GetUserTraffic)
 	r.GET("/users/:name/total", h.GetUserTotal)
+	r.GET("/users/:name/daily", h.GetUserDaily)
+	r.GET("/users/:name/sessions", h.GetUserSessions)
+	r.GET("/users/:name/quota", h.GetUserQuota)
+	r.GET("/sessions", h.GetSessions)
 	r.GET("/traffic", h.GetAllTraffic)
 	r.GET("/traffic/total", h.GetTotalTraffic)
 	r.GET("/traffic/daily", h.GetDailyTraffic)
+	r.GET("/traffic/hourly", h.GetHourlyTraffic)
+	r.GET("/traffic/top", h.GetTopTraffic)
 	r.GET("/connected", h.GetConnected)
+	r.GET("/events", h.Events)
+	r.GET("/routes", h.GetRoutes)
 	r.GET("/aliases", h.GetAliases)
 	r.PUT("/aliases", h.SetAlias)
 	r.POST("/collect", h.CollectNow)
+	r.GET("/alerts/history", h.GetAlertHistory)
+	r.GET("/quotas", h.GetQuotas)
+	r.GET("/quotas/groups", h.GetQuotaGroups)
+	r.GET("/quotas/:id", h.GetQuota)
+	// Приём снимков от агентов только с авторизацией: без ключей любой мог бы писать в БД
+	if apiKey != "" || ingestToken != "" {
+		r.POST("/ingest", h.Ingest)
+	} else {
+		log.Printf("POST /ingest отключён: задайте INGEST_TOKEN или API_KEY")
+	}
+	// Управляющие маршруты только с API_KEY: без ключа любой, кто видит порт, отключал бы пользователей VPN
+	// (kill, квота с action disconnect), заставлял бы сервер слать POST на свои адреса (webhook_url),
+	// останавливал бы сбор пересчётом агрегатов и читал бы журнал отключений с адресами клиентов
+	if apiKey != "" {
+		r.POST("/connected/:name/kill", h.KillClient)
+		r.GET("/admin/kills", h.GetKillLog)
+		r.POST("/admin/rebuild", h.Rebuild)
+		r.POST("/quotas", h.CreateQuota)
+		r.PUT("/quotas/groups/:group", h.SetQuotaGroup)
+		r.PUT("/quotas/:id", h.UpdateQuota)
+		r.DELETE("/quotas/:id", h.DeleteQuota)
+		r.GET("/alerts/rules", h.GetAlertRules)
+		r.POST("/alerts/rules", h.CreateAlertRule)
+		r.GET("/alerts/rules/:id", h.GetAlertRule)
+		r.PUT("/alerts/rules/:id", h.UpdateAlertRule)
+		r.DELETE("/alerts/rules/:id", h.DeleteAlertRule)
+	} else {
+		log.Printf("Kill, изменение квот, /alerts/rules, /admin/kills и /admin/rebuild отключены: задайте API_KEY")
+	}
+	r.GET("/admin/retention", h.GetRetention)
 
 	srv := &http.Server{
 		Addr:              *addr,
 		Handler:           r,
 		ReadHeaderTimeout: 5 * time.Second,
 	}
+	// Потоки /events открыты бесконечно: без этого Shutdown ждал бы их до таймаута
+	srv.RegisterOnShutdown(broker.Close)
 	go func() {
 		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			log.Fatalf("Сервер: %v", err)
@@ -153,7 +412,9 @@ func main() {
 	}()
 
 	fmt.Printf("Сервер: http://localhost%s\n", *addr)
-	fmt.Printf("Status-файл: %s (обновление каждые %s)\n", *statusPath, *interval)
+	for i, src := range runners {
+		fmt.Printf("Источник [%s]: %s\n", sources[i].name, src)
+	}
 	<-ctx.Done()
 
 	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestParseSources(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{"один путь", "/var/log/openvpn/status.log", "[{default /var/log/openvpn/status.log}]", false},
+		{"список", "udp1=/var/log/openvpn/udp.log, tcp1 = tcp://127.0.0.1:7505", "[{udp1 /var/log/openvpn/udp.log} {tcp1 tcp://127.0.0.1:7505}]", false},
+		{"лишние запятые", ",udp1=/a.log,,", "[{udp1 /a.log}]", false},
+		{"повтор имени", "udp1=/a.log,udp1=/b.log", "", true},
+		{"повтор имени по умолчанию", "/a.log,/b.log", "", true},
+		{"без пути", "udp1=", "", true},
+		{"без имени", "=/a.log", "", true},
+		{"пусто", " , ", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseSources(tt.in, "default")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ошибка %v, ожидалась %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && fmt.Sprint(got) != tt.want {
+				t.Errorf("%v, ожидалось %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+
+	"open-statistic/internal/api"
+	"open-statistic/internal/database"
+)
+
+// runRebuild — openstat rebuild: пересчёт агрегатов по сохранённым снимкам (как POST /admin/rebuild)
+func runRebuild(args []string) int {
+	flags := flag.NewFlagSet("rebuild", flag.ExitOnError)
+	dbPath := flags.String("db", getEnv("DB_PATH", "./openstat.db"), "путь к SQLite БД")
+	instance := flags.String("instance", "", "пересчитать только этот инстанс (по умолчанию все)")
+	dryRun := flags.Bool("dry-run", false, "только показать расхождения, ничего не меняя")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Использование: openstat rebuild [-db path] [-instance name] [-dry-run]")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	db, err := database.New(*dbPath)
+	if err != nil {
+		log.Printf("БД: %v", err)
+		return 1
+	}
+	defer db.Close()
+
+	report, err := db.Rebuild(database.RebuildOptions{
+		Instance: *instance,
+		DryRun:   *dryRun,
+		Progress: func(instance string, done, total int) {
+			log.Printf("Пересчёт [%s]: %d из %d снимков", instance, done, total)
+		},
+	})
+	if err != nil {
+		log.Printf("Пересчёт: %v", err)
+		return 1
+	}
+	for _, ri := range report.Instances {
+		fmt.Printf("[%s] снимков %d (%s — %s), заменено приращений %d\n", ri.Instance, ri.Snapshots,
+			ri.From.Format("2006-01-02 15:04:05"), ri.To.Format("2006-01-02 15:04:05"), ri.DeltasRemoved)
+	}
+	for _, u := range report.Users {
+		fmt.Printf("  %s/%s: получено %s → %s, отправлено %s → %s\n", u.Instance, u.CommonName,
+			api.FormatBytes(u.BytesReceivedBefore), api.FormatBytes(u.BytesReceivedAfter),
+			api.FormatBytes(u.BytesSentBefore), api.FormatBytes(u.BytesSentAfter))
+	}
+	fmt.Printf("Изменилось пользователей: %d, дней: %d\n", len(report.Users), len(report.Days))
+	if report.DryRun {
+		fmt.Println("Пробный запуск: изменения не сохранены")
+	}
+	return 0
+}
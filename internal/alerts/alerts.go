@@ -0,0 +1,367 @@
+// Package alerts — правила алертов, которые проверяются после каждого снимка и по таймеру,
+// и отправка сработавших алертов webhook-ами. Состояние (дедупликация, cooldown) хранится в SQLite.
+package alerts
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"open-statistic/internal/collector"
+	"open-statistic/internal/database"
+	"open-statistic/internal/geoip"
+	"open-statistic/internal/parser"
+	"open-statistic/internal/webhook"
+)
+
+// checkInterval период проверки правил, которые срабатывают без новых снимков (stale_status)
+const checkInterval = 30 * time.Second
+
+// firing одно срабатывание правила: ключ дедупликации и подробности
+type firing struct {
+	key        string
+	instance   string
+	commonName string
+	message    string
+	value      int64
+}
+
+// countryEvent подключение пользователя из новой для него страны
+type countryEvent struct {
+	instance   string
+	commonName string
+	country    string
+	address    string
+}
+
+// Engine проверяет правила и отправляет алерты
+type Engine struct {
+	db         *database.DB
+	collectors *collector.Registry
+	webhooks   *webhook.Sender
+	geo        *geoip.DB
+	started    time.Time
+
+	mu        sync.Mutex // проверки выполняются по одной: состояние читается и пишется без гонок
+	connected map[string]int
+}
+
+// New создаёт движок алертов. geo — база стран для new_country (nil — правило не срабатывает)
+func New(db *database.DB, collectors *collector.Registry, webhooks *webhook.Sender, geo *geoip.DB) *Engine {
+	return &Engine{
+		db:         db,
+		collectors: collectors,
+		webhooks:   webhooks,
+		geo:        geo,
+		started:    time.Now(),
+		connected:  make(map[string]int),
+	}
+}
+
+// Run проверяет правила по таймеру до отмены ctx
+func (e *Engine) Run(ctx context.Context) {
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			e.evaluate("", nil)
+		}
+	}
+}
+
+// OnSave проверяет правила после сохранения снимка инстанса (collector.SaveHook)
+func (e *Engine) OnSave(instance string, status *parser.Status) {
+	e.evaluate(instance, status)
+}
+
+// evaluate проверяет все включённые правила. status — только что сохранённый снимок инстанса
+// (nil — проверка по таймеру: правила, которым нужен снимок, пропускаются)
+func (e *Engine) evaluate(instance string, status *parser.Status) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	rules, err := e.db.GetAlertRules(true)
+	if err != nil {
+		log.Printf("Алерты: %v", err)
+		return
+	}
+	now := time.Now().UTC()
+
+	var prevConnected, connected int
+	var countries []countryEvent
+	if status != nil {
+		for _, cl := range status.Clients {
+			if parser.ValidCommonName(cl.CommonName) {
+				connected++
+			}
+		}
+		prevConnected = e.connected[instance]
+		e.connected[instance] = connected
+		if hasType(rules, database.AlertNewCountry) {
+			countries = e.newCountries(instance, status, now)
+		}
+	}
+
+	for _, r := range rules {
+		if r.Instance != "" && status != nil && r.Instance != instance {
+			continue
+		}
+		var fired []firing
+		// scope — ключи, которые эта проверка могла бы выставить: остальные не сбрасываются
+		var scope func(key string) bool
+		switch r.Type {
+		case database.AlertUserDailyBytes:
+			if status == nil {
+				continue
+			}
+			fired, err = e.userDailyBytes(r, now)
+		case database.AlertConcurrentSessions:
+			if status == nil {
+				continue
+			}
+			fired, err = e.concurrentSessions(r)
+		case database.AlertNewCountry:
+			for _, c := range countries {
+				if r.CommonName == "" || r.CommonName == c.commonName {
+					fired = append(fired, firing{
+						key:        c.commonName + "|" + c.country,
+						instance:   c.instance,
+						commonName: c.commonName,
+						message:    fmt.Sprintf("%s подключился из новой страны %s (%s)", c.commonName, c.country, c.address),
+					})
+				}
+			}
+			scope = func(string) bool { return false }
+		case database.AlertStaleStatus:
+			fired = e.staleStatus(r, now)
+		case database.AlertZeroConnected:
+			if status == nil {
+				continue
+			}
+			if connected == 0 {
+				// Срабатывает при падении до нуля; пока подключённых нет, активный алерт повторяется раз в cooldown
+				still := false
+				if prevConnected == 0 {
+					var states map[string]database.AlertState
+					states, err = e.db.GetAlertStates(r.ID)
+					still = states[instance].Active
+				}
+				if prevConnected > 0 || still {
+					fired = append(fired, firing{
+						key:      instance,
+						instance: instance,
+						message:  fmt.Sprintf("на инстансе %s нет подключённых клиентов", instance),
+						value:    int64(prevConnected),
+					})
+				}
+			}
+			scope = func(key string) bool { return key == instance }
+		}
+		if err != nil {
+			log.Printf("Алерт %d (%s): %v", r.ID, r.Name, err)
+			continue
+		}
+		if err := e.apply(r, fired, scope, now); err != nil {
+			log.Printf("Алерт %d (%s): %v", r.ID, r.Name, err)
+		}
+	}
+}
+
+// apply отправляет срабатывания с учётом состояния и cooldown и сбрасывает ключи,
+// которые перестали срабатывать
+func (e *Engine) apply(r database.AlertRule, fired []firing, scope func(key string) bool, now time.Time) error {
+	states, err := e.db.GetAlertStates(r.ID)
+	if err != nil {
+		return err
+	}
+	active := make(map[string]bool, len(fired))
+	for _, f := range fired {
+		active[f.key] = true
+		st := states[f.key]
+		cooldown := r.Cooldown()
+		send := st.LastFiredAt.IsZero() ||
+			(cooldown > 0 && now.Sub(st.LastFiredAt) >= cooldown) ||
+			(cooldown == 0 && !st.Active)
+		if !send {
+			if !st.Active {
+				if err := e.db.SetAlertState(r.ID, database.AlertState{Key: f.key, Active: true}); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if err := e.fire(r, f, now); err != nil {
+			return err
+		}
+		if err := e.db.SetAlertState(r.ID, database.AlertState{Key: f.key, Active: true, LastFiredAt: now}); err != nil {
+			return err
+		}
+	}
+	for key, st := range states {
+		if st.Active && !active[key] && (scope == nil || scope(key)) {
+			if err := e.db.SetAlertState(r.ID, database.AlertState{Key: key, Active: false}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// fire записывает алерт в историю и отправляет webhook; итог доставки пишется в историю
+func (e *Engine) fire(r database.AlertRule, f firing, now time.Time) error {
+	ev := &database.AlertEvent{
+		RuleID:     r.ID,
+		RuleName:   r.Name,
+		Type:       r.Type,
+		Key:        f.key,
+		Instance:   f.instance,
+		CommonName: f.commonName,
+		Message:    f.message,
+		Value:      f.value,
+		Threshold:  r.Threshold,
+		FiredAt:    now,
+	}
+	if err := e.db.AddAlertEvent(ev); err != nil {
+		return err
+	}
+	log.Printf("Алерт %d (%s): %s", r.ID, r.Name, f.message)
+	if e.webhooks == nil {
+		return nil
+	}
+	payload := *ev
+	payload.Delivery = ""
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		defer cancel()
+		err := e.webhooks.Post(ctx, r.WebhookURL, struct {
+			Event string `json:"event"`
+			database.AlertEvent
+		}{"alert", payload})
+		if err != nil {
+			log.Printf("Алерт %d (%s): webhook: %v", r.ID, r.Name, err)
+		}
+		if err := e.db.SetAlertDelivery(ev.ID, err); err != nil {
+			log.Printf("Алерт %d: история: %v", r.ID, err)
+		}
+	}()
+	return nil
+}
+
+func (e *Engine) userDailyBytes(r database.AlertRule, now time.Time) ([]firing, error) {
+	users, err := e.db.UsersOverDailyBytes(now, r.Threshold, r.CommonName, database.Filter{Instance: r.Instance})
+	if err != nil {
+		return nil, err
+	}
+	day := now.Format("2006-01-02")
+	fired := make([]firing, 0, len(users))
+	for _, u := range users {
+		fired = append(fired, firing{
+			key:        u.CommonName + "|" + day,
+			instance:   r.Instance,
+			commonName: u.CommonName,
+			message:    fmt.Sprintf("%s: %d байт за %s (порог %d)", u.CommonName, u.TotalBytes, day, r.Threshold),
+			value:      u.TotalBytes,
+		})
+	}
+	return fired, nil
+}
+
+func (e *Engine) concurrentSessions(r database.AlertRule) ([]firing, error) {
+	clients, err := e.db.GetLatestSnapshot(database.Filter{Instance: r.Instance})
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int64)
+	var order []string
+	for _, cl := range clients {
+		if r.CommonName != "" && cl.CommonName != r.CommonName {
+			continue
+		}
+		if counts[cl.CommonName] == 0 {
+			order = append(order, cl.CommonName)
+		}
+		counts[cl.CommonName]++
+	}
+	var fired []firing
+	for _, cn := range order {
+		if n := counts[cn]; n > r.Threshold {
+			fired = append(fired, firing{
+				key:        cn,
+				instance:   r.Instance,
+				commonName: cn,
+				message:    fmt.Sprintf("%s: %d одновременных сессий (порог %d)", cn, n, r.Threshold),
+				value:      n,
+			})
+		}
+	}
+	return fired, nil
+}
+
+// staleStatus инстансы, чей последний снимок старше порога. Пока снимков не было,
+// отсчёт идёт от запуска сервера
+func (e *Engine) staleStatus(r database.AlertRule, now time.Time) []firing {
+	limit := time.Duration(r.Threshold) * time.Second
+	var fired []firing
+	for _, col := range e.collectors.All() {
+		if r.Instance != "" && col.Instance() != r.Instance {
+			continue
+		}
+		last := col.Stats().LastStatusAt
+		if last.IsZero() {
+			if col.Remote() {
+				continue
+			}
+			last = e.started
+		}
+		if age := now.Sub(last); age > limit {
+			fired = append(fired, firing{
+				key:      col.Instance(),
+				instance: col.Instance(),
+				message:  fmt.Sprintf("снимок инстанса %s не обновлялся %s", col.Instance(), age.Truncate(time.Second)),
+				value:    int64(age.Seconds()),
+			})
+		}
+	}
+	return fired
+}
+
+// newCountries запоминает страны подключений из снимка и возвращает новые для пользователя
+func (e *Engine) newCountries(instance string, status *parser.Status, now time.Time) []countryEvent {
+	if e.geo == nil {
+		return nil
+	}
+	var events []countryEvent
+	for _, cl := range status.Clients {
+		ip, ok := parser.RealIP(cl.RealAddress)
+		if !ok {
+			continue
+		}
+		country := e.geo.Country(ip)
+		if country == "" {
+			continue
+		}
+		isNew, known, err := e.db.RecordUserCountry(cl.CommonName, country, now)
+		if err != nil {
+			log.Printf("Алерты: страна %s: %v", cl.CommonName, err)
+			continue
+		}
+		if isNew && known {
+			events = append(events, countryEvent{instance: instance, commonName: cl.CommonName, country: country, address: cl.RealAddress})
+		}
+	}
+	return events
+}
+
+func hasType(rules []database.AlertRule, typ string) bool {
+	for _, r := range rules {
+		if r.Type == typ {
+			return true
+		}
+	}
+	return false
+}
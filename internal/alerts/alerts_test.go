@@ -0,0 +1,144 @@
+package alerts
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"open-statistic/internal/collector"
+	"open-statistic/internal/database"
+	"open-statistic/internal/parser"
+)
+
+func newTestEngine(t *testing.T) (*Engine, *database.DB) {
+	t.Helper()
+	db, err := database.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return New(db, collector.NewRegistry(db), nil, nil), db
+}
+
+func newRule(t *testing.T, db *database.DB, typ string, cooldown int64) database.AlertRule {
+	t.Helper()
+	r := database.AlertRule{Name: typ, Type: typ, Threshold: 1, WebhookURL: "http://127.0.0.1:1/hook", CooldownSeconds: cooldown, Enabled: true}
+	if err := db.CreateAlertRule(&r); err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func history(t *testing.T, db *database.DB, ruleID int64) int {
+	t.Helper()
+	events, err := db.GetAlertHistory(ruleID, database.Filter{}, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(events)
+}
+
+// TestApply повтор уведомлений: без cooldown — после сброса состояния, с cooldown — не чаще
+// раза в cooldown; ключи вне scope не сбрасываются
+func TestApply(t *testing.T) {
+	type step struct {
+		at    time.Duration // от начала
+		keys  []string      // сработавшие ключи
+		scope []string      // ключи, которые проверка могла выставить; nil — все
+		sent  int           // всего уведомлений после шага
+	}
+	tests := []struct {
+		name     string
+		cooldown int64
+		steps    []step
+	}{
+		{"без cooldown", 0, []step{
+			{0, []string{"a"}, nil, 1},
+			{time.Minute, []string{"a"}, nil, 1},
+			{2 * time.Minute, []string{"a", "b"}, nil, 2},
+			{3 * time.Minute, nil, nil, 2},
+			{4 * time.Minute, []string{"a"}, nil, 3},
+		}},
+		{"cooldown минута", 60, []step{
+			{0, []string{"a"}, nil, 1},
+			{30 * time.Second, []string{"a"}, nil, 1},
+			{time.Minute, []string{"a"}, nil, 2},
+			// Сброс и новое срабатывание раньше cooldown не уведомляют
+			{70 * time.Second, nil, nil, 2},
+			{80 * time.Second, []string{"a"}, nil, 2},
+			{2 * time.Minute, []string{"a"}, nil, 3},
+		}},
+		{"вне scope", 0, []step{
+			{0, []string{"a"}, nil, 1},
+			{time.Minute, nil, []string{"b"}, 1},
+			{2 * time.Minute, []string{"a"}, nil, 1},
+			{3 * time.Minute, nil, []string{"a"}, 1},
+			{4 * time.Minute, []string{"a"}, nil, 2},
+		}},
+	}
+	start := time.Date(2024, 2, 23, 12, 0, 0, 0, time.UTC)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, db := newTestEngine(t)
+			r := newRule(t, db, database.AlertStaleStatus, tt.cooldown)
+			for i, s := range tt.steps {
+				fired := make([]firing, 0, len(s.keys))
+				for _, k := range s.keys {
+					fired = append(fired, firing{key: k, instance: k, message: "test " + k})
+				}
+				var scope func(string) bool
+				if s.scope != nil {
+					scope = func(key string) bool {
+						for _, k := range s.scope {
+							if k == key {
+								return true
+							}
+						}
+						return false
+					}
+				}
+				if err := e.apply(r, fired, scope, start.Add(s.at)); err != nil {
+					t.Fatal(err)
+				}
+				if n := history(t, db, r.ID); n != s.sent {
+					t.Errorf("шаг %d: уведомлений %d, ожидалось %d", i+1, n, s.sent)
+				}
+				states, err := db.GetAlertStates(r.ID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, k := range s.keys {
+					if !states[k].Active {
+						t.Errorf("шаг %d: ключ %s не активен", i+1, k)
+					}
+				}
+			}
+		})
+	}
+}
+
+// TestZeroConnected алерт при падении числа клиентов до нуля; клиенты UNDEF не считаются
+func TestZeroConnected(t *testing.T) {
+	e, db := newTestEngine(t)
+	r := newRule(t, db, database.AlertZeroConnected, 0)
+	alice := parser.Client{CommonName: "alice", RealAddress: "1.1.1.1:1000"}
+	undef := parser.Client{CommonName: "UNDEF", RealAddress: "2.2.2.2:2000"}
+
+	steps := []struct {
+		clients []parser.Client
+		sent    int
+	}{
+		{nil, 0}, // до первого подключения не срабатывает
+		{[]parser.Client{alice}, 0},
+		{[]parser.Client{undef}, 1},
+		{nil, 1},
+		{[]parser.Client{alice}, 1},
+		{nil, 2},
+	}
+	for i, s := range steps {
+		e.OnSave("vpn1", &parser.Status{Clients: s.clients})
+		if n := history(t, db, r.ID); n != s.sent {
+			t.Errorf("шаг %d: уведомлений %d, ожидалось %d", i+1, n, s.sent)
+		}
+	}
+}
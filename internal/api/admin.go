@@ -0,0 +1,78 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"open-statistic/internal/database"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetRetention godoc
+// @Summary Политика хранения и что будет удалено при следующей очистке
+// @Tags admin
+// @Produce json
+// @Success 200 {array} database.RetentionTable
+// @Router /admin/retention [get]
+func (h *Handler) GetRetention(c *gin.Context) {
+	tables, err := h.db.RetentionPreview(time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	p := h.db.Retention()
+	c.JSON(http.StatusOK, gin.H{
+		"policy": gin.H{
+			"raw":    formatRetention(p.Raw),
+			"hourly": formatRetention(p.Hourly),
+			"daily":  formatRetention(p.Daily),
+		},
+		"tables": tables,
+	})
+}
+
+// Rebuild godoc
+// @Summary Пересчитать накопленный, дневной и почасовой трафик по сохранённым снимкам
+// @Tags admin
+// @Param instance query string false "Имя инстанса (по умолчанию все)"
+// @Param dry_run query bool false "Только показать расхождения, ничего не меняя"
+// @Produce json
+// @Success 200 {object} database.RebuildReport
+// @Failure 409 {object} map[string]string
+// @Router /admin/rebuild [post]
+func (h *Handler) Rebuild(c *gin.Context) {
+	dryRun, err := parseBoolParam(c.Query("dry_run"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	opts := database.RebuildOptions{Instance: c.Query("instance"), DryRun: dryRun != nil && *dryRun}
+	opts.Progress = func(instance string, done, total int) {
+		log.Printf("Пересчёт [%s]: %d из %d снимков", instance, done, total)
+	}
+	report, err := h.db.Rebuild(opts)
+	if errors.Is(err, database.ErrRebuildRunning) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// formatRetention срок хранения в том же виде, что и в конфиге: 7d, 36h0m0s, forever
+func formatRetention(d time.Duration) string {
+	if d <= 0 {
+		return "forever"
+	}
+	if d%(24*time.Hour) == 0 {
+		return fmt.Sprintf("%dd", d/(24*time.Hour))
+	}
+	return d.String()
+}
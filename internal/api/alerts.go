@@ -0,0 +1,219 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"open-statistic/internal/database"
+	"open-statistic/internal/webhook"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultAlertCooldown cooldown правила, если он не указан
+const defaultAlertCooldown = 3600
+
+// AlertRuleRequest тело POST/PUT /alerts/rules
+type AlertRuleRequest struct {
+	Name            string `json:"name"`
+	Type            string `json:"type"` // user_daily_bytes, concurrent_sessions, new_country, stale_status, zero_connected
+	CommonName      string `json:"common_name"`
+	Instance        string `json:"instance"`
+	Threshold       int64  `json:"threshold"` // байты, число сессий или секунды — зависит от type
+	WebhookURL      string `json:"webhook_url"`
+	CooldownSeconds *int64 `json:"cooldown_seconds"` // по умолчанию 3600
+	Enabled         *bool  `json:"enabled"`          // по умолчанию true
+}
+
+func (r AlertRuleRequest) rule() database.AlertRule {
+	rule := database.AlertRule{
+		Name:            r.Name,
+		Type:            r.Type,
+		CommonName:      r.CommonName,
+		Instance:        r.Instance,
+		Threshold:       r.Threshold,
+		WebhookURL:      r.WebhookURL,
+		CooldownSeconds: defaultAlertCooldown,
+		Enabled:         true,
+	}
+	if r.CooldownSeconds != nil {
+		rule.CooldownSeconds = *r.CooldownSeconds
+	}
+	if r.Enabled != nil {
+		rule.Enabled = *r.Enabled
+	}
+	return rule
+}
+
+// SetWebhooks подключает отправителя webhook-ов: по нему проверяются webhook_url квот и алертов
+func (h *Handler) SetWebhooks(s *webhook.Sender) {
+	h.webhooks = s
+}
+
+// checkWebhook отвечает 400, если webhook_url не разрешён (WEBHOOK_ALLOWED_HOSTS)
+func (h *Handler) checkWebhook(c *gin.Context, rawURL string) bool {
+	if h.webhooks == nil || rawURL == "" {
+		return true
+	}
+	if err := h.webhooks.CheckURL(rawURL); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return false
+	}
+	return true
+}
+
+// GetAlertRules godoc
+// @Summary Правила алертов
+// @Tags alerts
+// @Produce json
+// @Success 200 {array} database.AlertRule
+// @Router /alerts/rules [get]
+func (h *Handler) GetAlertRules(c *gin.Context) {
+	rules, err := h.db.GetAlertRules(false)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"rules": rules})
+}
+
+// GetAlertRule godoc
+// @Summary Правило алерта по id
+// @Tags alerts
+// @Param id path int true "id правила"
+// @Produce json
+// @Success 200 {object} database.AlertRule
+// @Router /alerts/rules/{id} [get]
+func (h *Handler) GetAlertRule(c *gin.Context) {
+	id, ok := pathID(c)
+	if !ok {
+		return
+	}
+	r, err := h.db.GetAlertRule(id)
+	if err != nil {
+		alertRuleError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, r)
+}
+
+// CreateAlertRule godoc
+// @Summary Создать правило алерта
+// @Tags alerts
+// @Param body body AlertRuleRequest true "Правило"
+// @Produce json
+// @Success 201 {object} database.AlertRule
+// @Router /alerts/rules [post]
+func (h *Handler) CreateAlertRule(c *gin.Context) {
+	var req AlertRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body"})
+		return
+	}
+	if !h.checkWebhook(c, req.WebhookURL) {
+		return
+	}
+	r := req.rule()
+	if err := h.db.CreateAlertRule(&r); err != nil {
+		alertRuleError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, r)
+}
+
+// UpdateAlertRule godoc
+// @Summary Изменить правило алерта (состояние срабатываний сбрасывается)
+// @Tags alerts
+// @Param id path int true "id правила"
+// @Param body body AlertRuleRequest true "Правило целиком"
+// @Produce json
+// @Success 200 {object} database.AlertRule
+// @Router /alerts/rules/{id} [put]
+func (h *Handler) UpdateAlertRule(c *gin.Context) {
+	id, ok := pathID(c)
+	if !ok {
+		return
+	}
+	var req AlertRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body"})
+		return
+	}
+	if !h.checkWebhook(c, req.WebhookURL) {
+		return
+	}
+	r := req.rule()
+	r.ID = id
+	if err := h.db.UpdateAlertRule(&r); err != nil {
+		alertRuleError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, r)
+}
+
+// DeleteAlertRule godoc
+// @Summary Удалить правило алерта (история остаётся)
+// @Tags alerts
+// @Param id path int true "id правила"
+// @Router /alerts/rules/{id} [delete]
+func (h *Handler) DeleteAlertRule(c *gin.Context) {
+	id, ok := pathID(c)
+	if !ok {
+		return
+	}
+	if err := h.db.DeleteAlertRule(id); err != nil {
+		alertRuleError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// GetAlertHistory godoc
+// @Summary Сработавшие алерты и статус доставки webhook-ов
+// @Tags alerts
+// @Param rule_id query int false "id правила"
+// @Param instance query string false "Имя инстанса"
+// @Param from query string false "RFC 3339 или YYYY-MM-DD"
+// @Param to query string false "RFC 3339 или YYYY-MM-DD"
+// @Param limit query int false "По умолчанию 100, максимум 1000"
+// @Produce json
+// @Success 200 {array} database.AlertEvent
+// @Router /alerts/history [get]
+func (h *Handler) GetAlertHistory(c *gin.Context) {
+	f, err := parseRange(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var ruleID int64
+	if s := c.Query("rule_id"); s != "" {
+		if ruleID, err = strconv.ParseInt(s, 10, 64); err != nil || ruleID <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "неверный rule_id"})
+			return
+		}
+	}
+	limit, err := parseLimitParam(c.Query("limit"), 100, 1000)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	events, err := h.db.GetAlertHistory(ruleID, f, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"alerts": events})
+}
+
+// alertRuleError ответ на ошибку правил: не найдено — 404, не прошло проверку — 400
+func alertRuleError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, database.ErrAlertRuleNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, database.ErrInvalidAlertRule):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
@@ -10,28 +10,60 @@ import (
 const (
 	headerAPIKey = "X-API-Key"
 	headerAuth   = "Authorization"
+	ctxAuthName  = "auth_name"
 )
 
 // APIKeyAuth middleware — если API_KEY задан, требует X-API-Key или Authorization: Bearer <key>. /health всегда доступен.
-func APIKeyAuth(apiKey string) gin.HandlerFunc {
-	if apiKey == "" {
+// pathKeys — отдельные ключи для путей (например, токен Prometheus для /metrics): на таком пути
+// принимается и общий ключ, и ключ пути; ключ пути требуется, даже если API_KEY не задан.
+func APIKeyAuth(apiKey string, pathKeys map[string]string) gin.HandlerFunc {
+	if apiKey == "" && len(pathKeys) == 0 {
 		return func(c *gin.Context) { c.Next() }
 	}
 	return func(c *gin.Context) {
-		if c.Request.URL.Path == "/health" {
+		path := c.Request.URL.Path
+		if path == "/health" {
 			c.Next()
 			return
 		}
-		key := c.GetHeader(headerAPIKey)
-		if key == "" {
-			if auth := c.GetHeader(headerAuth); strings.HasPrefix(auth, "Bearer ") {
-				key = strings.TrimPrefix(auth, "Bearer ")
-			}
+		pathKey := pathKeys[path]
+		if apiKey == "" && pathKey == "" {
+			c.Next()
+			return
 		}
-		if key != apiKey {
+		key := requestKey(c)
+		switch {
+		case key == "":
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		case key == apiKey:
+			c.Set(ctxAuthName, "api_key")
+		case key == pathKey:
+			c.Set(ctxAuthName, "token:"+path)
+		default:
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
 			return
 		}
 		c.Next()
 	}
 }
+
+// AuthName каким ключом авторизован запрос: "api_key" — общий, "token:<путь>" — ключ пути;
+// пусто — ключ не требовался
+func AuthName(c *gin.Context) string {
+	return c.GetString(ctxAuthName)
+}
+
+func requestKey(c *gin.Context) string {
+	key := c.GetHeader(headerAPIKey)
+	if key == "" {
+		if auth := c.GetHeader(headerAuth); strings.HasPrefix(auth, "Bearer ") {
+			key = strings.TrimPrefix(auth, "Bearer ")
+		}
+	}
+	// EventSource в браузере не умеет передавать заголовки
+	if key == "" && c.Request.URL.Path == "/events" {
+		key = c.Query("api_key")
+	}
+	return key
+}
@@ -0,0 +1,153 @@
+package api
+
+import (
+	"net/http"
+	"sort"
+
+	"open-statistic/internal/database"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetUserDaily godoc
+// @Summary Трафик пользователя по дням
+// @Tags users
+// @Param name path string true "Common Name пользователя"
+// @Param from query string false "RFC 3339 или YYYY-MM-DD (по умолчанию — последние 30 дней)"
+// @Param to query string false "RFC 3339 или YYYY-MM-DD"
+// @Produce json
+// @Success 200 {array} database.DailyTraffic
+// @Router /users/{name}/daily [get]
+func (h *Handler) GetUserDaily(c *gin.Context) {
+	name := c.Param("name")
+	if name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "имя пользователя обязательно"})
+		return
+	}
+	f, err := parseRange(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	list, err := h.db.GetUserDailyTraffic(name, f)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"common_name": name, "days": dailyItems(list, c.Query("human") == "1")})
+}
+
+// getDailyByUser матрица для /traffic/daily?by=user: список дней и по каждому пользователю
+// массивы значений, выровненные по этим дням (удобно для выгрузки в таблицу)
+func (h *Handler) getDailyByUser(c *gin.Context, f database.Filter) {
+	list, err := h.db.GetDailyTrafficByUser(f)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	dayIndex := make(map[string]int)
+	days := make([]string, 0, 31)
+	for _, d := range list {
+		if _, ok := dayIndex[d.Day]; !ok {
+			dayIndex[d.Day] = 0
+			days = append(days, d.Day)
+		}
+	}
+	sort.Strings(days)
+	for i, d := range days {
+		dayIndex[d] = i
+	}
+
+	type row struct {
+		name           string
+		received, sent []int64
+		totalR, totalS int64
+	}
+	var rows []*row
+	byName := make(map[string]*row)
+	for _, d := range list {
+		r, ok := byName[d.CommonName]
+		if !ok {
+			r = &row{name: d.CommonName, received: make([]int64, len(days)), sent: make([]int64, len(days))}
+			byName[d.CommonName] = r
+			rows = append(rows, r)
+		}
+		i := dayIndex[d.Day]
+		r.received[i] += d.BytesReceived
+		r.sent[i] += d.BytesSent
+		r.totalR += d.BytesReceived
+		r.totalS += d.BytesSent
+	}
+
+	aliases := h.db.LoadAllAliases()
+	human := c.Query("human") == "1"
+	users := make([]gin.H, 0, len(rows))
+	for _, r := range rows {
+		total := make([]int64, len(days))
+		for i := range days {
+			total[i] = r.received[i] + r.sent[i]
+		}
+		item := gin.H{"common_name": r.name}
+		if human {
+			item["bytes_received"] = formatBytesSlice(r.received)
+			item["bytes_sent"] = formatBytesSlice(r.sent)
+			item["total_bytes"] = formatBytesSlice(total)
+			item["period_total_bytes"] = FormatBytes(r.totalR + r.totalS)
+		} else {
+			item["bytes_received"] = r.received
+			item["bytes_sent"] = r.sent
+			item["total_bytes"] = total
+			item["period_total_bytes"] = r.totalR + r.totalS
+		}
+		if a, ok := aliases[r.name]; ok {
+			item["alias"] = a
+		}
+		users = append(users, item)
+	}
+	c.JSON(http.StatusOK, gin.H{"days": days, "users": users})
+}
+
+// exportDailyByUser выгрузка матрицы пользователь × день построчно: одна строка на пользователя и день
+func (h *Handler) exportDailyByUser(c *gin.Context, f database.Filter, format string) {
+	list, err := h.db.GetDailyTrafficByUser(f)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	aliases := h.db.LoadAllAliases()
+	writeExport(c, format, "traffic-daily-users", []string{"day", "common_name", "alias", "bytes_received", "bytes_sent", "total_bytes"}, len(list), func(i int) []interface{} {
+		d := list[i]
+		return []interface{}{d.Day, d.CommonName, aliases[d.CommonName], d.BytesReceived, d.BytesSent, d.TotalBytes}
+	})
+}
+
+func dailyItems(list []database.DailyTraffic, human bool) []gin.H {
+	out := make([]gin.H, 0, len(list))
+	for _, d := range list {
+		if human {
+			out = append(out, gin.H{
+				"day":            d.Day,
+				"bytes_received": FormatBytes(d.BytesReceived),
+				"bytes_sent":     FormatBytes(d.BytesSent),
+				"total_bytes":    FormatBytes(d.TotalBytes),
+			})
+			continue
+		}
+		out = append(out, gin.H{
+			"day":            d.Day,
+			"bytes_received": d.BytesReceived,
+			"bytes_sent":     d.BytesSent,
+			"total_bytes":    d.TotalBytes,
+		})
+	}
+	return out
+}
+
+func formatBytesSlice(values []int64) []string {
+	out := make([]string, len(values))
+	for i, v := range values {
+		out[i] = FormatBytes(v)
+	}
+	return out
+}
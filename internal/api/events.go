@@ -0,0 +1,124 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"open-statistic/internal/events"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// eventsBuffer сколько событий ждёт отправки клиенту, прежде чем он будет отключён как медленный
+	eventsBuffer = 256
+	// eventsWriteTimeout сколько ждать записи в соединение: зависший клиент не держит обработчик
+	eventsWriteTimeout = 10 * time.Second
+	// eventsHeartbeat период комментария-пинга, чтобы прокси не закрывали молчащий поток
+	eventsHeartbeat = 15 * time.Second
+)
+
+// SetEvents подключает брокер событий сбора для /events
+func (h *Handler) SetEvents(b *events.Broker) {
+	h.events = b
+}
+
+// Events godoc
+// @Summary Поток событий сбора (Server-Sent Events)
+// @Description События connect, disconnect, throughput и totals после каждого сохранённого снимка.
+// @Description Событие reset — часть событий потеряна, состояние нужно перечитать (/connected)
+// @Tags traffic
+// @Param instance query string false "Имя инстанса"
+// @Param types query string false "Типы событий через запятую: connect,disconnect,throughput,totals"
+// @Param last_event_id query int false "Продолжить после события (вместо заголовка Last-Event-ID)"
+// @Produce text/event-stream
+// @Router /events [get]
+func (h *Handler) Events(c *gin.Context) {
+	if h.events == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "события недоступны"})
+		return
+	}
+	instance := c.Query("instance")
+	types := make(map[string]bool)
+	if s := c.Query("types"); s != "" {
+		for _, t := range strings.Split(s, ",") {
+			switch t = strings.TrimSpace(t); t {
+			case events.TypeConnect, events.TypeDisconnect, events.TypeThroughput, events.TypeTotals:
+				types[t] = true
+			default:
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("неизвестный тип события %q", t)})
+				return
+			}
+		}
+	}
+	lastParam := c.GetHeader("Last-Event-ID")
+	if lastParam == "" {
+		lastParam = c.Query("last_event_id")
+	}
+	var lastID uint64
+	if lastParam != "" {
+		var err error
+		if lastID, err = strconv.ParseUint(lastParam, 10, 64); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "неверный last_event_id"})
+			return
+		}
+	}
+
+	sub, complete := h.events.Subscribe(eventsBuffer, lastID, func(ev events.Event) bool {
+		return (instance == "" || ev.Instance == instance) && (len(types) == 0 || types[ev.Type])
+	})
+	defer h.events.Unsubscribe(sub)
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no") // nginx не буферизует поток
+	c.Status(http.StatusOK)
+
+	rc := http.NewResponseController(c.Writer)
+	write := func(format string, args ...interface{}) bool {
+		rc.SetWriteDeadline(time.Now().Add(eventsWriteTimeout))
+		if _, err := fmt.Fprintf(c.Writer, format, args...); err != nil {
+			return false
+		}
+		return rc.Flush() == nil
+	}
+	if !write("retry: 3000\n\n") {
+		return
+	}
+	if lastID > 0 && !complete && !write("event: reset\ndata: {}\n\n") {
+		return
+	}
+
+	heartbeat := time.NewTicker(eventsHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-heartbeat.C:
+			if !write(": ping\n\n") {
+				return
+			}
+		case ev, open := <-sub.C:
+			if !open {
+				// Клиент не успевал читать: он переподключится с Last-Event-ID и получит пропущенное
+				if sub.Lagged() {
+					write("event: lagged\ndata: {}\n\n")
+				}
+				return
+			}
+			data, err := json.Marshal(ev)
+			if err != nil {
+				continue
+			}
+			if !write("id: %d\nevent: %s\ndata: %s\n\n", ev.ID, ev.Type, data) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package api
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Форматы выгрузки списков
+const (
+	formatCSV    = "csv"
+	formatNDJSON = "ndjson"
+)
+
+// exportFlushRows через сколько строк выгрузка отправляется клиенту
+const exportFlushRows = 500
+
+// negotiateExport формат выгрузки: ?format=csv|ndjson|json или заголовок Accept
+// (text/csv, application/x-ndjson). "" — обычный JSON
+func negotiateExport(c *gin.Context) (string, error) {
+	switch f := c.Query("format"); f {
+	case formatCSV, formatNDJSON:
+		return f, nil
+	case "json":
+		return "", nil
+	case "":
+	default:
+		return "", fmt.Errorf("format: допустимо csv, ndjson или json")
+	}
+	accept := c.GetHeader("Accept")
+	switch {
+	case strings.Contains(accept, "text/csv"):
+		return formatCSV, nil
+	case strings.Contains(accept, "application/x-ndjson"):
+		return formatNDJSON, nil
+	}
+	return "", nil
+}
+
+// setNextCursor передаёт курсор следующей страницы выгрузки в заголовке X-Next-Cursor
+func setNextCursor(c *gin.Context, next string) {
+	if next != "" {
+		c.Header("X-Next-Cursor", next)
+	}
+}
+
+// writeExport выгружает n уже прочитанных из БД строк в формате format, отправляя ответ
+// частями по exportFlushRows строк. row возвращает значения строки i в порядке columns;
+// name — имя файла без расширения
+func writeExport(c *gin.Context, format, name string, columns []string, n int, row func(i int) []interface{}) {
+	if format == formatCSV {
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+	} else {
+		c.Header("Content-Type", "application/x-ndjson")
+	}
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, name, format))
+	c.Status(http.StatusOK)
+
+	bw := bufio.NewWriter(c.Writer)
+	flush := func() error {
+		if err := bw.Flush(); err != nil {
+			return err
+		}
+		c.Writer.Flush()
+		return nil
+	}
+	if format == formatCSV {
+		cw := csv.NewWriter(bw)
+		if err := cw.Write(columns); err != nil {
+			return
+		}
+		record := make([]string, len(columns))
+		for i := 0; i < n; i++ {
+			for j, v := range row(i) {
+				record[j] = csvValue(v)
+			}
+			if err := cw.Write(record); err != nil {
+				return
+			}
+			if (i+1)%exportFlushRows == 0 {
+				if cw.Flush(); flush() != nil {
+					return
+				}
+			}
+		}
+		cw.Flush()
+		flush()
+		return
+	}
+	for i := 0; i < n; i++ {
+		if err := writeNDJSONRow(bw, columns, row(i)); err != nil {
+			return
+		}
+		if (i+1)%exportFlushRows == 0 && flush() != nil {
+			return
+		}
+	}
+	flush()
+}
+
+// writeNDJSONRow пишет строку JSON-объектом с ключами в порядке колонок
+func writeNDJSONRow(w *bufio.Writer, columns []string, values []interface{}) error {
+	w.WriteByte('{')
+	for i, col := range columns {
+		if i > 0 {
+			w.WriteByte(',')
+		}
+		key, _ := json.Marshal(col)
+		w.Write(key)
+		w.WriteByte(':')
+		v, err := json.Marshal(values[i])
+		if err != nil {
+			return err
+		}
+		w.Write(v)
+	}
+	w.WriteByte('}')
+	return w.WriteByte('\n')
+}
+
+// csvValue значение ячейки: числа как есть, время в RFC 3339, пустые указатели — пустая строка
+func csvValue(v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return x
+	case int:
+		return strconv.Itoa(x)
+	case int64:
+		return strconv.FormatInt(x, 10)
+	case *int64:
+		if x == nil {
+			return ""
+		}
+		return strconv.FormatInt(*x, 10)
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(x)
+	case time.Time:
+		if x.IsZero() {
+			return ""
+		}
+		return x.UTC().Format(time.RFC3339)
+	default:
+		return fmt.Sprint(x)
+	}
+}
@@ -0,0 +1,158 @@
+package api
+
+import (
+	"encoding/csv"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"open-statistic/internal/database"
+	"open-statistic/internal/parser"
+
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+func newTestHandler(t *testing.T) (*Handler, *database.DB) {
+	t.Helper()
+	db, err := database.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return New(db), db
+}
+
+// testContext gin-контекст запроса GET target с заголовками headers (имя, значение, ...)
+func testContext(target string, headers ...string) (*gin.Context, *httptest.ResponseRecorder) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, target, nil)
+	for i := 0; i+1 < len(headers); i += 2 {
+		c.Request.Header.Set(headers[i], headers[i+1])
+	}
+	return c, w
+}
+
+func TestNegotiateExport(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		accept string
+		want   string
+		ok     bool
+	}{
+		{"по умолчанию JSON", "/x", "", "", true},
+		{"format=csv", "/x?format=csv", "", formatCSV, true},
+		{"format=ndjson", "/x?format=ndjson", "", formatNDJSON, true},
+		{"Accept text/csv", "/x", "text/csv", formatCSV, true},
+		{"Accept ndjson со списком", "/x", "application/x-ndjson, */*;q=0.1", formatNDJSON, true},
+		{"Accept JSON", "/x", "application/json", "", true},
+		{"format=json важнее Accept", "/x?format=json", "text/csv", "", true},
+		{"format важнее Accept", "/x?format=ndjson", "text/csv", formatNDJSON, true},
+		{"неизвестный format", "/x?format=xml", "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := testContext(tt.target, "Accept", tt.accept)
+			got, err := negotiateExport(c)
+			if (err == nil) != tt.ok || got != tt.want {
+				t.Errorf("negotiateExport() = %q, %v; ожидалось %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteExport(t *testing.T) {
+	at := time.Date(2024, 2, 23, 12, 0, 0, 0, time.FixedZone("MSK", 3*3600))
+	id := int64(7)
+	columns := []string{"common_name", "bytes_received", "rate", "client_id", "connected_since", "active"}
+	rows := [][]interface{}{
+		{"alice", int64(1500), 12.5, &id, at, true},
+		{"bob, \"jr\"", int64(0), nil, (*int64)(nil), at, false},
+	}
+	tests := []struct {
+		format      string
+		contentType string
+		want        string
+	}{
+		{formatCSV, "text/csv; charset=utf-8",
+			"common_name,bytes_received,rate,client_id,connected_since,active\n" +
+				"alice,1500,12.5,7,2024-02-23T09:00:00Z,true\n" +
+				"\"bob, \"\"jr\"\"\",0,,,2024-02-23T09:00:00Z,false\n"},
+		{formatNDJSON, "application/x-ndjson",
+			`{"common_name":"alice","bytes_received":1500,"rate":12.5,"client_id":7,"connected_since":"2024-02-23T12:00:00+03:00","active":true}` + "\n" +
+				`{"common_name":"bob, \"jr\"","bytes_received":0,"rate":null,"client_id":null,"connected_since":"2024-02-23T12:00:00+03:00","active":false}` + "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			c, w := testContext("/x")
+			writeExport(c, tt.format, "connected", columns, len(rows), func(i int) []interface{} { return rows[i] })
+			if ct := w.Header().Get("Content-Type"); ct != tt.contentType {
+				t.Errorf("Content-Type = %q", ct)
+			}
+			if cd := w.Header().Get("Content-Disposition"); cd != `attachment; filename="connected.`+tt.format+`"` {
+				t.Errorf("Content-Disposition = %q", cd)
+			}
+			if got := w.Body.String(); got != tt.want {
+				t.Errorf("тело:\n%s\nожидалось:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestWriteExportFlush выгрузка длиннее exportFlushRows отдаётся целиком
+func TestWriteExportFlush(t *testing.T) {
+	c, w := testContext("/x")
+	n := 2*exportFlushRows + 1
+	writeExport(c, formatNDJSON, "big", []string{"i"}, n, func(i int) []interface{} { return []interface{}{i} })
+	if lines := strings.Count(w.Body.String(), "\n"); lines != n {
+		t.Errorf("строк %d, ожидалось %d", lines, n)
+	}
+}
+
+// TestConnectedExport /connected в CSV: заголовок в порядке колонок выгрузки, значения под ним
+func TestConnectedExport(t *testing.T) {
+	h, db := newTestHandler(t)
+	at := time.Date(2024, 2, 23, 12, 0, 0, 0, time.UTC)
+	cid := int64(3)
+	if err := db.SaveSnapshot("vpn1", &parser.Status{Version: 2, UpdatedAt: at, Clients: []parser.Client{
+		{CommonName: "alice", RealAddress: "1.1.1.1:1000", VirtualAddr: "10.8.0.2", Username: "al", ClientID: &cid,
+			BytesReceived: 300, BytesSent: 30, ConnectedSince: at.Add(-time.Minute)},
+	}}); err != nil {
+		t.Fatal(err)
+	}
+
+	c, w := testContext("/connected", "Accept", "text/csv")
+	h.GetConnected(c)
+	if w.Code != http.StatusOK {
+		t.Fatalf("код %d: %s", w.Code, w.Body)
+	}
+	records, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("строк %d: %q", len(records), records)
+	}
+	want := map[string]string{
+		"instance": "vpn1", "common_name": "alice", "real_address": "1.1.1.1:1000", "virtual_address": "10.8.0.2",
+		"username": "al", "client_id": "3", "peer_id": "", "bytes_received": "300", "bytes_sent": "30",
+		"connected_since": "2024-02-23T11:59:00Z", "avg_rate_received": "5", "avg_rate_sent": "0.5",
+	}
+	header, row := records[0], records[1]
+	if header[0] != "instance" || header[1] != "common_name" || header[len(header)-1] != "avg_rate_sent" {
+		t.Errorf("порядок колонок %q", header)
+	}
+	for i, col := range header {
+		if v, ok := want[col]; ok && row[i] != v {
+			t.Errorf("%s = %q, ожидалось %q", col, row[i], v)
+		}
+	}
+}
@@ -2,8 +2,13 @@ package api
 
 import (
 	"net/http"
+	"strings"
+	"time"
 
+	"open-statistic/internal/collector"
 	"open-statistic/internal/database"
+	"open-statistic/internal/events"
+	"open-statistic/internal/webhook"
 
 	"github.com/gin-gonic/gin"
 )
@@ -11,11 +16,16 @@ import (
 type Handler struct {
 	db           *database.DB
 	collectFn    CollectFn
-	allowedPaths []string // разрешённые директории для path (защита от traversal)
+	collectors   *collector.Registry
+	events       *events.Broker
+	webhooks     *webhook.Sender // проверка webhook_url квот и алертов
+	allowedPaths []string        // разрешённые директории для path (защита от traversal)
+	health       HealthConfig
+	started      time.Time
 }
 
 func New(db *database.DB) *Handler {
-	return &Handler{db: db, allowedPaths: []string{"/var/log/openvpn"}}
+	return &Handler{db: db, allowedPaths: []string{"/var/log/openvpn"}, started: time.Now()}
 }
 
 func (h *Handler) SetCollectFn(fn CollectFn) {
@@ -29,21 +39,34 @@ func (h *Handler) SetAllowedPaths(paths []string) {
 // GetUsers godoc
 // @Summary Список пользователей
 // @Tags users
+// @Param instance query string false "Имя инстанса"
+// @Param name query string false "Префикс Common Name или glob-шаблон (*, ?, [...])"
+// @Param alias query string false "Подстрока алиаса без учёта регистра"
+// @Param cidr query string false "Подсеть реального адреса клиента"
+// @Param limit query int false "Размер страницы"
+// @Param cursor query string false "next_cursor предыдущей страницы"
+// @Param sort query string false "common_name или -common_name"
 // @Produce json
 // @Success 200 {object} []string
 // @Router /users [get]
 func (h *Handler) GetUsers(c *gin.Context) {
-	users, err := h.db.GetUsers()
+	o, err := parseListOptions(c)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	users, next, err := h.db.GetUsers(instanceFilter(c), o)
+	if err != nil {
+		listError(c, err)
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"users": users})
+	c.JSON(http.StatusOK, gin.H{"users": users, "next_cursor": next})
 }
 
 // GetUserTraffic godoc
 // @Summary Трафик пользователя
 // @Tags users
+// @Description Трафик текущих сессий, их скорость (байт/с), пиковая и средняя скорость каждой сессии
 // @Param name path string true "Common Name пользователя"
 // @Produce json
 // @Success 200 {object} database.UserTraffic
@@ -54,36 +77,85 @@ func (h *Handler) GetUserTraffic(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "имя пользователя обязательно"})
 		return
 	}
-	traffic, err := h.db.GetUserTraffic(name)
+	traffic, err := h.db.GetUserTraffic(name, instanceFilter(c))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	if c.Query("human") == "1" {
-		c.JSON(http.StatusOK, gin.H{
-			"common_name":     traffic.CommonName,
-			"bytes_received": FormatBytes(traffic.BytesReceived),
-			"bytes_sent":     FormatBytes(traffic.BytesSent),
-			"total_bytes":    FormatBytes(traffic.TotalBytes),
-		})
+	rates, err := h.db.GetUserSessionRates(name, instanceFilter(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	c.JSON(http.StatusOK, traffic)
+	human := c.Query("human") == "1"
+	// Скорость пользователя — сумма по его текущим сессиям
+	var rateR, rateS *float64
+	sessions := make([]gin.H, 0, len(rates))
+	for _, r := range rates {
+		if r.RateReceived != nil {
+			rateR, rateS = addRate(rateR, *r.RateReceived), addRate(rateS, *r.RateSent)
+		}
+		item := gin.H{"instance": r.Instance, "real_address": r.RealAddress, "connected_since": r.ConnectedSince}
+		for k, v := range rateFields(r.Rates, human) {
+			item[k] = v
+		}
+		sessions = append(sessions, item)
+	}
+	out := gin.H{
+		"common_name":    traffic.CommonName,
+		"bytes_received": traffic.BytesReceived,
+		"bytes_sent":     traffic.BytesSent,
+		"total_bytes":    traffic.TotalBytes,
+		"sessions":       sessions,
+	}
+	if human {
+		out["bytes_received"] = FormatBytes(traffic.BytesReceived)
+		out["bytes_sent"] = FormatBytes(traffic.BytesSent)
+		out["total_bytes"] = FormatBytes(traffic.TotalBytes)
+	}
+	out["rate_received"], out["rate_sent"] = formatRate(rateR, human), formatRate(rateS, human)
+	c.JSON(http.StatusOK, out)
 }
 
 // GetAllTraffic godoc
 // @Summary Трафик всех пользователей
 // @Tags traffic
-// @Produce json
+// @Param format query string false "csv или ndjson (или заголовок Accept: text/csv, application/x-ndjson)"
+// @Param name query string false "Префикс Common Name или glob-шаблон (*, ?, [...])"
+// @Param alias query string false "Подстрока алиаса без учёта регистра"
+// @Param cidr query string false "Подсеть реального адреса клиента"
+// @Param limit query int false "Размер страницы"
+// @Param cursor query string false "next_cursor предыдущей страницы"
+// @Param min_bytes query int false "Не меньше байт всего"
+// @Param sort query string false "common_name, instance, bytes_received, bytes_sent, total_bytes; - — по убыванию (по умолчанию -total_bytes)"
+// @Produce json,text/csv,application/x-ndjson
 // @Success 200 {array} database.UserTraffic
 // @Router /traffic [get]
 func (h *Handler) GetAllTraffic(c *gin.Context) {
-	traffic, err := h.db.GetAllTraffic()
+	format, err := negotiateExport(c)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	o, err := parseListOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	traffic, next, err := h.db.GetAllTraffic(instanceFilter(c), o)
+	if err != nil {
+		listError(c, err)
 		return
 	}
 	aliases := h.db.LoadAllAliases()
+	if format != "" {
+		setNextCursor(c, next)
+		writeExport(c, format, "traffic", []string{"instance", "common_name", "alias", "bytes_received", "bytes_sent", "total_bytes"}, len(traffic), func(i int) []interface{} {
+			t := traffic[i]
+			return []interface{}{t.Instance, t.CommonName, aliases[t.CommonName], t.BytesReceived, t.BytesSent, t.TotalBytes}
+		})
+		return
+	}
 	if c.Query("human") == "1" {
 		out := make([]gin.H, 0, len(traffic))
 		for _, t := range traffic {
@@ -93,41 +165,85 @@ func (h *Handler) GetAllTraffic(c *gin.Context) {
 				"bytes_sent":     FormatBytes(t.BytesSent),
 				"total_bytes":    FormatBytes(t.TotalBytes),
 			}
+			if t.Instance != "" {
+				item["instance"] = t.Instance
+			}
 			if a, ok := aliases[t.CommonName]; ok {
 				item["alias"] = a
 			}
 			out = append(out, item)
 		}
-		c.JSON(http.StatusOK, gin.H{"traffic": out})
+		c.JSON(http.StatusOK, gin.H{"traffic": out, "next_cursor": next})
 		return
 	}
 	out := make([]gin.H, 0, len(traffic))
 	for _, t := range traffic {
 		item := gin.H{"common_name": t.CommonName, "bytes_received": t.BytesReceived, "bytes_sent": t.BytesSent, "total_bytes": t.TotalBytes}
+		if t.Instance != "" {
+			item["instance"] = t.Instance
+		}
 		if a, ok := aliases[t.CommonName]; ok {
 			item["alias"] = a
 		}
 		out = append(out, item)
 	}
-	c.JSON(http.StatusOK, gin.H{"traffic": out})
+	c.JSON(http.StatusOK, gin.H{"traffic": out, "next_cursor": next})
 }
 
 // GetConnected godoc
 // @Summary Текущие подключения (последний снимок)
 // @Tags traffic
-// @Produce json
-// @Success 200 {array} parser.Client
+// @Param format query string false "csv или ndjson (или заголовок Accept: text/csv, application/x-ndjson)"
+// @Produce json,text/csv,application/x-ndjson
+// @Param instance query string false "Имя инстанса"
+// @Param name query string false "Префикс Common Name или glob-шаблон (*, ?, [...])"
+// @Param alias query string false "Подстрока алиаса без учёта регистра"
+// @Param cidr query string false "Подсеть реального адреса клиента"
+// @Param limit query int false "Размер страницы"
+// @Param cursor query string false "next_cursor предыдущей страницы"
+// @Param min_bytes query int false "Не меньше байт всего за сессию"
+// @Param sort query string false "common_name, instance, real_address, bytes_received, bytes_sent, total_bytes; - — по убыванию"
+// @Success 200 {array} database.ConnectedClient
 // @Router /connected [get]
 func (h *Handler) GetConnected(c *gin.Context) {
-	clients, err := h.db.GetLatestSnapshot()
+	format, err := negotiateExport(c)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	o, err := parseListOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	clients, next, err := h.db.GetConnected(instanceFilter(c), o)
+	if err != nil {
+		listError(c, err)
 		return
 	}
 	aliases := h.db.LoadAllAliases()
+	if format != "" {
+		setNextCursor(c, next)
+		columns := []string{"instance", "common_name", "alias", "real_address", "virtual_address", "virtual_ipv6_address", "username",
+			"client_id", "peer_id", "cipher", "bytes_received", "bytes_sent", "connected_since",
+			"rate_received", "rate_sent", "peak_rate_received", "peak_rate_sent", "avg_rate_received", "avg_rate_sent"}
+		writeExport(c, format, "connected", columns, len(clients), func(i int) []interface{} {
+			cl := clients[i]
+			alias, ok := aliases[cl.CommonName+"|"+cl.RealAddress]
+			if !ok {
+				alias = aliases[cl.CommonName]
+			}
+			return []interface{}{cl.Instance, cl.CommonName, alias, cl.RealAddress, cl.VirtualAddr, cl.VirtualIPv6, cl.Username,
+				cl.ClientID, cl.PeerID, cl.Cipher, cl.BytesReceived, cl.BytesSent, cl.ConnectedSince,
+				formatRate(cl.RateReceived, false), formatRate(cl.RateSent, false), formatRate(&cl.PeakRateReceived, false), formatRate(&cl.PeakRateSent, false),
+				formatRate(&cl.AvgRateReceived, false), formatRate(&cl.AvgRateSent, false)}
+		})
+		return
+	}
 	out := make([]gin.H, 0, len(clients))
 	for _, cl := range clients {
 		item := gin.H{
+			"instance":        cl.Instance,
 			"common_name":     cl.CommonName,
 			"real_address":   cl.RealAddress,
 			"virtual_address": cl.VirtualAddr,
@@ -135,6 +251,25 @@ func (h *Handler) GetConnected(c *gin.Context) {
 			"bytes_sent":      cl.BytesSent,
 			"connected_since": cl.ConnectedSince,
 		}
+		for k, v := range rateFields(cl.Rates, false) {
+			item[k] = v
+		}
+		// Расширенные колонки есть только в status-version 2/3
+		if cl.VirtualIPv6 != "" {
+			item["virtual_ipv6_address"] = cl.VirtualIPv6
+		}
+		if cl.Username != "" {
+			item["username"] = cl.Username
+		}
+		if cl.ClientID != nil {
+			item["client_id"] = *cl.ClientID
+		}
+		if cl.PeerID != nil {
+			item["peer_id"] = *cl.PeerID
+		}
+		if cl.Cipher != "" {
+			item["cipher"] = cl.Cipher
+		}
 		if a, ok := aliases[cl.CommonName+"|"+cl.RealAddress]; ok {
 			item["alias"] = a
 		} else if a, ok := aliases[cl.CommonName]; ok {
@@ -142,12 +277,55 @@ func (h *Handler) GetConnected(c *gin.Context) {
 		}
 		out = append(out, item)
 	}
-	c.JSON(http.StatusOK, gin.H{"clients": out})
+	c.JSON(http.StatusOK, gin.H{"clients": out, "next_cursor": next})
+}
+
+// GetRoutes godoc
+// @Summary Таблица маршрутов из последнего снимка (какой клиент владеет адресом/подсетью)
+// @Tags traffic
+// @Param name query string false "Common Name клиента"
+// @Produce json
+// @Success 200 {array} database.RouteEntry
+// @Router /routes [get]
+func (h *Handler) GetRoutes(c *gin.Context) {
+	routes, err := h.db.GetLatestRoutes(c.Query("name"), instanceFilter(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	aliases := h.db.LoadAllAliases()
+	out := make([]gin.H, 0, len(routes))
+	for _, r := range routes {
+		item := gin.H{
+			"instance":        r.Instance,
+			"virtual_address": r.VirtualAddr,
+			"common_name":     r.CommonName,
+			"real_address":    r.RealAddress,
+			"last_ref":        r.LastRef,
+			"subnet":          strings.Contains(r.VirtualAddr, "/"),
+		}
+		// Сколько секунд маршрут не использовался на момент снимка
+		if !r.LastRef.IsZero() {
+			item["idle_seconds"] = int64(r.SnapshotAt.Sub(r.LastRef).Seconds())
+		}
+		if a, ok := aliases[r.CommonName+"|"+r.RealAddress]; ok {
+			item["alias"] = a
+		} else if a, ok := aliases[r.CommonName]; ok {
+			item["alias"] = a
+		}
+		out = append(out, item)
+	}
+	c.JSON(http.StatusOK, gin.H{"routes": out})
 }
 
 // GetStats godoc
 func (h *Handler) GetStats(c *gin.Context) {
-	stats, err := h.db.GetStats()
+	f, err := parseRange(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	stats, err := h.db.GetStats(f)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -171,38 +349,50 @@ func (h *Handler) GetStats(c *gin.Context) {
 // GetDailyTraffic godoc
 // @Summary Агрегированный трафик по дням (всего по всем пользователям)
 // @Tags traffic
-// @Produce json
+// @Param from query string false "RFC 3339 или YYYY-MM-DD (по умолчанию — последние 30 дней)"
+// @Param to query string false "RFC 3339 или YYYY-MM-DD"
+// @Param by query string false "user — матрица пользователь × день"
+// @Param format query string false "csv или ndjson (или заголовок Accept: text/csv, application/x-ndjson)"
+// @Produce json,text/csv,application/x-ndjson
 // @Success 200 {array} database.DailyTraffic
 // @Router /traffic/daily [get]
 func (h *Handler) GetDailyTraffic(c *gin.Context) {
-	list, err := h.db.GetDailyTraffic(30)
+	f, err := parseRange(c)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	if c.Query("human") == "1" {
-		out := make([]gin.H, 0, len(list))
-		for _, d := range list {
-			out = append(out, gin.H{
-				"day":            d.Day,
-				"bytes_received": FormatBytes(d.BytesReceived),
-				"bytes_sent":     FormatBytes(d.BytesSent),
-				"total_bytes":    FormatBytes(d.TotalBytes),
-			})
+	format, err := negotiateExport(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	switch c.Query("by") {
+	case "":
+	case "user":
+		if format != "" {
+			h.exportDailyByUser(c, f, format)
+			return
 		}
-		c.JSON(http.StatusOK, gin.H{"days": out})
+		h.getDailyByUser(c, f)
+		return
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "by: допустимо только user"})
 		return
 	}
-	out := make([]gin.H, 0, len(list))
-	for _, d := range list {
-		out = append(out, gin.H{
-			"day":            d.Day,
-			"bytes_received": d.BytesReceived,
-			"bytes_sent":     d.BytesSent,
-			"total_bytes":    d.TotalBytes,
+	list, err := h.db.GetDailyTraffic(f)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if format != "" {
+		writeExport(c, format, "traffic-daily", []string{"day", "bytes_received", "bytes_sent", "total_bytes"}, len(list), func(i int) []interface{} {
+			d := list[i]
+			return []interface{}{d.Day, d.BytesReceived, d.BytesSent, d.TotalBytes}
 		})
+		return
 	}
-	c.JSON(http.StatusOK, gin.H{"days": out})
+	c.JSON(http.StatusOK, gin.H{"days": dailyItems(list, c.Query("human") == "1")})
 }
 
 // GetUserTotal godoc
@@ -212,7 +402,12 @@ func (h *Handler) GetUserTotal(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "имя пользователя обязательно"})
 		return
 	}
-	traffic, err := h.db.GetTotalTraffic(name)
+	f, err := parseRange(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	traffic, err := h.db.GetTotalTraffic(name, f)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -231,12 +426,35 @@ func (h *Handler) GetUserTotal(c *gin.Context) {
 
 // GetTotalTraffic godoc
 func (h *Handler) GetTotalTraffic(c *gin.Context) {
-	traffic, err := h.db.GetTotalTrafficAll()
+	f, err := parseRange(c)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	format, err := negotiateExport(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	o, err := parseListOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	traffic, next, err := h.db.GetTotalTrafficAll(f, o)
+	if err != nil {
+		listError(c, err)
 		return
 	}
 	aliases := h.db.LoadAllAliases()
+	if format != "" {
+		setNextCursor(c, next)
+		writeExport(c, format, "traffic-total", []string{"common_name", "alias", "bytes_received", "bytes_sent", "total_bytes"}, len(traffic), func(i int) []interface{} {
+			t := traffic[i]
+			return []interface{}{t.CommonName, aliases[t.CommonName], t.BytesReceived, t.BytesSent, t.TotalBytes}
+		})
+		return
+	}
 	if c.Query("human") == "1" {
 		out := make([]gin.H, 0, len(traffic))
 		for _, t := range traffic {
@@ -251,7 +469,7 @@ func (h *Handler) GetTotalTraffic(c *gin.Context) {
 			}
 			out = append(out, item)
 		}
-		c.JSON(http.StatusOK, gin.H{"traffic": out})
+		c.JSON(http.StatusOK, gin.H{"traffic": out, "next_cursor": next})
 		return
 	}
 	out := make([]gin.H, 0, len(traffic))
@@ -262,13 +480,14 @@ func (h *Handler) GetTotalTraffic(c *gin.Context) {
 		}
 		out = append(out, item)
 	}
-	c.JSON(http.StatusOK, gin.H{"traffic": out})
+	c.JSON(http.StatusOK, gin.H{"traffic": out, "next_cursor": next})
 }
 
 // CollectNow godoc
 // @Summary Принудительно собрать статистику из status-файла
 // @Tags collect
 // @Param path query string true "Путь к OpenVPN status-файлу"
+// @Param instance query string false "Инстанс, под которым сохранить снимок (по умолчанию — чей это status-файл)"
 // @Produce json
 // @Success 200 {object} map[string]int
 // @Router /collect [post]
@@ -290,29 +509,54 @@ func (h *Handler) CollectNow(c *gin.Context) {
 		c.JSON(http.StatusForbidden, gin.H{"error": "путь не разрешён"})
 		return
 	}
-	if h.collectFn != nil {
-		if err := h.collectFn(path); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
+	instance := c.Query("instance")
+	if col := h.findCollector(instance); instance != "" && (col == nil || col.Remote()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "неизвестный инстанс"})
+		return
 	}
-	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	if h.collectFn == nil {
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+		return
+	}
+	res, err := h.collectFn(instance, path)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if res.Skipped {
+		c.JSON(http.StatusOK, gin.H{"status": "skipped", "instance": res.Instance, "reason": res.Reason, "updated_at": res.UpdatedAt})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "instance": res.Instance, "clients": res.Clients, "updated_at": res.UpdatedAt})
 }
 
-// CollectFn вызывается для сбора статистики (инжектируется из main)
-type CollectFn func(statusPath string) error
+// CollectFn вызывается для сбора статистики (инжектируется из main). instance="" — определить по пути
+type CollectFn func(instance, statusPath string) (collector.Result, error)
 
 // GetAliases godoc
 // @Summary Список алиасов (читаемые имена устройств/пользователей)
 // @Tags aliases
-// @Produce json
+// @Param format query string false "csv или ndjson (или заголовок Accept: text/csv, application/x-ndjson)"
+// @Produce json,text/csv,application/x-ndjson
 // @Router /aliases [get]
 func (h *Handler) GetAliases(c *gin.Context) {
+	format, err := negotiateExport(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	list, err := h.db.GetAllAliases()
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	if format != "" {
+		writeExport(c, format, "aliases", []string{"common_name", "real_address", "alias"}, len(list), func(i int) []interface{} {
+			a := list[i]
+			return []interface{}{a.CommonName, a.RealAddress, a.Alias}
+		})
+		return
+	}
 	out := make([]gin.H, 0, len(list))
 	for _, a := range list {
 		out = append(out, gin.H{"common_name": a.CommonName, "real_address": a.RealAddress, "alias": a.Alias})
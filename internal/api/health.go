@@ -0,0 +1,177 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"open-statistic/internal/collector"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Состояния /health
+const (
+	healthOK       = "ok"
+	healthDegraded = "degraded" // последний сбор с ошибкой, но пороги не превышены
+	healthFail     = "fail"     // порог превышен или БД недоступна на запись — 503
+)
+
+// HealthConfig пороги /health; 0 — не проверять
+type HealthConfig struct {
+	MaxStatusAge  time.Duration // возраст времени Updated последнего снимка
+	MaxCollectAge time.Duration // время с последнего успешного сбора
+}
+
+// InstanceHealth состояние сбора одного инстанса
+type InstanceHealth struct {
+	Instance         string     `json:"instance"`
+	Source           string     `json:"source"` // local или agent
+	Status           string     `json:"status"`
+	LastSuccess      *time.Time `json:"last_success"`
+	CollectAgeSec    *int64     `json:"collect_age_seconds"`
+	StatusUpdatedAt  *time.Time `json:"status_updated_at"`
+	StatusAgeSec     *int64     `json:"status_age_seconds"`
+	LastError        string     `json:"last_error,omitempty"`
+	LastErrorAt      *time.Time `json:"last_error_at,omitempty"`
+	Runs             int64      `json:"runs"`
+	Errors           int64      `json:"errors"`
+	ParseErrors      int64      `json:"parse_errors"`
+	Problems         []string   `json:"problems,omitempty"`
+	countsForOverall bool
+}
+
+// SetHealth задаёт пороги /health
+func (h *Handler) SetHealth(cfg HealthConfig) {
+	h.health = cfg
+}
+
+// Health godoc
+// @Summary Проверка для оркестратора: 503, если снимки устарели или БД недоступна на запись
+// @Tags health
+// @Produce json
+// @Success 200 {object} map[string]string
+// @Failure 503 {object} map[string]string
+// @Router /health [get]
+func (h *Handler) Health(c *gin.Context) {
+	status, _, _ := h.checkHealth(time.Now())
+	c.JSON(healthCode(status), gin.H{"status": status})
+}
+
+// HealthDetails godoc
+// @Summary Подробное состояние: сбор по инстансам, возраст снимков, ошибки, запись в БД
+// @Tags health
+// @Produce json
+// @Success 200 {object} map[string]interface{}
+// @Failure 503 {object} map[string]interface{}
+// @Router /health/details [get]
+func (h *Handler) HealthDetails(c *gin.Context) {
+	status, instances, dbErr := h.checkHealth(time.Now())
+	db := gin.H{"writable": dbErr == nil}
+	if dbErr != nil {
+		db["error"] = dbErr.Error()
+	}
+	c.JSON(healthCode(status), gin.H{
+		"status":    status,
+		"db":        db,
+		"instances": instances,
+		"thresholds": gin.H{
+			"max_status_age_seconds":  int64(h.health.MaxStatusAge.Seconds()),
+			"max_collect_age_seconds": int64(h.health.MaxCollectAge.Seconds()),
+		},
+	})
+}
+
+func healthCode(status string) int {
+	if status == healthFail {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusOK
+}
+
+// checkHealth общее состояние и состояние инстансов. Инстансы агентов показываются,
+// но на общий статус не влияют: перезапуск центрального сервера их не починит
+func (h *Handler) checkHealth(now time.Time) (string, []InstanceHealth, error) {
+	overall := healthOK
+	dbErr := h.db.CheckWritable()
+	if dbErr != nil {
+		overall = healthFail
+	}
+	cols := h.allCollectors()
+	instances := make([]InstanceHealth, 0, len(cols))
+	for _, col := range cols {
+		ih := h.instanceHealth(col, now)
+		if ih.countsForOverall && worse(ih.Status, overall) {
+			overall = ih.Status
+		}
+		instances = append(instances, ih)
+	}
+	return overall, instances, dbErr
+}
+
+func (h *Handler) instanceHealth(col *collector.Collector, now time.Time) InstanceHealth {
+	st := col.Stats()
+	ih := InstanceHealth{
+		Instance:         col.Instance(),
+		Source:           "local",
+		Status:           healthOK,
+		LastError:        st.LastError,
+		Runs:             st.Runs,
+		Errors:           st.Errors,
+		ParseErrors:      st.ParseErrors,
+		countsForOverall: !col.Remote(),
+	}
+	if col.Remote() {
+		ih.Source = "agent"
+	}
+	if !st.LastErrorAt.IsZero() {
+		ih.LastErrorAt = timePtr(st.LastErrorAt)
+	}
+
+	// До первого успешного сбора отсчёт идёт от запуска сервера
+	since := h.started
+	if !st.LastSuccess.IsZero() {
+		ih.LastSuccess = timePtr(st.LastSuccess)
+		since = st.LastSuccess
+	}
+	collectAge := now.Sub(since)
+	ih.CollectAgeSec = secondsPtr(collectAge)
+	if h.health.MaxCollectAge > 0 && collectAge > h.health.MaxCollectAge {
+		ih.Status = healthFail
+		ih.Problems = append(ih.Problems, fmt.Sprintf("нет успешного сбора %s", collectAge.Truncate(time.Second)))
+	}
+
+	if !st.LastStatusAt.IsZero() {
+		ih.StatusUpdatedAt = timePtr(st.LastStatusAt)
+		statusAge := now.Sub(st.LastStatusAt)
+		ih.StatusAgeSec = secondsPtr(statusAge)
+		if h.health.MaxStatusAge > 0 && statusAge > h.health.MaxStatusAge {
+			ih.Status = healthFail
+			ih.Problems = append(ih.Problems, fmt.Sprintf("status-файл не обновлялся %s", statusAge.Truncate(time.Second)))
+		}
+	}
+
+	if st.LastErrorAt.After(st.LastSuccess) {
+		if ih.Status == healthOK {
+			ih.Status = healthDegraded
+		}
+		ih.Problems = append(ih.Problems, "последний сбор: "+st.LastError)
+	}
+	return ih
+}
+
+// worse a хуже b
+func worse(a, b string) bool {
+	rank := map[string]int{healthOK: 0, healthDegraded: 1, healthFail: 2}
+	return rank[a] > rank[b]
+}
+
+func timePtr(t time.Time) *time.Time {
+	t = t.UTC()
+	return &t
+}
+
+func secondsPtr(d time.Duration) *int64 {
+	s := int64(d.Seconds())
+	return &s
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"open-statistic/internal/collector"
+	"open-statistic/internal/parser"
+)
+
+// TestCheckHealth пороги возраста снимка и сбора, ошибка последнего сбора; инстансы агентов
+// на общий статус не влияют
+func TestCheckHealth(t *testing.T) {
+	updated := time.Now().UTC().Truncate(time.Second)
+	snapshot := &parser.Status{Version: 2, UpdatedAt: updated, Clients: []parser.Client{
+		{CommonName: "alice", RealAddress: "1.1.1.1:1000", ConnectedSince: updated.Add(-time.Hour), BytesReceived: 100, BytesSent: 10},
+	}}
+	cfg := HealthConfig{MaxStatusAge: 5 * time.Minute, MaxCollectAge: 3 * time.Minute}
+
+	tests := []struct {
+		name     string
+		after    time.Duration // сколько прошло после снимка
+		local    bool          // снимок собран локально, иначе пришёл от агента
+		failLast bool          // следующий сбор завершился ошибкой
+		want     string        // общий статус
+		instance string        // статус инстанса
+	}{
+		{"свежий снимок", time.Minute, true, false, healthOK, healthOK},
+		{"ошибка после успешного сбора", time.Minute, true, true, healthDegraded, healthDegraded},
+		{"нет сбора дольше порога", 4 * time.Minute, true, false, healthFail, healthFail},
+		{"status-файл устарел", 6 * time.Minute, true, true, healthFail, healthFail},
+		{"устаревший агент", 6 * time.Minute, false, false, healthOK, healthFail},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, db := newTestHandler(t)
+			h.SetHealth(cfg)
+			reg := collector.NewRegistry(db)
+			h.SetCollectors(reg)
+			var col *collector.Collector
+			var err error
+			if tt.local {
+				col, err = reg.AddLocal("vpn1")
+			} else {
+				col, err = reg.Remote("vpn1")
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := col.Ingest(snapshot); err != nil {
+				t.Fatal(err)
+			}
+			if tt.failLast {
+				if _, err := col.CollectFile(filepath.Join(t.TempDir(), "missing.log")); err == nil {
+					t.Fatal("сбор несуществующего файла без ошибки")
+				}
+			}
+
+			status, instances, dbErr := h.checkHealth(updated.Add(tt.after))
+			if dbErr != nil {
+				t.Fatal(dbErr)
+			}
+			if status != tt.want {
+				t.Errorf("общий статус %q, ожидалось %q", status, tt.want)
+			}
+			if len(instances) != 1 || instances[0].Status != tt.instance {
+				t.Errorf("инстансы %+v", instances)
+			}
+			if tt.instance != healthOK && len(instances) == 1 && len(instances[0].Problems) == 0 {
+				t.Error("нет описания проблемы")
+			}
+			if code := healthCode(status); (code == http.StatusServiceUnavailable) != (status == healthFail) {
+				t.Errorf("код %d для %q", code, status)
+			}
+		})
+	}
+}
+
+// TestCheckHealthDB БД, недоступная на запись, — fail без инстансов
+func TestCheckHealthDB(t *testing.T) {
+	h, db := newTestHandler(t)
+	db.Close()
+	status, _, dbErr := h.checkHealth(time.Now())
+	if status != healthFail || dbErr == nil {
+		t.Errorf("статус %q, ошибка БД %v", status, dbErr)
+	}
+}
@@ -0,0 +1,49 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetHourlyTraffic godoc
+// @Summary Почасовой трафик (всего или одного пользователя)
+// @Tags traffic
+// @Param from query string false "RFC 3339 или YYYY-MM-DD (по умолчанию — последние 24 часа)"
+// @Param to query string false "RFC 3339 или YYYY-MM-DD"
+// @Param name query string false "Common Name пользователя"
+// @Produce json
+// @Success 200 {array} database.HourlyTraffic
+// @Router /traffic/hourly [get]
+func (h *Handler) GetHourlyTraffic(c *gin.Context) {
+	f, err := parseRange(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	list, err := h.db.GetHourlyTraffic(c.Query("name"), f)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	human := c.Query("human") == "1"
+	out := make([]gin.H, 0, len(list))
+	for _, t := range list {
+		if human {
+			out = append(out, gin.H{
+				"hour":           t.Hour,
+				"bytes_received": FormatBytes(t.BytesReceived),
+				"bytes_sent":     FormatBytes(t.BytesSent),
+				"total_bytes":    FormatBytes(t.TotalBytes),
+			})
+			continue
+		}
+		out = append(out, gin.H{
+			"hour":           t.Hour,
+			"bytes_received": t.BytesReceived,
+			"bytes_sent":     t.BytesSent,
+			"total_bytes":    t.TotalBytes,
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"hours": out})
+}
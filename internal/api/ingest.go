@@ -0,0 +1,63 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"open-statistic/internal/collector"
+	"open-statistic/internal/parser"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxIngestBody ограничение размера снимка от агента
+const maxIngestBody = 32 << 20
+
+// IngestRequest снимок, присланный агентом (cmd/agent)
+type IngestRequest struct {
+	Instance string         `json:"instance"`
+	Status   *parser.Status `json:"status"`
+}
+
+// Ingest godoc
+// @Summary Принять снимок status-файла от агента
+// @Tags collect
+// @Param body body IngestRequest true "instance и распарсенный status-файл"
+// @Produce json
+// @Success 200 {object} map[string]string
+// @Router /ingest [post]
+func (h *Handler) Ingest(c *gin.Context) {
+	if h.collectors == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "приём снимков не настроен"})
+		return
+	}
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxIngestBody)
+	var req IngestRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body"})
+		return
+	}
+	if req.Status == nil || req.Status.UpdatedAt.IsZero() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status.updated_at обязателен"})
+		return
+	}
+	col, err := h.collectors.Remote(req.Instance)
+	if errors.Is(err, collector.ErrLocalInstance) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	res, err := col.Ingest(req.Status)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if res.Skipped {
+		c.JSON(http.StatusOK, gin.H{"status": "skipped", "instance": req.Instance, "reason": res.Reason})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "instance": req.Instance, "clients": len(req.Status.Clients)})
+}
@@ -0,0 +1,93 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"open-statistic/internal/collector"
+	"open-statistic/internal/database"
+	"open-statistic/internal/parser"
+
+	"github.com/gin-gonic/gin"
+)
+
+func ingest(h *Handler, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/ingest", strings.NewReader(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+	h.Ingest(c)
+	return w
+}
+
+func ingestBody(instance string, updated time.Time, received int64) string {
+	body, _ := json.Marshal(IngestRequest{Instance: instance, Status: &parser.Status{Version: 2, UpdatedAt: updated, Clients: []parser.Client{
+		{CommonName: "alice", RealAddress: "1.1.1.1:1000", ConnectedSince: time.Date(2024, 2, 23, 9, 0, 0, 0, time.UTC), BytesReceived: received, BytesSent: 1},
+	}}})
+	return string(body)
+}
+
+// TestIngest снимки агента сохраняются под его инстансом; повтор и старый снимок пропускаются,
+// имя локального инстанса занять нельзя
+func TestIngest(t *testing.T) {
+	h, db := newTestHandler(t)
+	if w := ingest(h, ingestBody("agent1", time.Now(), 1)); w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("без реестра: %d %s", w.Code, w.Body)
+	}
+	reg := collector.NewRegistry(db)
+	if _, err := reg.AddLocal("vpn1"); err != nil {
+		t.Fatal(err)
+	}
+	h.SetCollectors(reg)
+
+	at := time.Date(2024, 2, 23, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		body     string
+		code     int
+		status   string
+		received int64 // трафик alice на agent1 после запроса
+	}{
+		{"неверное тело", `{"instance":`, http.StatusBadRequest, "", 0},
+		{"без updated_at", `{"instance":"agent1","status":{"clients":[]}}`, http.StatusBadRequest, "", 0},
+		{"неверное имя", ingestBody("agent 1", at, 100), http.StatusBadRequest, "", 0},
+		{"локальный инстанс", ingestBody("vpn1", at, 100), http.StatusConflict, "", 0},
+		{"первый снимок", ingestBody("agent1", at, 100), http.StatusOK, "ok", 100},
+		{"следующий", ingestBody("agent1", at.Add(time.Minute), 300), http.StatusOK, "ok", 300},
+		{"повтор", ingestBody("agent1", at.Add(time.Minute), 300), http.StatusOK, "skipped", 300},
+		{"старый", ingestBody("agent1", at, 200), http.StatusOK, "skipped", 300},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := ingest(h, tt.body)
+			if w.Code != tt.code {
+				t.Fatalf("код %d, ожидался %d: %s", w.Code, tt.code, w.Body)
+			}
+			var resp map[string]interface{}
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if tt.status != "" && resp["status"] != tt.status {
+				t.Errorf("status %v, ожидался %s", resp["status"], tt.status)
+			}
+			rows, err := db.GetInstanceTotals(database.Filter{Instance: "agent1"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got int64
+			for _, r := range rows {
+				got += r.BytesReceived
+			}
+			if got != tt.received {
+				t.Errorf("трафик agent1 %d, ожидалось %d", got, tt.received)
+			}
+		})
+	}
+	if names, _ := db.Instances(); len(names) != 1 || names[0] != "agent1" {
+		t.Errorf("инстансы %v", names)
+	}
+}
@@ -0,0 +1,144 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"open-statistic/internal/collector"
+	"open-statistic/internal/database"
+
+	"github.com/gin-gonic/gin"
+)
+
+// KillRequest тело POST /connected/:name/kill
+type KillRequest struct {
+	RealAddress string `json:"real_address"` // отключить только сессию с этого адреса
+	Instance    string `json:"instance"`     // только на этом инстансе (или ?instance=)
+	Reason      string `json:"reason"`
+	RequestedBy string `json:"requested_by"` // необязательная пометка, в журнал попадает как note
+}
+
+// KillClient godoc
+// @Summary Отключить клиента через management-интерфейс OpenVPN
+// @Tags traffic
+// @Param name path string true "Common Name клиента"
+// @Param body body KillRequest true "reason обязателен; real_address, instance, requested_by (пометка) — опционально"
+// @Produce json
+// @Success 200 {array} database.KillRecord
+// @Router /connected/{name}/kill [post]
+func (h *Handler) KillClient(c *gin.Context) {
+	name := c.Param("name")
+	var req KillRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body"})
+		return
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.Reason == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "reason обязателен"})
+		return
+	}
+	if req.Instance == "" {
+		req.Instance = c.Query("instance")
+	}
+
+	// Инстансы, где клиент сейчас подключён
+	clients, err := h.db.GetLatestSnapshot(database.Filter{Instance: req.Instance})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	var instances []string
+	seen := make(map[string]bool)
+	for _, cl := range clients {
+		if cl.CommonName != name || (req.RealAddress != "" && cl.RealAddress != req.RealAddress) || seen[cl.Instance] {
+			continue
+		}
+		seen[cl.Instance] = true
+		instances = append(instances, cl.Instance)
+	}
+	if len(instances) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "клиент не подключён"})
+		return
+	}
+
+	target := name
+	if req.RealAddress != "" {
+		target = req.RealAddress
+	}
+	records := make([]database.KillRecord, 0, len(instances))
+	var killed, unavailable int
+	for _, inst := range instances {
+		rec := database.KillRecord{
+			At:          time.Now().UTC(),
+			Instance:    inst,
+			CommonName:  name,
+			RealAddress: req.RealAddress,
+			Reason:      req.Reason,
+			RequestedBy: AuthName(c),
+			Note:        strings.TrimSpace(req.RequestedBy),
+			RemoteAddr:  c.ClientIP(),
+		}
+		col := h.findCollector(inst)
+		if col == nil {
+			err = collector.ErrNoManagement
+		} else {
+			rec.Result, err = col.Kill(c.Request.Context(), target)
+		}
+		switch {
+		case err == nil:
+			killed++
+		case errors.Is(err, collector.ErrNoManagement):
+			unavailable++
+			rec.Error = err.Error()
+		default:
+			rec.Error = err.Error()
+		}
+		if rec.ID, err = h.db.LogKill(rec); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		records = append(records, rec)
+	}
+
+	switch {
+	case killed > 0:
+		c.JSON(http.StatusOK, gin.H{"status": "ok", "killed": records})
+	case unavailable == len(records):
+		c.JSON(http.StatusConflict, gin.H{"error": collector.ErrNoManagement.Error(), "killed": records})
+	default:
+		c.JSON(http.StatusBadGateway, gin.H{"error": "OpenVPN не отключил клиента", "killed": records})
+	}
+}
+
+// GetKillLog godoc
+// @Summary Журнал принудительных отключений: кто, кого, почему
+// @Tags admin
+// @Param name query string false "Common Name"
+// @Param instance query string false "Имя инстанса"
+// @Param from query string false "RFC 3339 или YYYY-MM-DD"
+// @Param to query string false "RFC 3339 или YYYY-MM-DD"
+// @Param limit query int false "По умолчанию 100, максимум 1000"
+// @Produce json
+// @Success 200 {array} database.KillRecord
+// @Router /admin/kills [get]
+func (h *Handler) GetKillLog(c *gin.Context) {
+	f, err := parseRange(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	limit, err := parseLimitParam(c.Query("limit"), 100, 1000)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	records, err := h.db.GetKillLog(c.Query("name"), f, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"kills": records})
+}
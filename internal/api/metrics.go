@@ -0,0 +1,195 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"open-statistic/internal/collector"
+
+	"github.com/gin-gonic/gin"
+)
+
+const metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// SetCollectors подключает реестр сборщиков: их статистика отдаётся в /metrics,
+// через него же принимаются снимки агентов
+func (h *Handler) SetCollectors(reg *collector.Registry) {
+	h.collectors = reg
+}
+
+func (h *Handler) findCollector(instance string) *collector.Collector {
+	if h.collectors == nil {
+		return nil
+	}
+	return h.collectors.Get(instance)
+}
+
+func (h *Handler) allCollectors() []*collector.Collector {
+	if h.collectors == nil {
+		return nil
+	}
+	return h.collectors.All()
+}
+
+// GetMetrics godoc
+// @Summary Метрики в формате Prometheus
+// @Tags metrics
+// @Param instance query string false "Имя инстанса"
+// @Produce plain
+// @Router /metrics [get]
+func (h *Handler) GetMetrics(c *gin.Context) {
+	f := instanceFilter(c)
+	clients, err := h.db.GetLatestSnapshot(f)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	totals, err := h.db.GetInstanceTotals(f)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	aliases := h.db.LoadAllAliases()
+
+	var w metricsWriter
+	w.help("openstat_client_bytes_received", "gauge", "Байт получено от клиента в текущей сессии (последний снимок)")
+	for _, cl := range clients {
+		w.sample("openstat_client_bytes_received", clientLabels(cl.CommonName, cl.RealAddress, aliases, cl.Instance), float64(cl.BytesReceived))
+	}
+	w.help("openstat_client_bytes_sent", "gauge", "Байт отправлено клиенту в текущей сессии (последний снимок)")
+	for _, cl := range clients {
+		w.sample("openstat_client_bytes_sent", clientLabels(cl.CommonName, cl.RealAddress, aliases, cl.Instance), float64(cl.BytesSent))
+	}
+	w.help("openstat_client_connected_since_seconds", "gauge", "Время подключения клиента, unix-время")
+	for _, cl := range clients {
+		if cl.ConnectedSince.IsZero() {
+			continue
+		}
+		w.sample("openstat_client_connected_since_seconds", clientLabels(cl.CommonName, cl.RealAddress, aliases, cl.Instance), float64(cl.ConnectedSince.Unix()))
+	}
+
+	w.help("openstat_user_bytes_received_total", "counter", "Накопленный трафик от пользователя, байт")
+	for _, t := range totals {
+		w.sample("openstat_user_bytes_received_total", clientLabels(t.CommonName, "", aliases, t.Instance), float64(t.BytesReceived))
+	}
+	w.help("openstat_user_bytes_sent_total", "counter", "Накопленный трафик к пользователю, байт")
+	for _, t := range totals {
+		w.sample("openstat_user_bytes_sent_total", clientLabels(t.CommonName, "", aliases, t.Instance), float64(t.BytesSent))
+	}
+
+	// Инстансы без подключений тоже попадают в метрику со значением 0
+	all := h.allCollectors()
+	connected := make(map[string]int)
+	instances := make([]string, 0, len(all))
+	for _, col := range all {
+		if f.Instance == "" || col.Instance() == f.Instance {
+			connected[col.Instance()] = 0
+			instances = append(instances, col.Instance())
+		}
+	}
+	for _, cl := range clients {
+		if _, ok := connected[cl.Instance]; !ok {
+			instances = append(instances, cl.Instance)
+		}
+		connected[cl.Instance]++
+	}
+	w.help("openstat_connected_clients", "gauge", "Число подключённых клиентов (последний снимок)")
+	for _, inst := range instances {
+		w.sample("openstat_connected_clients", [][2]string{{"instance", inst}}, float64(connected[inst]))
+	}
+
+	cols := make([]*collector.Collector, 0, len(all))
+	for _, col := range all {
+		if f.Instance == "" || col.Instance() == f.Instance {
+			cols = append(cols, col)
+		}
+	}
+	if len(cols) > 0 {
+		w.help("openstat_collector_runs_total", "counter", "Запусков сбора статистики")
+		for _, col := range cols {
+			w.sample("openstat_collector_runs_total", collectorLabels(col), float64(col.Stats().Runs))
+		}
+		w.help("openstat_collector_errors_total", "counter", "Неудачных запусков сбора")
+		for _, col := range cols {
+			w.sample("openstat_collector_errors_total", collectorLabels(col), float64(col.Stats().Errors))
+		}
+		w.help("openstat_collector_skipped_total", "counter", "Снимков, пропущенных как повтор предыдущего")
+		for _, col := range cols {
+			w.sample("openstat_collector_skipped_total", collectorLabels(col), float64(col.Stats().Skipped))
+		}
+		w.help("openstat_collector_parse_errors_total", "counter", "Ошибок разбора status-файла")
+		for _, col := range cols {
+			w.sample("openstat_collector_parse_errors_total", collectorLabels(col), float64(col.Stats().ParseErrors))
+		}
+		w.help("openstat_collector_last_duration_seconds", "gauge", "Длительность последнего успешного сбора")
+		for _, col := range cols {
+			w.sample("openstat_collector_last_duration_seconds", collectorLabels(col), col.Stats().LastDuration.Seconds())
+		}
+		w.help("openstat_collector_last_success_timestamp_seconds", "gauge", "Время последнего успешного сбора, unix-время")
+		for _, col := range cols {
+			if st := col.Stats(); !st.LastSuccess.IsZero() {
+				w.sample("openstat_collector_last_success_timestamp_seconds", collectorLabels(col), float64(st.LastSuccess.Unix()))
+			}
+		}
+	}
+
+	if h.events != nil {
+		w.help("openstat_events_subscribers", "gauge", "Подключённых клиентов /events")
+		w.sample("openstat_events_subscribers", nil, float64(h.events.Subscribers()))
+		w.help("openstat_events_dropped_total", "counter", "Клиентов /events, отключённых за то, что не успевали читать")
+		w.sample("openstat_events_dropped_total", nil, float64(h.events.Dropped()))
+	}
+
+	c.Data(http.StatusOK, metricsContentType, w.buf.Bytes())
+}
+
+func collectorLabels(col *collector.Collector) [][2]string {
+	return [][2]string{{"instance", col.Instance()}}
+}
+
+func clientLabels(commonName, realAddress string, aliases map[string]string, instance string) [][2]string {
+	labels := [][2]string{{"common_name", commonName}}
+	if realAddress != "" {
+		labels = append(labels, [2]string{"real_address", realAddress})
+	}
+	alias, ok := aliases[commonName+"|"+realAddress]
+	if !ok {
+		alias = aliases[commonName]
+	}
+	labels = append(labels, [2]string{"alias", alias}, [2]string{"instance", instance})
+	return labels
+}
+
+// metricsWriter пишет text exposition format Prometheus
+type metricsWriter struct {
+	buf bytes.Buffer
+}
+
+func (w *metricsWriter) help(name, typ, help string) {
+	fmt.Fprintf(&w.buf, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func (w *metricsWriter) sample(name string, labels [][2]string, value float64) {
+	w.buf.WriteString(name)
+	if len(labels) > 0 {
+		w.buf.WriteByte('{')
+		for i, l := range labels {
+			if i > 0 {
+				w.buf.WriteByte(',')
+			}
+			w.buf.WriteString(l[0])
+			w.buf.WriteString(`="`)
+			w.buf.WriteString(labelEscaper.Replace(l[1]))
+			w.buf.WriteByte('"')
+		}
+		w.buf.WriteByte('}')
+	}
+	w.buf.WriteByte(' ')
+	w.buf.WriteString(strconv.FormatFloat(value, 'f', -1, 64))
+	w.buf.WriteByte('\n')
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
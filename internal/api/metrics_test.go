@@ -0,0 +1,79 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"open-statistic/internal/collector"
+	"open-statistic/internal/parser"
+)
+
+func TestMetricsSampleEscaping(t *testing.T) {
+	tests := []struct {
+		name   string
+		labels [][2]string
+		value  float64
+		want   string
+	}{
+		{"без меток", nil, 3, "m 3\n"},
+		{"обычные", [][2]string{{"common_name", "alice"}, {"instance", "vpn1"}}, 1500, `m{common_name="alice",instance="vpn1"} 1500` + "\n"},
+		{"кавычки", [][2]string{{"alias", `Ivan "boss"`}}, 1, `m{alias="Ivan \"boss\""} 1` + "\n"},
+		{"обратный слэш", [][2]string{{"common_name", `DOMAIN\user`}}, 1, `m{common_name="DOMAIN\\user"} 1` + "\n"},
+		{"перевод строки", [][2]string{{"alias", "a\nb"}}, 1, `m{alias="a\nb"} 1` + "\n"},
+		{"дробное значение", nil, 0.25, "m 0.25\n"},
+		{"большое значение без экспоненты", nil, 1e12, "m 1000000000000\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var w metricsWriter
+			w.sample("m", tt.labels, tt.value)
+			if got := w.buf.String(); got != tt.want {
+				t.Errorf("%q, ожидалось %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestGetMetrics метки клиентов с алиасом и инстансы без подключений
+func TestGetMetrics(t *testing.T) {
+	h, db := newTestHandler(t)
+	reg := collector.NewRegistry(db)
+	h.SetCollectors(reg)
+	if _, err := reg.AddLocal("vpn2"); err != nil {
+		t.Fatal(err)
+	}
+	col, err := reg.AddLocal("vpn1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := time.Date(2024, 2, 23, 12, 0, 0, 0, time.UTC)
+	if _, err := col.Ingest(&parser.Status{Version: 2, UpdatedAt: at, Clients: []parser.Client{
+		{CommonName: "alice", RealAddress: "1.1.1.1:1000", ConnectedSince: at.Add(-time.Hour), BytesReceived: 100, BytesSent: 10},
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetAlias("alice", "", `Alice "A"`); err != nil {
+		t.Fatal(err)
+	}
+
+	c, w := testContext("/metrics")
+	h.GetMetrics(c)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != metricsContentType {
+		t.Fatalf("код %d, Content-Type %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	body := w.Body.String()
+	for _, want := range []string{
+		`openstat_client_bytes_received{common_name="alice",real_address="1.1.1.1:1000",alias="Alice \"A\"",instance="vpn1"} 100`,
+		`openstat_user_bytes_sent_total{common_name="alice",alias="Alice \"A\"",instance="vpn1"} 10`,
+		`openstat_connected_clients{instance="vpn1"} 1`,
+		`openstat_connected_clients{instance="vpn2"} 0`,
+		`openstat_collector_runs_total{instance="vpn1"} 1`,
+		"# TYPE openstat_user_bytes_received_total counter",
+	} {
+		if !strings.Contains(body, want+"\n") {
+			t.Errorf("нет строки %s", want)
+		}
+	}
+}
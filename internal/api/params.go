@@ -0,0 +1,125 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"open-statistic/internal/database"
+
+	"github.com/gin-gonic/gin"
+)
+
+const dateLayout = "2006-01-02"
+
+// parseTimeParam разбирает время из query-параметра: RFC 3339 или YYYY-MM-DD (начало дня UTC)
+func parseTimeParam(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t.UTC(), nil
+	}
+	if t, err := time.Parse(dateLayout, s); err == nil {
+		return t.UTC(), nil
+	}
+	return time.Time{}, fmt.Errorf("неверный формат времени %q: ожидается RFC 3339 или YYYY-MM-DD", s)
+}
+
+// parseBoolParam разбирает необязательный флаг: "", "1"/"true", "0"/"false"
+func parseBoolParam(s string) (*bool, error) {
+	if s == "" {
+		return nil, nil
+	}
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return nil, fmt.Errorf("неверное значение %q: ожидается true/false", s)
+	}
+	return &b, nil
+}
+
+// parseLimitParam разбирает limit с ограничением сверху
+func parseLimitParam(s string, def, max int) (int, error) {
+	if s == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("неверный limit %q", s)
+	}
+	if n > max {
+		n = max
+	}
+	return n, nil
+}
+
+// instanceFilter фильтр только по инстансу (?instance=)
+func instanceFilter(c *gin.Context) database.Filter {
+	return database.Filter{Instance: c.Query("instance")}
+}
+
+// parseRange разбирает from/to и instance из query. to без времени (YYYY-MM-DD) включает весь этот день
+func parseRange(c *gin.Context) (database.Filter, error) {
+	f := instanceFilter(c)
+	var err error
+	if f.From, err = parseTimeParam(c.Query("from")); err != nil {
+		return f, err
+	}
+	to := c.Query("to")
+	if f.To, err = parseTimeParam(to); err != nil {
+		return f, err
+	}
+	if _, err := time.Parse(dateLayout, to); err == nil {
+		f.To = f.To.AddDate(0, 0, 1)
+	}
+	if !f.From.IsZero() && !f.To.IsZero() && !f.From.Before(f.To) {
+		return f, fmt.Errorf("from должен быть раньше to")
+	}
+	return f, nil
+}
+
+// listMaxLimit наибольший размер страницы списков
+const listMaxLimit = 10000
+
+// parseListOptions разбирает фильтры, сортировку и страницу списка:
+// name, alias, min_bytes, cidr, sort, limit, cursor
+func parseListOptions(c *gin.Context) (database.ListOptions, error) {
+	o := database.ListOptions{
+		Name:   c.Query("name"),
+		Alias:  c.Query("alias"),
+		CIDR:   c.Query("cidr"),
+		Sort:   c.Query("sort"),
+		Cursor: c.Query("cursor"),
+	}
+	var err error
+	if o.Limit, err = parseLimitParam(c.Query("limit"), 0, listMaxLimit); err != nil {
+		return o, err
+	}
+	if s := c.Query("min_bytes"); s != "" {
+		if o.MinBytes, err = strconv.ParseInt(s, 10, 64); err != nil || o.MinBytes < 0 {
+			return o, fmt.Errorf("неверный min_bytes %q", s)
+		}
+	}
+	return o, nil
+}
+
+// listError отвечает на ошибку выборки списка: неверные параметры — 400
+func listError(c *gin.Context, err error) {
+	if errors.Is(err, database.ErrInvalidList) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
+
+// pathID разбирает :id из пути; при ошибке отвечает 400
+func pathID(c *gin.Context) (int64, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "неверный id"})
+		return 0, false
+	}
+	return id, true
+}
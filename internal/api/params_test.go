@@ -0,0 +1,41 @@
+package api
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRange(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2026, 9, d, 0, 0, 0, 0, time.UTC) }
+	tests := []struct {
+		name     string
+		query    string
+		from, to time.Time
+		instance string
+		ok       bool
+	}{
+		{"без интервала", "", time.Time{}, time.Time{}, "", true},
+		{"даты: to включает весь день", "from=2026-09-01&to=2026-09-30", day(1), day(30).AddDate(0, 0, 1), "", true},
+		{"один день", "from=2026-09-05&to=2026-09-05", day(5), day(6), "", true},
+		{"RFC 3339 переводится в UTC", "from=2026-09-01T03:00:00%2B03:00&to=2026-09-01T12:00:00Z", day(1), day(1).Add(12 * time.Hour), "", true},
+		{"только from и инстанс", "from=2026-09-01&instance=vpn1", day(1), time.Time{}, "vpn1", true},
+		{"from после to", "from=2026-09-02&to=2026-09-01T12:00:00Z", time.Time{}, time.Time{}, "", false},
+		{"from равен to", "from=2026-09-01T00:00:00Z&to=2026-09-01T00:00:00Z", time.Time{}, time.Time{}, "", false},
+		{"неверное время", "from=yesterday", time.Time{}, time.Time{}, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := testContext("/traffic/total?" + tt.query)
+			f, err := parseRange(c)
+			if (err == nil) != tt.ok {
+				t.Fatalf("parseRange() = %+v, %v", f, err)
+			}
+			if !tt.ok {
+				return
+			}
+			if !f.From.Equal(tt.from) || !f.To.Equal(tt.to) || f.Instance != tt.instance {
+				t.Errorf("parseRange() = %+v", f)
+			}
+		})
+	}
+}
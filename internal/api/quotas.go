@@ -0,0 +1,241 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"open-statistic/internal/database"
+
+	"github.com/gin-gonic/gin"
+)
+
+// QuotaRequest тело POST/PUT /quotas
+type QuotaRequest struct {
+	CommonName string `json:"common_name"`
+	Group      string `json:"group"`
+	Period     string `json:"period"`      // daily | monthly
+	LimitBytes int64  `json:"limit_bytes"` // получено + отправлено
+	Action     string `json:"action"`      // log | webhook | disconnect
+	WebhookURL string `json:"webhook_url"`
+}
+
+func (r QuotaRequest) quota() database.Quota {
+	return database.Quota{
+		CommonName: strings.TrimSpace(r.CommonName),
+		Group:      strings.TrimSpace(r.Group),
+		Period:     r.Period,
+		LimitBytes: r.LimitBytes,
+		Action:     r.Action,
+		WebhookURL: r.WebhookURL,
+	}
+}
+
+// GetQuotas godoc
+// @Summary Список квот
+// @Tags quotas
+// @Produce json
+// @Success 200 {array} database.Quota
+// @Router /quotas [get]
+func (h *Handler) GetQuotas(c *gin.Context) {
+	quotas, err := h.db.GetQuotas()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"quotas": quotas})
+}
+
+// GetQuota godoc
+// @Summary Квота по id
+// @Tags quotas
+// @Param id path int true "id квоты"
+// @Produce json
+// @Success 200 {object} database.Quota
+// @Router /quotas/{id} [get]
+func (h *Handler) GetQuota(c *gin.Context) {
+	id, ok := pathID(c)
+	if !ok {
+		return
+	}
+	q, err := h.db.GetQuota(id)
+	if err != nil {
+		quotaError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, q)
+}
+
+// CreateQuota godoc
+// @Summary Создать квоту для пользователя или группы
+// @Tags quotas
+// @Param body body QuotaRequest true "common_name или group, period, limit_bytes, action, webhook_url"
+// @Produce json
+// @Success 201 {object} database.Quota
+// @Router /quotas [post]
+func (h *Handler) CreateQuota(c *gin.Context) {
+	var req QuotaRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body"})
+		return
+	}
+	q := req.quota()
+	if q.Action == database.QuotaActionWebhook && !h.checkWebhook(c, q.WebhookURL) {
+		return
+	}
+	if err := h.db.CreateQuota(&q); err != nil {
+		quotaError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, q)
+}
+
+// UpdateQuota godoc
+// @Summary Изменить квоту (превышения в текущем периоде проверяются заново)
+// @Tags quotas
+// @Param id path int true "id квоты"
+// @Param body body QuotaRequest true "Квота целиком"
+// @Produce json
+// @Success 200 {object} database.Quota
+// @Router /quotas/{id} [put]
+func (h *Handler) UpdateQuota(c *gin.Context) {
+	id, ok := pathID(c)
+	if !ok {
+		return
+	}
+	var req QuotaRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body"})
+		return
+	}
+	q := req.quota()
+	q.ID = id
+	if q.Action == database.QuotaActionWebhook && !h.checkWebhook(c, q.WebhookURL) {
+		return
+	}
+	if err := h.db.UpdateQuota(&q); err != nil {
+		quotaError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, q)
+}
+
+// DeleteQuota godoc
+// @Summary Удалить квоту
+// @Tags quotas
+// @Param id path int true "id квоты"
+// @Router /quotas/{id} [delete]
+func (h *Handler) DeleteQuota(c *gin.Context) {
+	id, ok := pathID(c)
+	if !ok {
+		return
+	}
+	if err := h.db.DeleteQuota(id); err != nil {
+		quotaError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// GetQuotaGroups godoc
+// @Summary Группы квот и их участники
+// @Tags quotas
+// @Produce json
+// @Router /quotas/groups [get]
+func (h *Handler) GetQuotaGroups(c *gin.Context) {
+	groups, err := h.db.GetQuotaGroups()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"groups": groups})
+}
+
+// SetQuotaGroup godoc
+// @Summary Задать состав группы (пустой members удаляет группу)
+// @Tags quotas
+// @Param group path string true "Имя группы"
+// @Param body body object true "members: список Common Name"
+// @Router /quotas/groups/{group} [put]
+func (h *Handler) SetQuotaGroup(c *gin.Context) {
+	var body struct {
+		Members []string `json:"members"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body"})
+		return
+	}
+	members := make([]string, 0, len(body.Members))
+	for _, m := range body.Members {
+		if m = strings.TrimSpace(m); m != "" {
+			members = append(members, m)
+		}
+	}
+	if err := h.db.SetQuotaGroup(c.Param("group"), members); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "group": c.Param("group"), "members": members})
+}
+
+// GetUserQuota godoc
+// @Summary Квоты пользователя: расход, остаток и дата сброса
+// @Tags users
+// @Param name path string true "Common Name пользователя"
+// @Produce json
+// @Success 200 {array} database.QuotaUsage
+// @Router /users/{name}/quota [get]
+func (h *Handler) GetUserQuota(c *gin.Context) {
+	name := c.Param("name")
+	quotas, err := h.db.QuotasForUser(name)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	now := time.Now()
+	out := make([]*database.QuotaUsage, 0, len(quotas))
+	for _, q := range quotas {
+		u, err := h.db.GetQuotaUsage(q, name, now)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		out = append(out, u)
+	}
+	if c.Query("human") == "1" {
+		items := make([]gin.H, 0, len(out))
+		for _, u := range out {
+			items = append(items, gin.H{
+				"id":           u.ID,
+				"group":        u.Group,
+				"period":       u.Period,
+				"action":       u.Action,
+				"limit":        FormatBytes(u.LimitBytes),
+				"used":         FormatBytes(u.UsedBytes),
+				"remaining":    FormatBytes(u.RemainingBytes),
+				"period_start": u.PeriodStart,
+				"reset_at":     u.ResetAt,
+				"exceeded":     u.Exceeded,
+				"exceeded_at":  u.ExceededAt,
+			})
+		}
+		c.JSON(http.StatusOK, gin.H{"common_name": name, "quotas": items})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"common_name": name, "quotas": out})
+}
+
+// quotaError ответ на ошибку квот: не найдена — 404, не прошла проверку — 400, дубликат — 409
+func quotaError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, database.ErrQuotaNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, database.ErrInvalidQuota):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, database.ErrQuotaExists):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
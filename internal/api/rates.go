@@ -0,0 +1,40 @@
+package api
+
+import (
+	"math"
+
+	"open-statistic/internal/database"
+
+	"github.com/gin-gonic/gin"
+)
+
+// formatRate скорость для ответа: байт/с с двумя знаками или, с human, "1.5 MB/s". nil — неизвестна
+func formatRate(r *float64, human bool) interface{} {
+	if r == nil {
+		return nil
+	}
+	if human {
+		return FormatBytes(int64(math.Round(*r))) + "/s"
+	}
+	return math.Round(*r*100) / 100
+}
+
+// rateFields поля скорости сессии для ответа
+func rateFields(r database.Rates, human bool) gin.H {
+	return gin.H{
+		"rate_received":      formatRate(r.RateReceived, human),
+		"rate_sent":          formatRate(r.RateSent, human),
+		"peak_rate_received": formatRate(&r.PeakRateReceived, human),
+		"peak_rate_sent":     formatRate(&r.PeakRateSent, human),
+		"avg_rate_received":  formatRate(&r.AvgRateReceived, human),
+		"avg_rate_sent":      formatRate(&r.AvgRateSent, human),
+	}
+}
+
+// addRate прибавляет скорость к сумме (nil — суммы ещё нет)
+func addRate(sum *float64, r float64) *float64 {
+	if sum != nil {
+		r += *sum
+	}
+	return &r
+}
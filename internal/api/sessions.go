@@ -0,0 +1,106 @@
+package api
+
+import (
+	"net/http"
+
+	"open-statistic/internal/database"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetSessions godoc
+// @Summary История сессий (подключение, отключение, длительность, трафик)
+// @Tags sessions
+// @Param name query string false "Common Name"
+// @Param instance query string false "Имя инстанса"
+// @Param real_address query string false "Реальный адрес клиента"
+// @Param active query bool false "true — только открытые, false — только завершённые"
+// @Param from query string false "RFC 3339 или YYYY-MM-DD"
+// @Param to query string false "RFC 3339 или YYYY-MM-DD"
+// @Param limit query int false "По умолчанию 100, максимум 1000"
+// @Produce json
+// @Success 200 {array} database.Session
+// @Router /sessions [get]
+func (h *Handler) GetSessions(c *gin.Context) {
+	h.listSessions(c, c.Query("name"))
+}
+
+// GetUserSessions godoc
+// @Summary История сессий пользователя
+// @Tags users
+// @Param name path string true "Common Name пользователя"
+// @Produce json
+// @Success 200 {array} database.Session
+// @Router /users/{name}/sessions [get]
+func (h *Handler) GetUserSessions(c *gin.Context) {
+	name := c.Param("name")
+	if name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "имя пользователя обязательно"})
+		return
+	}
+	h.listSessions(c, name)
+}
+
+func (h *Handler) listSessions(c *gin.Context, name string) {
+	f := database.SessionFilter{CommonName: name, Instance: c.Query("instance"), RealAddress: c.Query("real_address")}
+	var err error
+	if f.Active, err = parseBoolParam(c.Query("active")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	rng, err := parseRange(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	f.From, f.To = rng.From, rng.To
+	if f.Limit, err = parseLimitParam(c.Query("limit"), 100, 1000); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	sessions, err := h.db.GetSessions(f)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	aliases := h.db.LoadAllAliases()
+	human := c.Query("human") == "1"
+	out := make([]gin.H, 0, len(sessions))
+	for _, s := range sessions {
+		item := gin.H{
+			"id":               s.ID,
+			"instance":         s.Instance,
+			"common_name":      s.CommonName,
+			"real_address":     s.RealAddress,
+			"virtual_address":  s.VirtualAddr,
+			"connected_since":  s.ConnectedSince,
+			"first_seen_at":    s.FirstSeenAt,
+			"last_seen_at":     s.LastSeenAt,
+			"ended_at":         s.EndedAt,
+			"active":           s.Active(),
+			"duration_seconds": int64(s.Duration().Seconds()),
+		}
+		if human {
+			item["bytes_received"] = FormatBytes(s.BytesReceived)
+			item["bytes_sent"] = FormatBytes(s.BytesSent)
+			item["duration"] = s.Duration().String()
+		} else {
+			item["bytes_received"] = s.BytesReceived
+			item["bytes_sent"] = s.BytesSent
+		}
+		if s.Username != "" {
+			item["username"] = s.Username
+		}
+		if s.Cipher != "" {
+			item["cipher"] = s.Cipher
+		}
+		if a, ok := aliases[s.CommonName+"|"+s.RealAddress]; ok {
+			item["alias"] = a
+		} else if a, ok := aliases[s.CommonName]; ok {
+			item["alias"] = a
+		}
+		out = append(out, item)
+	}
+	c.JSON(http.StatusOK, gin.H{"sessions": out})
+}
@@ -0,0 +1,140 @@
+package api
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"open-statistic/internal/database"
+
+	"github.com/gin-gonic/gin"
+)
+
+// topMaxN наибольшее число пользователей в рейтинге
+const topMaxN = 1000
+
+// parsePeriodParam разбирает длину периода: Go-длительность ("24h"), дни или недели ("7d", "2w"),
+// а также day, week и month (30 дней)
+func parsePeriodParam(s string) (time.Duration, error) {
+	switch s {
+	case "day":
+		return 24 * time.Hour, nil
+	case "week":
+		return 7 * 24 * time.Hour, nil
+	case "month":
+		return 30 * 24 * time.Hour, nil
+	}
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if v, ok := strings.CutSuffix(s, suffix); ok {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("неверный period %q", s)
+			}
+			return time.Duration(n) * unit, nil
+		}
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("неверный period %q: ожидается 24h, 7d, 2w, day, week или month", s)
+	}
+	return d, nil
+}
+
+// percent доля a от b в процентах с двумя знаками; nil, если b = 0
+func percent(a, b int64) *float64 {
+	if b == 0 {
+		return nil
+	}
+	p := math.Round(float64(a)/float64(b)*10000) / 100
+	return &p
+}
+
+// GetTopTraffic godoc
+// @Summary Рейтинг пользователей по трафику за период
+// @Tags traffic
+// @Param period query string false "Длина периода: 24h, 7d, 2w, day, week, month (по умолчанию 24h)"
+// @Param n query int false "Сколько пользователей вернуть (по умолчанию 10)"
+// @Param by query string false "total, sent или received (по умолчанию total)"
+// @Param instance query string false "Имя инстанса"
+// @Produce json
+// @Success 200 {object} database.TopReport
+// @Router /traffic/top [get]
+func (h *Handler) GetTopTraffic(c *gin.Context) {
+	period := c.DefaultQuery("period", "24h")
+	d, err := parsePeriodParam(period)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	n, err := parseLimitParam(c.Query("n"), 10, topMaxN)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "неверный n"})
+		return
+	}
+	by := c.DefaultQuery("by", database.TopByTotal)
+	switch by {
+	case database.TopByTotal, database.TopBySent, database.TopByReceived:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "by: допустимо total, sent или received"})
+		return
+	}
+
+	now := time.Now().UTC()
+	cur := instanceFilter(c)
+	cur.From, cur.To = now.Add(-d), now
+	prev := instanceFilter(c)
+	prev.From, prev.To = now.Add(-2*d), cur.From
+	report, err := h.db.GetTopTraffic(cur, prev, by, n)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	human := c.Query("human") == "1"
+	bytes := func(b int64) interface{} {
+		switch {
+		case !human:
+			return b
+		case b < 0:
+			return "-" + FormatBytes(-b)
+		}
+		return FormatBytes(b)
+	}
+	aliases := h.db.LoadAllAliases()
+	out := make([]gin.H, 0, len(report.Users))
+	for _, t := range report.Users {
+		item := gin.H{
+			"rank":           t.Rank,
+			"common_name":    t.CommonName,
+			"bytes_received": bytes(t.BytesReceived),
+			"bytes_sent":     bytes(t.BytesSent),
+			"total_bytes":    bytes(t.TotalBytes),
+			"share_percent":  percent(t.Value, report.Total),
+			"previous_bytes": bytes(t.PreviousValue),
+			"change_bytes":   bytes(t.Value - t.PreviousValue),
+			// Без трафика в предыдущем периоде изменение в процентах не определено: null
+			"change_percent": percent(t.Value-t.PreviousValue, t.PreviousValue),
+		}
+		if t.PreviousRank > 0 {
+			item["previous_rank"] = t.PreviousRank
+		}
+		if a, ok := aliases[t.CommonName]; ok {
+			item["alias"] = a
+		}
+		out = append(out, item)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"period":                period,
+		"by":                    by,
+		"from":                  cur.From,
+		"to":                    cur.To,
+		"previous_from":         prev.From,
+		"period_bytes":          bytes(report.Total),
+		"previous_period_bytes": bytes(report.PreviousTotal),
+		"change_percent":        percent(report.Total-report.PreviousTotal, report.PreviousTotal),
+		"top":                   out,
+	})
+}
@@ -0,0 +1,151 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"open-statistic/internal/parser"
+)
+
+func TestPercent(t *testing.T) {
+	tests := []struct {
+		a, b int64
+		want *float64
+	}{
+		{1, 3, ptr(33.33)},
+		{2, 3, ptr(66.67)},
+		{-550, 1100, ptr(-50)},
+		{5, 0, nil},
+	}
+	for _, tt := range tests {
+		got := percent(tt.a, tt.b)
+		if (got == nil) != (tt.want == nil) || got != nil && *got != *tt.want {
+			t.Errorf("percent(%d, %d) = %v, ожидалось %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func ptr(v float64) *float64 {
+	return &v
+}
+
+// TestTopTraffic доля в трафике периода и изменение к предыдущему периоду той же длины
+func TestTopTraffic(t *testing.T) {
+	h, db := newTestHandler(t)
+	now := time.Now().UTC()
+	aliceSince, bobSince := now.Add(-51*time.Hour), now.Add(-3*time.Hour)
+	alice := func(r, s int64) parser.Client {
+		return parser.Client{CommonName: "alice", RealAddress: "1.1.1.1:1000", ConnectedSince: aliceSince, BytesReceived: r, BytesSent: s}
+	}
+	bob := func(r, s int64) parser.Client {
+		return parser.Client{CommonName: "bob", RealAddress: "2.2.2.2:2000", ConnectedSince: bobSince, BytesReceived: r, BytesSent: s}
+	}
+	// Предыдущие сутки: alice 500/50; последние сутки: alice 1000/100, bob 3000/0
+	for _, s := range []struct {
+		ago     time.Duration
+		clients []parser.Client
+	}{
+		{50 * time.Hour, []parser.Client{alice(100, 10)}},
+		{30 * time.Hour, []parser.Client{alice(600, 60)}},
+		{2 * time.Hour, []parser.Client{alice(600, 60), bob(0, 0)}},
+		{time.Hour, []parser.Client{alice(1600, 160), bob(3000, 0)}},
+	} {
+		if err := db.SaveSnapshot("vpn1", &parser.Status{Version: 2, UpdatedAt: now.Add(-s.ago), Clients: s.clients}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	type user struct {
+		Rank          int      `json:"rank"`
+		CommonName    string   `json:"common_name"`
+		SharePercent  *float64 `json:"share_percent"`
+		PreviousBytes int64    `json:"previous_bytes"`
+		ChangeBytes   int64    `json:"change_bytes"`
+		ChangePercent *float64 `json:"change_percent"`
+		PreviousRank  int      `json:"previous_rank"`
+	}
+	tests := []struct {
+		by            string
+		periodBytes   int64
+		previousBytes int64
+		change        *float64
+		users         []user
+	}{
+		{"total", 4100, 550, ptr(645.45), []user{
+			{1, "bob", ptr(73.17), 0, 3000, nil, 0},
+			{2, "alice", ptr(26.83), 550, 550, ptr(100), 1},
+		}},
+		{"received", 4000, 500, ptr(700), []user{
+			{1, "bob", ptr(75), 0, 3000, nil, 0},
+			{2, "alice", ptr(25), 500, 500, ptr(100), 1},
+		}},
+		// Без отправленного трафика bob в рейтинг не попадает
+		{"sent", 100, 50, ptr(100), []user{
+			{1, "alice", ptr(100), 50, 50, ptr(100), 1},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.by, func(t *testing.T) {
+			c, w := testContext("/traffic/top?period=day&by=" + tt.by)
+			h.GetTopTraffic(c)
+			if w.Code != http.StatusOK {
+				t.Fatalf("код %d: %s", w.Code, w.Body)
+			}
+			var got struct {
+				PeriodBytes   int64    `json:"period_bytes"`
+				PreviousBytes int64    `json:"previous_period_bytes"`
+				ChangePercent *float64 `json:"change_percent"`
+				Top           []user   `json:"top"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			if got.PeriodBytes != tt.periodBytes || got.PreviousBytes != tt.previousBytes || !samePercent(got.ChangePercent, tt.change) {
+				t.Errorf("период %d, предыдущий %d, изменение %v", got.PeriodBytes, got.PreviousBytes, got.ChangePercent)
+			}
+			if len(got.Top) != len(tt.users) {
+				t.Fatalf("рейтинг %+v", got.Top)
+			}
+			for i, want := range tt.users {
+				u := got.Top[i]
+				if u.Rank != want.Rank || u.CommonName != want.CommonName || !samePercent(u.SharePercent, want.SharePercent) ||
+					u.PreviousBytes != want.PreviousBytes || u.ChangeBytes != want.ChangeBytes ||
+					!samePercent(u.ChangePercent, want.ChangePercent) || u.PreviousRank != want.PreviousRank {
+					t.Errorf("место %d: %+v", i+1, u)
+				}
+			}
+		})
+	}
+}
+
+func samePercent(a, b *float64) bool {
+	return a == nil && b == nil || a != nil && b != nil && *a == *b
+}
+
+func TestParsePeriodParam(t *testing.T) {
+	day := 24 * time.Hour
+	tests := []struct {
+		in   string
+		want time.Duration
+		ok   bool
+	}{
+		{"24h", day, true},
+		{"90m", 90 * time.Minute, true},
+		{"7d", 7 * day, true},
+		{"2w", 14 * day, true},
+		{"week", 7 * day, true},
+		{"month", 30 * day, true},
+		{"0d", 0, false},
+		{"-1h", 0, false},
+		{"xd", 0, false},
+		{"year", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := parsePeriodParam(tt.in)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("parsePeriodParam(%q) = %v, %v", tt.in, got, err)
+		}
+	}
+}
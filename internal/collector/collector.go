@@ -0,0 +1,202 @@
+package collector
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"open-statistic/internal/database"
+	"open-statistic/internal/management"
+	"open-statistic/internal/parser"
+)
+
+// Stats счётчики работы сборщика (для /metrics)
+type Stats struct {
+	Runs         int64         `json:"runs"`
+	Errors       int64         `json:"errors"`
+	ParseErrors  int64         `json:"parse_errors"`
+	Skipped      int64         `json:"skipped"` // снимков, не сохранённых как повтор предыдущего
+	LastDuration time.Duration `json:"last_duration"`
+	LastSuccess  time.Time     `json:"last_success"`
+	LastStatusAt time.Time     `json:"last_status_at"` // время обновления (Updated) последнего прочитанного снимка
+	LastError    string        `json:"last_error,omitempty"`
+	LastErrorAt  time.Time     `json:"last_error_at"`
+}
+
+// Collector читает status-файл одного OpenVPN-инстанса (или принимает снимки от агента),
+// сохраняет их и ведёт статистику запусков
+type Collector struct {
+	db       *database.DB
+	instance string
+	remote   bool // снимки приходят от агента через Ingest
+
+	saveMu      sync.Mutex // проверка на повтор и сохранение снимка — одна операция
+	lastLoaded  bool       // lastUpdated прочитан из БД
+	lastUpdated time.Time  // Updated последнего сохранённого снимка
+	lastHash    [sha256.Size]byte
+
+	mu           sync.RWMutex
+	stats        Stats
+	mgmtAddr     string // management-интерфейс для kill (пусто — отключать нельзя)
+	mgmtPassword string
+	mgmt         *management.Client // соединение ManagementSource, пока оно открыто
+	onSave       SaveHook
+}
+
+// SaveHook вызывается после каждого сохранённого снимка (проверка квот и т.п.)
+type SaveHook func(instance string, status *parser.Status)
+
+// New создаёт сборщик. instance — имя OpenVPN-инстанса, под которым сохраняются снимки
+func New(db *database.DB, instance string) *Collector {
+	if instance == "" {
+		instance = database.DefaultInstance
+	}
+	return &Collector{db: db, instance: instance}
+}
+
+// Instance имя OpenVPN-инстанса
+func (c *Collector) Instance() string {
+	return c.instance
+}
+
+// Причины пропуска снимка (Result.Reason)
+const (
+	SkipSameUpdated = "updated_unchanged" // Updated совпадает с последним сохранённым снимком
+	SkipSameContent = "content_unchanged" // содержимое status-файла не изменилось
+	SkipNotNewer    = "not_newer"         // снимок агента не новее уже принятого
+	SkipIncomplete  = "incomplete"        // status-файл пуст или переписывается
+)
+
+// Result итог одного сбора
+type Result struct {
+	Instance  string    `json:"instance"`
+	Skipped   bool      `json:"skipped"`
+	Reason    string    `json:"reason,omitempty"`
+	Clients   int       `json:"clients"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// CollectFile читает, парсит и сохраняет status-файл. Без строки Updated временем снимка
+// считается время изменения файла. Если OpenVPN ещё не переписал файл (тот же Updated или
+// то же содержимое), снимок не сохраняется: повтор раздул бы traffic_snapshots
+func (c *Collector) CollectFile(path string) (Result, error) {
+	start := time.Now()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		c.fail(err, false)
+		return Result{Instance: c.instance}, err
+	}
+	status, err := parser.ParseBytes(data)
+	if err != nil {
+		err = fmt.Errorf("%s: %w", path, err)
+		c.fail(err, true)
+		return Result{Instance: c.instance}, err
+	}
+	if status.UpdatedAt.IsZero() {
+		if fi, err := os.Stat(path); err == nil {
+			status.UpdatedAt = fi.ModTime().UTC()
+		}
+	}
+	return c.store(status, sha256.Sum256(data), false, start)
+}
+
+// Ingest сохраняет снимок, присланный агентом. Снимок не новее уже принятого пропускается:
+// агент может повторить отправку, если не дождался ответа, а старый снимок после нового
+// исказил бы приращения
+func (c *Collector) Ingest(status *parser.Status) (Result, error) {
+	return c.store(status, [sha256.Size]byte{}, true, time.Now())
+}
+
+// store сохраняет снимок, если он не повторяет предыдущий. hash — хеш исходного вывода
+// (нулевой — не сравнивать), strict — пропускать и снимки старше последнего
+func (c *Collector) store(status *parser.Status, hash [sha256.Size]byte, strict bool, start time.Time) (Result, error) {
+	res := Result{Instance: c.instance, Clients: len(status.Clients), UpdatedAt: status.UpdatedAt}
+	c.saveMu.Lock()
+	defer c.saveMu.Unlock()
+	if !c.lastLoaded {
+		last, err := c.db.LastSnapshotAt(c.instance)
+		if err != nil {
+			err = fmt.Errorf("последний снимок: %w", err)
+			c.fail(err, false)
+			return res, err
+		}
+		c.lastUpdated, c.lastLoaded = last, true
+		// После перезапуска файл может не меняться (OpenVPN остановлен): возраст снимка
+		// для /health считается от сохранённого, а не от момента запуска
+		c.mu.Lock()
+		if c.stats.LastStatusAt.IsZero() {
+			c.stats.LastStatusAt = last
+		}
+		c.mu.Unlock()
+	}
+	switch {
+	case status.Incomplete():
+		// Пустой снимок завершил бы все сессии: ждём, пока OpenVPN допишет файл
+		res.Reason = SkipIncomplete
+	case strict && !status.UpdatedAt.After(c.lastUpdated):
+		res.Reason = SkipNotNewer
+	case !status.UpdatedAt.IsZero() && status.UpdatedAt.Equal(c.lastUpdated):
+		res.Reason = SkipSameUpdated
+	case hash != [sha256.Size]byte{} && hash == c.lastHash:
+		res.Reason = SkipSameContent
+	}
+	if res.Reason != "" {
+		res.Skipped = true
+		c.mu.Lock()
+		c.stats.Runs++
+		c.stats.Skipped++
+		c.stats.LastDuration = time.Since(start)
+		c.stats.LastSuccess = time.Now()
+		// У недописанного файла времени Updated нет, а старый снимок не должен молодить статус
+		if !status.Incomplete() && status.UpdatedAt.After(c.stats.LastStatusAt) {
+			c.stats.LastStatusAt = status.UpdatedAt
+		}
+		c.mu.Unlock()
+		return res, nil
+	}
+	if err := c.save(status, start); err != nil {
+		return res, err
+	}
+	c.lastUpdated, c.lastHash = status.UpdatedAt, hash
+	return res, nil
+}
+
+func (c *Collector) save(status *parser.Status, start time.Time) error {
+	if err := c.db.SaveSnapshot(c.instance, status); err != nil {
+		err = fmt.Errorf("сохранение снимка: %w", err)
+		c.fail(err, false)
+		return err
+	}
+	c.mu.Lock()
+	c.stats.Runs++
+	c.stats.LastDuration = time.Since(start)
+	c.stats.LastSuccess = time.Now()
+	c.stats.LastStatusAt = status.UpdatedAt
+	hook := c.onSave
+	c.mu.Unlock()
+	if hook != nil {
+		hook(c.instance, status)
+	}
+	return nil
+}
+
+// Stats возвращает копию счётчиков
+func (c *Collector) Stats() Stats {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.stats
+}
+
+func (c *Collector) fail(err error, parse bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stats.Runs++
+	c.stats.Errors++
+	if parse {
+		c.stats.ParseErrors++
+	}
+	c.stats.LastError = err.Error()
+	c.stats.LastErrorAt = time.Now()
+}
@@ -0,0 +1,58 @@
+package collector
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"open-statistic/internal/database"
+)
+
+// TestLastStatusAtAfterRestart после перезапуска неизменившийся status-файл пропускается как повтор,
+// но время Updated берётся из него, а не остаётся нулевым (иначе /health не заметит остановку OpenVPN)
+func TestLastStatusAtAfterRestart(t *testing.T) {
+	dir := t.TempDir()
+	db, err := database.New(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	path := filepath.Join(dir, "status.log")
+	data := "TITLE,OpenVPN 2.6.8\n" +
+		"TIME,Tue Feb 23 12:00:00 2024,1708689600\n" +
+		"HEADER,CLIENT_LIST,Common Name,Real Address,Virtual Address,Bytes Received,Bytes Sent,Connected Since (time_t)\n" +
+		"CLIENT_LIST,alice,1.2.3.4:5555,10.8.0.2,1000,100,1708689000\n" +
+		"END\n"
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	updated := time.Unix(1708689600, 0)
+
+	if res, err := New(db, "vpn1").CollectFile(path); err != nil || res.Skipped {
+		t.Fatalf("первый сбор: %+v, %v", res, err)
+	}
+
+	c := New(db, "vpn1")
+	res, err := c.CollectFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Skipped || res.Reason != SkipSameUpdated {
+		t.Errorf("повтор после перезапуска: %+v", res)
+	}
+	if got := c.Stats().LastStatusAt; !got.Equal(updated) {
+		t.Errorf("LastStatusAt = %v, ожидалось %v", got, updated)
+	}
+
+	// Недописанный файл не сдвигает время статуса
+	if err := os.WriteFile(path, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if res, err := c.CollectFile(path); err != nil || res.Reason != SkipIncomplete {
+		t.Errorf("пустой файл: %+v, %v", res, err)
+	}
+	if got := c.Stats().LastStatusAt; !got.Equal(updated) {
+		t.Errorf("LastStatusAt после пустого файла = %v", got)
+	}
+}
@@ -0,0 +1,56 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"open-statistic/internal/management"
+)
+
+// ErrNoManagement для инстанса не настроен management-интерфейс, отключить клиента нельзя
+var ErrNoManagement = errors.New("для инстанса не настроен management-интерфейс")
+
+// SetManagement задаёт management-интерфейс инстанса для отключения клиентов
+func (c *Collector) SetManagement(addr, password string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.mgmtAddr, c.mgmtPassword = addr, password
+}
+
+// CanKill можно ли отключать клиентов этого инстанса
+func (c *Collector) CanKill() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.mgmtAddr != ""
+}
+
+// setManagementClient соединение, через которое сейчас идёт сбор (nil — разорвано)
+func (c *Collector) setManagementClient(m *management.Client) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.mgmt = m
+}
+
+// Kill отключает клиента командой kill: target — Common Name или реальный адрес ip:port.
+// Используется соединение сборщика, а если его нет — management-интерфейс открывается на время команды
+func (c *Collector) Kill(ctx context.Context, target string) (string, error) {
+	c.mu.RLock()
+	m, addr, password := c.mgmt, c.mgmtAddr, c.mgmtPassword
+	c.mu.RUnlock()
+	if addr == "" {
+		return "", ErrNoManagement
+	}
+	if m == nil {
+		dialCtx, cancel := context.WithTimeout(ctx, managementDialTimeout)
+		defer cancel()
+		var err error
+		if m, err = management.Dial(dialCtx, addr, password); err != nil {
+			return "", err
+		}
+		defer m.Close()
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	return m.Kill(ctx, target)
+}
@@ -0,0 +1,206 @@
+package collector
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"log"
+	"time"
+
+	"open-statistic/internal/database"
+	"open-statistic/internal/management"
+	"open-statistic/internal/parser"
+)
+
+const (
+	managementDialTimeout   = 10 * time.Second
+	managementStatusTimeout = 30 * time.Second
+	// после подключения клиента снимок снимается чуть позже, чтобы сессия сразу попала в БД
+	managementRefreshDelay = time.Second
+	managementAuthTimeout  = 10 * time.Second
+)
+
+// RunManagement собирает статистику через management-интерфейс до отмены ctx,
+// переподключаясь при обрыве соединения
+func (c *Collector) RunManagement(ctx context.Context, src ManagementSource) {
+	backoff := time.Second
+	for ctx.Err() == nil {
+		start := time.Now()
+		err := c.runManagementConn(ctx, src)
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Since(start) > time.Minute {
+			backoff = time.Second
+		}
+		c.fail(fmt.Errorf("management %s: %w", src.Addr, err), false)
+		log.Printf("Management [%s]: %v, переподключение через %s", c.instance, err, backoff)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > time.Minute {
+			backoff = time.Minute
+		}
+	}
+}
+
+// sessionRef сессия клиента по его Client ID из последнего status 3
+type sessionRef struct {
+	commonName     string
+	realAddress    string
+	virtualAddr    string
+	username       string
+	connectedSince time.Time
+}
+
+func (c *Collector) runManagementConn(ctx context.Context, src ManagementSource) error {
+	dialCtx, cancel := context.WithTimeout(ctx, managementDialTimeout)
+	m, err := management.Dial(dialCtx, src.Addr, src.Password)
+	cancel()
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+	c.setManagementClient(m)
+	defer c.setManagementClient(nil)
+	log.Printf("Management [%s]: подключено к %s", c.instance, src.Addr)
+
+	if src.Bytecount > 0 {
+		secs := int(src.Bytecount.Seconds())
+		if secs < 1 {
+			secs = 1
+		}
+		if _, err := m.Command(ctx, fmt.Sprintf("bytecount %d", secs)); err != nil {
+			return err
+		}
+	}
+
+	sessions := make(map[int64]sessionRef)
+	bytecounts := make(map[int64][2]int64)
+	poll := func() error {
+		status, err := c.collectManagement(ctx, m)
+		if err != nil {
+			return err
+		}
+		clear(sessions)
+		for _, cl := range status.Clients {
+			if cl.ClientID != nil {
+				sessions[*cl.ClientID] = sessionRef{cl.CommonName, cl.RealAddress, cl.VirtualAddr, cl.Username, cl.ConnectedSince}
+			}
+		}
+		return nil
+	}
+	if err := poll(); err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(src.Interval)
+	defer ticker.Stop()
+	refresh := time.NewTimer(managementRefreshDelay)
+	refresh.Stop()
+	defer refresh.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-m.Done():
+			if err := m.Err(); err != nil {
+				return err
+			}
+			return management.ErrClosed
+		case <-ticker.C:
+			if err := poll(); err != nil {
+				return err
+			}
+		case <-refresh.C:
+			if err := poll(); err != nil {
+				return err
+			}
+		case ev := <-m.Events():
+			switch ev.Type {
+			case management.EventConnect, management.EventReauth:
+				if src.ClientAuth {
+					c.authorize(ctx, m, ev)
+				}
+			case management.EventEstablished:
+				refresh.Reset(managementRefreshDelay)
+			case management.EventBytecount:
+				bytecounts[ev.ClientID] = [2]int64{ev.BytesIn, ev.BytesOut}
+			case management.EventDisconnect:
+				c.endSession(ev, sessions[ev.ClientID], bytecounts[ev.ClientID])
+				delete(sessions, ev.ClientID)
+				delete(bytecounts, ev.ClientID)
+			}
+		}
+	}
+}
+
+// authorize пропускает клиента, уже прошедшего проверку сертификата: openstat только собирает
+// статистику и решений об авторизации не принимает
+func (c *Collector) authorize(ctx context.Context, m *management.Client, ev management.Event) {
+	actx, cancel := context.WithTimeout(ctx, managementAuthTimeout)
+	defer cancel()
+	if err := m.ClientAuthNT(actx, ev.ClientID, ev.KeyID); err != nil {
+		c.fail(fmt.Errorf("client-auth-nt %s: %w", ev.CommonName(), err), false)
+		log.Printf("Management [%s]: авторизация %s (cid %d): %v", c.instance, ev.CommonName(), ev.ClientID, err)
+	}
+}
+
+// collectManagement снимает status 3 и сохраняет снимок
+func (c *Collector) collectManagement(ctx context.Context, m *management.Client) (*parser.Status, error) {
+	start := time.Now()
+	sctx, cancel := context.WithTimeout(ctx, managementStatusTimeout)
+	data, err := m.Status(sctx)
+	cancel()
+	if err != nil {
+		return nil, err
+	}
+	status, err := parser.ParseBytes(data)
+	if err != nil {
+		err = fmt.Errorf("status 3: %w", err)
+		c.fail(err, true)
+		return nil, err
+	}
+	if status.UpdatedAt.IsZero() {
+		status.UpdatedAt = start.UTC() // вывод status 3 снят только что
+	}
+	// вывод status 3 каждый раз новый (TIME), повтором считается только тот же Updated —
+	// например, опрос по таймеру и после подключения клиента в одну секунду
+	if _, err := c.store(status, [sha256.Size]byte{}, false, start); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// endSession завершает сессию по >CLIENT:DISCONNECT: ключ сессии берётся из последнего
+// status 3 (по Client ID), итоговые байты — из ENV, иначе из последнего >BYTECOUNT_CLI
+func (c *Collector) endSession(ev management.Event, ref sessionRef, counts [2]int64) {
+	if ref.commonName == "" {
+		ref = sessionRef{
+			commonName:     ev.CommonName(),
+			realAddress:    ev.RealAddress(),
+			virtualAddr:    ev.Env["ifconfig_pool_remote_ip"],
+			username:       ev.Env["username"],
+			connectedSince: ev.ConnectedSince(),
+		}
+	}
+	end := database.SessionEnd{
+		CommonName:     ref.commonName,
+		RealAddress:    ref.realAddress,
+		VirtualAddr:    ref.virtualAddr,
+		Username:       ref.username,
+		ConnectedSince: ref.connectedSince,
+		EndedAt:        time.Now().UTC(),
+		BytesReceived:  counts[0],
+		BytesSent:      counts[1],
+	}
+	if r, s, ok := ev.Bytes(); ok {
+		end.BytesReceived, end.BytesSent = r, s
+	}
+	if err := c.db.EndSession(c.instance, end); err != nil {
+		c.fail(fmt.Errorf("завершение сессии %s: %w", end.CommonName, err), false)
+		log.Printf("Management [%s]: завершение сессии %s: %v", c.instance, end.CommonName, err)
+	}
+}
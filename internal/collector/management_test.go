@@ -0,0 +1,307 @@
+package collector
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"open-statistic/internal/database"
+)
+
+// fakeOpenVPN management-интерфейс с паролем: отдаёт status 3 со списком clients,
+// отвечает на kill, bytecount и client-auth-nt
+type fakeOpenVPN struct {
+	ln net.Listener
+
+	mu      sync.Mutex
+	conn    net.Conn
+	clients []string // строки CLIENT_LIST после тега, через табуляцию
+	polls   int      // сколько раз выполнен status 3
+	authed  []string // полученные client-auth-nt
+}
+
+const fakePassword = "secret"
+
+func newFakeOpenVPN(t *testing.T, clients ...string) *fakeOpenVPN {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeOpenVPN{ln: ln, clients: clients}
+	t.Cleanup(func() {
+		ln.Close()
+		s.mu.Lock()
+		if s.conn != nil {
+			s.conn.Close()
+		}
+		s.mu.Unlock()
+	})
+	go s.serve()
+	return s
+}
+
+func (s *fakeOpenVPN) serve() {
+	conn, err := s.ln.Accept()
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	s.conn = conn
+	s.mu.Unlock()
+	r := bufio.NewReader(conn)
+	s.write("ENTER PASSWORD:")
+	if line, err := r.ReadString('\n'); err != nil || strings.TrimSpace(line) != fakePassword {
+		s.write("ERROR: bad password\n")
+		conn.Close()
+		return
+	}
+	s.write("SUCCESS: password is correct\n")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.TrimSpace(line)
+		switch {
+		case cmd == "status 3":
+			s.write(s.status())
+		case strings.HasPrefix(cmd, "client-auth-nt "):
+			s.mu.Lock()
+			s.authed = append(s.authed, strings.TrimPrefix(cmd, "client-auth-nt "))
+			s.mu.Unlock()
+			s.write("SUCCESS: client-auth command succeeded\n")
+		case strings.HasPrefix(cmd, "bytecount "):
+			s.write("SUCCESS: bytecount interval changed\n")
+		case cmd == "kill alice":
+			s.write("SUCCESS: common name 'alice' found, 1 client(s) killed\n")
+		case strings.HasPrefix(cmd, "kill "):
+			s.write("ERROR: common name '" + strings.TrimPrefix(cmd, "kill ") + "' not found\n")
+		default:
+			s.write("ERROR: unknown command, enter 'help' for more options\n")
+		}
+	}
+}
+
+// status вывод status 3; TIME растёт с каждым опросом, иначе снимок сочли бы повтором
+func (s *fakeOpenVPN) status() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.polls++
+	at := time.Unix(1708689600, 0).Add(time.Duration(s.polls) * time.Minute).UTC()
+	var b strings.Builder
+	fmt.Fprintf(&b, "TITLE\tOpenVPN 2.6.8\nTIME\t%s\t%d\n", at.Format("Mon Jan 2 15:04:05 2006"), at.Unix())
+	b.WriteString("HEADER\tCLIENT_LIST\tCommon Name\tReal Address\tVirtual Address\tBytes Received\tBytes Sent\tConnected Since (time_t)\tClient ID\n")
+	for _, cl := range s.clients {
+		b.WriteString("CLIENT_LIST\t" + cl + "\n")
+	}
+	b.WriteString("END\n")
+	return b.String()
+}
+
+func (s *fakeOpenVPN) setClients(clients ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clients = clients
+}
+
+func (s *fakeOpenVPN) pollCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.polls
+}
+
+func (s *fakeOpenVPN) authorized() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.authed...)
+}
+
+func (s *fakeOpenVPN) write(data string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	io.WriteString(s.conn, data)
+}
+
+func (s *fakeOpenVPN) push(lines ...string) {
+	for _, l := range lines {
+		s.write(l + "\n")
+	}
+}
+
+// eventually ждёт, пока cond не вернёт true
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("не дождались: %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestManagementSessionLifecycle(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	db, err := database.New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	// Второе соединение к той же БД — смотреть служебные таблицы
+	raw, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Close()
+
+	srv := newFakeOpenVPN(t, "alice\t1.2.3.4:5555\t10.8.0.2\t1000\t100\t1708689000\t7")
+	c := New(db, "vpn1")
+	c.SetManagement(srv.ln.Addr().String(), fakePassword)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		c.RunManagement(ctx, ManagementSource{Addr: srv.ln.Addr().String(), Password: fakePassword, Interval: time.Hour})
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	total := func(name string) [2]int64 {
+		tr, err := db.GetTotalTraffic(name, database.Filter{Instance: "vpn1"})
+		if err != nil {
+			return [2]int64{-1, -1}
+		}
+		return [2]int64{tr.BytesReceived, tr.BytesSent}
+	}
+	lastBytes := func() int {
+		var n int
+		if err := raw.QueryRow("SELECT COUNT(*) FROM session_last_bytes WHERE instance = 'vpn1'").Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	eventually(t, "первый status 3", func() bool { return total("alice") == [2]int64{1000, 100} })
+	if n := lastBytes(); n != 1 {
+		t.Fatalf("session_last_bytes: %d строк", n)
+	}
+
+	// Подключение: ESTABLISHED с блоком ENV вызывает внеочередной status 3
+	srv.setClients(
+		"alice\t1.2.3.4:5555\t10.8.0.2\t1200\t120\t1708689000\t7",
+		"bob\t5.6.7.8:6666\t10.8.0.3\t50\t5\t1708689700\t8",
+	)
+	polls := srv.pollCount()
+	srv.push(
+		">CLIENT:ESTABLISHED,8",
+		">CLIENT:ENV,common_name=bob",
+		">CLIENT:ENV,trusted_ip=5.6.7.8",
+		">CLIENT:ENV,trusted_port=6666",
+		">CLIENT:ENV,END",
+	)
+	eventually(t, "status 3 после подключения", func() bool { return srv.pollCount() > polls && total("bob") == [2]int64{50, 5} })
+	if got := total("alice"); got != [2]int64{1200, 120} {
+		t.Errorf("alice после второго снимка %v", got)
+	}
+
+	// Отключение: трафик после последнего снимка берётся из ENV, состояние сессии удаляется
+	srv.push(
+		">CLIENT:DISCONNECT,7",
+		">CLIENT:ENV,common_name=alice",
+		">CLIENT:ENV,trusted_ip=1.2.3.4",
+		">CLIENT:ENV,trusted_port=5555",
+		">CLIENT:ENV,bytes_received=1500",
+		">CLIENT:ENV,bytes_sent=150",
+		">CLIENT:ENV,END",
+	)
+	eventually(t, "хвост сессии alice", func() bool { return total("alice") == [2]int64{1500, 150} })
+	if n := lastBytes(); n != 1 {
+		t.Errorf("session_last_bytes после отключения alice: %d строк, ожидалась 1 (bob)", n)
+	}
+	var ended sql.NullTime
+	if err := raw.QueryRow(`SELECT s.ended_at FROM sessions s JOIN users u ON u.id = s.user_id WHERE u.common_name = 'alice'`).Scan(&ended); err != nil {
+		t.Fatal(err)
+	}
+	if !ended.Valid {
+		t.Error("сессия alice не завершена")
+	}
+
+	// kill через соединение сборщика
+	kctx, kcancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer kcancel()
+	if msg, err := c.Kill(kctx, "alice"); err != nil || !strings.Contains(msg, "1 client(s) killed") {
+		t.Errorf("kill alice: %q, %v", msg, err)
+	}
+	if _, err := c.Kill(kctx, "carol"); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("kill carol: %v", err)
+	}
+}
+
+// TestManagementClientAuth с ClientAuth сборщик пропускает клиентов из >CLIENT:CONNECT и REAUTH,
+// без него — не отвечает на них
+func TestManagementClientAuth(t *testing.T) {
+	tests := []struct {
+		name       string
+		clientAuth bool
+		want       []string
+	}{
+		{"включено", true, []string{"3 0", "3 2"}},
+		{"выключено", false, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := database.New(filepath.Join(t.TempDir(), "test.db"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			srv := newFakeOpenVPN(t)
+			c := New(db, "vpn1")
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			go func() {
+				c.RunManagement(ctx, ManagementSource{Addr: srv.ln.Addr().String(), Password: fakePassword, Interval: time.Hour, ClientAuth: tt.clientAuth})
+				close(done)
+			}()
+			defer func() {
+				cancel()
+				<-done
+			}()
+
+			eventually(t, "первый status 3", func() bool { return srv.pollCount() > 0 })
+			srv.push(
+				">CLIENT:CONNECT,3,0",
+				">CLIENT:ENV,common_name=bob",
+				">CLIENT:ENV,END",
+				">CLIENT:REAUTH,3,2",
+				">CLIENT:ENV,common_name=bob",
+				">CLIENT:ENV,END",
+				// ESTABLISHED вызывает status 3: по нему видно, что уведомления выше обработаны
+				">CLIENT:ESTABLISHED,3",
+				">CLIENT:ENV,common_name=bob",
+				">CLIENT:ENV,END",
+			)
+			eventually(t, "status 3 после ESTABLISHED", func() bool { return srv.pollCount() > 1 })
+			got := srv.authorized()
+			if len(got) != len(tt.want) {
+				t.Fatalf("client-auth-nt: %q, ожидалось %q", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("client-auth-nt %d: %q, ожидалось %q", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
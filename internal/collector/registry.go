@@ -0,0 +1,103 @@
+package collector
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sync"
+
+	"open-statistic/internal/database"
+)
+
+// ErrLocalInstance снимок от агента пришёл под именем инстанса, который собирается локально
+var ErrLocalInstance = errors.New("инстанс собирается из локального status-файла")
+
+var instanceNameRe = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+// ValidInstanceName допустимое имя инстанса: латиница, цифры, _ . -, до 64 символов
+func ValidInstanceName(name string) bool {
+	return instanceNameRe.MatchString(name)
+}
+
+// Registry сборщики по инстансам: локальные status-файлы и удалённые агенты
+type Registry struct {
+	db *database.DB
+
+	mu     sync.RWMutex
+	byName map[string]*Collector
+	order  []*Collector
+	onSave SaveHook
+}
+
+// NewRegistry создаёт пустой реестр
+func NewRegistry(db *database.DB) *Registry {
+	return &Registry{db: db, byName: make(map[string]*Collector)}
+}
+
+// AddLocal регистрирует сборщик локального status-файла
+func (r *Registry) AddLocal(instance string) (*Collector, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.byName[instance]; ok {
+		return nil, fmt.Errorf("инстанс %q указан дважды", instance)
+	}
+	c := New(r.db, instance)
+	r.add(c)
+	return c, nil
+}
+
+// Remote возвращает сборщик для снимков агента, создавая его при первом обращении
+func (r *Registry) Remote(instance string) (*Collector, error) {
+	if !ValidInstanceName(instance) {
+		return nil, fmt.Errorf("недопустимое имя инстанса %q", instance)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if c, ok := r.byName[instance]; ok {
+		if !c.remote {
+			return nil, ErrLocalInstance
+		}
+		return c, nil
+	}
+	c := New(r.db, instance)
+	c.remote = true
+	r.add(c)
+	return c, nil
+}
+
+// OnSave задаёт hook после сохранения снимка для всех сборщиков, в том числе будущих агентов
+func (r *Registry) OnSave(hook SaveHook) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.onSave = hook
+	for _, c := range r.order {
+		c.mu.Lock()
+		c.onSave = hook
+		c.mu.Unlock()
+	}
+}
+
+func (r *Registry) add(c *Collector) {
+	c.onSave = r.onSave
+	r.byName[c.instance] = c
+	r.order = append(r.order, c)
+}
+
+// Get сборщик инстанса или nil
+func (r *Registry) Get(instance string) *Collector {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.byName[instance]
+}
+
+// All сборщики в порядке регистрации
+func (r *Registry) All() []*Collector {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]*Collector(nil), r.order...)
+}
+
+// Remote снимки приходят от агента
+func (c *Collector) Remote() bool {
+	return c.remote
+}
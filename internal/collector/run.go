@@ -0,0 +1,83 @@
+package collector
+
+import (
+	"context"
+	"log"
+	"os"
+	"time"
+)
+
+// RunOptions режим периодического сбора
+type RunOptions struct {
+	Interval time.Duration // период опроса
+	Watch    bool          // собирать сразу после перезаписи файла; при недоступности — опрос
+	Debounce time.Duration // тишина после последнего изменения файла перед сбором
+}
+
+// watcher сообщает об изменениях status-файла
+type watcher interface {
+	Events() <-chan struct{} // закрывается, если слежение прервалось
+	Close() error
+}
+
+// Run собирает статистику из path до отмены ctx: по событиям файловой системы или опросом
+func (c *Collector) Run(ctx context.Context, path string, opts RunOptions) {
+	if opts.Watch {
+		w, err := newWatcher(path)
+		if err == nil {
+			log.Printf("Слежение за %s (debounce %s)", path, opts.Debounce)
+			if c.watch(ctx, path, w, opts.Debounce) {
+				return
+			}
+			log.Printf("Слежение за %s прервано, опрос каждые %s", path, opts.Interval)
+		} else {
+			log.Printf("Слежение за %s недоступно (%v), опрос каждые %s", path, err, opts.Interval)
+		}
+	}
+	c.poll(ctx, path, opts.Interval)
+}
+
+func (c *Collector) poll(ctx context.Context, path string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.collectIfExists(path)
+		}
+	}
+}
+
+// watch собирает после каждой серии изменений файла. OpenVPN перезаписывает status-файл
+// несколькими write(), поэтому сбор откладывается, пока файл не затихнет на debounce.
+// Возвращает false, если слежение прервалось и нужно перейти на опрос
+func (c *Collector) watch(ctx context.Context, path string, w watcher, debounce time.Duration) bool {
+	defer w.Close()
+	timer := time.NewTimer(debounce)
+	timer.Stop()
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return true
+		case _, ok := <-w.Events():
+			if !ok {
+				return ctx.Err() != nil
+			}
+			timer.Reset(debounce)
+		case <-timer.C:
+			c.collectIfExists(path)
+		}
+	}
+}
+
+func (c *Collector) collectIfExists(path string) {
+	if _, err := os.Stat(path); err != nil {
+		return
+	}
+	if _, err := c.CollectFile(path); err != nil {
+		log.Printf("Сбор: %v", err)
+	}
+}
@@ -0,0 +1,95 @@
+package collector
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"open-statistic/internal/database"
+)
+
+// fakeWatcher события status-файла задаются тестом
+type fakeWatcher struct {
+	events chan struct{}
+	closed bool
+}
+
+func (w *fakeWatcher) Events() <-chan struct{} { return w.events }
+
+func (w *fakeWatcher) Close() error {
+	w.closed = true
+	return nil
+}
+
+const watchStatus = "TITLE,OpenVPN 2.6.8\n" +
+	"TIME,Tue Feb 23 12:00:00 2024,1708689600\n" +
+	"HEADER,CLIENT_LIST,Common Name,Real Address,Virtual Address,Bytes Received,Bytes Sent,Connected Since (time_t)\n" +
+	"CLIENT_LIST,alice,1.2.3.4:5555,10.8.0.2,1000,100,1708689000\n" +
+	"END\n"
+
+// TestWatch серия изменений файла даёт один сбор после debounce; обрыв слежения
+// возвращает false (переход на опрос), отмена ctx — true
+func TestWatch(t *testing.T) {
+	const debounce = 50 * time.Millisecond
+	tests := []struct {
+		name     string
+		events   int  // изменений файла подряд
+		file     bool // status-файл существует
+		stop     func(cancel context.CancelFunc, w *fakeWatcher)
+		want     bool
+		wantRuns int64
+	}{
+		{"серия изменений", 3, true, func(cancel context.CancelFunc, _ *fakeWatcher) { cancel() }, true, 1},
+		{"без изменений", 0, true, func(cancel context.CancelFunc, _ *fakeWatcher) { cancel() }, true, 0},
+		{"файла нет", 2, false, func(cancel context.CancelFunc, _ *fakeWatcher) { cancel() }, true, 0},
+		{"слежение прервано", 1, true, func(_ context.CancelFunc, w *fakeWatcher) { close(w.events) }, false, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			db, err := database.New(filepath.Join(dir, "test.db"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			path := filepath.Join(dir, "status.log")
+			if tt.file {
+				if err := os.WriteFile(path, []byte(watchStatus), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			c := New(db, "vpn1")
+			w := &fakeWatcher{events: make(chan struct{}, tt.events)}
+			for i := 0; i < tt.events; i++ {
+				w.events <- struct{}{}
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			done := make(chan bool, 1)
+			go func() { done <- c.watch(ctx, path, w, debounce) }()
+
+			if tt.wantRuns > 0 {
+				eventually(t, "сбор после debounce", func() bool { return c.Stats().Runs >= tt.wantRuns })
+			}
+			time.Sleep(3 * debounce) // лишних сборов не будет
+			tt.stop(cancel, w)
+			select {
+			case got := <-done:
+				if got != tt.want {
+					t.Errorf("watch = %v, ожидалось %v", got, tt.want)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("watch не завершился")
+			}
+			if runs := c.Stats().Runs; runs != tt.wantRuns {
+				t.Errorf("сборов %d, ожидалось %d", runs, tt.wantRuns)
+			}
+			if !w.closed {
+				t.Error("слежение не закрыто")
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package collector
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// Source откуда сборщик берёт снимки: status-файл или management-интерфейс OpenVPN
+type Source interface {
+	// Run собирает снимки в c до отмены ctx
+	Run(ctx context.Context, c *Collector)
+	String() string
+}
+
+// FileSource status-файл: опрос или слежение за изменениями
+type FileSource struct {
+	Path    string
+	Options RunOptions
+}
+
+func (s FileSource) Run(ctx context.Context, c *Collector) {
+	c.Run(ctx, s.Path, s.Options)
+}
+
+func (s FileSource) String() string {
+	if s.Options.Watch {
+		return s.Path + " (сбор при изменении)"
+	}
+	return s.Path + " (обновление каждые " + s.Options.Interval.String() + ")"
+}
+
+// ManagementSource management-интерфейс: status 3 по таймеру и уведомления о подключениях
+type ManagementSource struct {
+	Addr      string        // tcp://host:port или unix:///path
+	Password  string        // пароль management-интерфейса
+	Interval  time.Duration // период status 3
+	Bytecount time.Duration // период >BYTECOUNT_CLI; 0 — не включать
+	// ClientAuth отвечать client-auth-nt на >CLIENT:CONNECT/REAUTH. Нужно при management-client-auth:
+	// только с ним OpenVPN шлёт ESTABLISHED и DISCONNECT, но без ответа клиенты ждут авторизации
+	ClientAuth bool
+}
+
+func (s ManagementSource) Run(ctx context.Context, c *Collector) {
+	c.RunManagement(ctx, s)
+}
+
+func (s ManagementSource) String() string {
+	return s.Addr + " (management, status 3 каждые " + s.Interval.String() + ")"
+}
+
+// IsManagementAddr адрес management-интерфейса, а не путь к status-файлу
+func IsManagementAddr(s string) bool {
+	return strings.HasPrefix(s, "tcp://") || strings.HasPrefix(s, "unix://")
+}
@@ -0,0 +1,94 @@
+//go:build linux
+
+package collector
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+)
+
+// inotifyWatcher следит за каталогом status-файла, а не за самим файлом:
+// так переживаются замена файла через rename и его пересоздание
+type inotifyWatcher struct {
+	file   *os.File
+	name   string
+	events chan struct{}
+}
+
+func newWatcher(path string) (watcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Dir(path)
+	const mask = syscall.IN_MODIFY | syscall.IN_CLOSE_WRITE | syscall.IN_CREATE | syscall.IN_MOVED_TO
+	if _, err := syscall.InotifyAddWatch(fd, dir, mask); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	w := &inotifyWatcher{
+		// неблокирующий fd под netpoller: Close прерывает ожидающий Read
+		file:   os.NewFile(uintptr(fd), "inotify"),
+		name:   filepath.Base(path),
+		events: make(chan struct{}, 1),
+	}
+	go w.readLoop()
+	return w, nil
+}
+
+func (w *inotifyWatcher) Events() <-chan struct{} {
+	return w.events
+}
+
+func (w *inotifyWatcher) Close() error {
+	return w.file.Close()
+}
+
+func (w *inotifyWatcher) readLoop() {
+	defer close(w.events)
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			if errors.Is(err, syscall.EINTR) {
+				continue
+			}
+			return
+		}
+		matched := false
+		for off := 0; off+syscall.SizeofInotifyEvent <= n; {
+			ev := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[off]))
+			nameStart := off + syscall.SizeofInotifyEvent
+			nameEnd := nameStart + int(ev.Len)
+			if nameEnd > n {
+				break
+			}
+			if ev.Mask&syscall.IN_IGNORED != 0 {
+				// каталог удалён или размонтирован — дальше следить не за чем
+				return
+			}
+			if cstring(buf[nameStart:nameEnd]) == w.name {
+				matched = true
+			}
+			off = nameEnd
+		}
+		if matched {
+			select {
+			case w.events <- struct{}{}:
+			default: // событие уже ждёт обработки
+			}
+		}
+	}
+}
+
+func cstring(b []byte) string {
+	for i, c := range b {
+		if c == 0 {
+			return string(b[:i])
+		}
+	}
+	return string(b)
+}
@@ -0,0 +1,95 @@
+//go:build linux
+
+package collector
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestInotifyWatcher события приходят только для status-файла — при записи и при замене
+// через rename; удаление каталога прерывает слежение
+func TestInotifyWatcher(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "status.log")
+	w, err := newWatcher(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	expect := func(what string, want bool) {
+		t.Helper()
+		select {
+		case _, ok := <-w.Events():
+			if !ok {
+				t.Fatalf("%s: слежение прервано", what)
+			}
+			if !want {
+				t.Errorf("%s: лишнее событие", what)
+			}
+		case <-time.After(200 * time.Millisecond):
+			if want {
+				t.Errorf("%s: нет события", what)
+			}
+		}
+	}
+	tests := []struct {
+		name   string
+		change func() error
+		want   bool
+	}{
+		{"другой файл", func() error { return os.WriteFile(filepath.Join(dir, "other.log"), []byte("x"), 0o644) }, false},
+		{"создание", func() error { return os.WriteFile(path, []byte(watchStatus), 0o644) }, true},
+		{"дозапись", func() error {
+			f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			_, err = f.WriteString("\n")
+			return err
+		}, true},
+		{"замена через rename", func() error {
+			tmp := filepath.Join(dir, "status.log.tmp")
+			if err := os.WriteFile(tmp, []byte(watchStatus), 0o644); err != nil {
+				return err
+			}
+			return os.Rename(tmp, path)
+		}, true},
+	}
+	for _, tt := range tests {
+		if err := tt.change(); err != nil {
+			t.Fatal(err)
+		}
+		expect(tt.name, tt.want)
+		// события одной перезаписи сливаются в одно, хвост не должен попасть в следующий шаг
+		for drained := false; !drained; {
+			select {
+			case _, ok := <-w.Events():
+				if !ok {
+					t.Fatalf("%s: слежение прервано", tt.name)
+				}
+			case <-time.After(50 * time.Millisecond):
+				drained = true
+			}
+		}
+	}
+
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.After(5 * time.Second)
+	for {
+		select {
+		case _, ok := <-w.Events():
+			if !ok {
+				return
+			}
+		case <-deadline:
+			t.Fatal("после удаления каталога слежение не прервано")
+		}
+	}
+}
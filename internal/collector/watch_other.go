@@ -0,0 +1,9 @@
+//go:build !linux
+
+package collector
+
+import "errors"
+
+func newWatcher(path string) (watcher, error) {
+	return nil, errors.New("слежение за файлами поддерживается только в Linux")
+}
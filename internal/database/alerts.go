@@ -0,0 +1,359 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Типы правил алертов
+const (
+	AlertUserDailyBytes     = "user_daily_bytes"    // пользователь за сутки (UTC) превысил threshold байт
+	AlertConcurrentSessions = "concurrent_sessions" // у CN больше threshold одновременных сессий
+	AlertNewCountry         = "new_country"         // CN подключился из страны, откуда раньше не подключался
+	AlertStaleStatus        = "stale_status"        // снимок инстанса не обновлялся threshold секунд (по умолчанию 300)
+	AlertZeroConnected      = "zero_connected"      // число подключённых к инстансу упало до нуля
+)
+
+var (
+	// ErrAlertRuleNotFound правила с таким id нет
+	ErrAlertRuleNotFound = errors.New("правило не найдено")
+	// ErrInvalidAlertRule правило не прошло проверку
+	ErrInvalidAlertRule = errors.New("недопустимое правило")
+)
+
+// AlertRule правило алерта. CommonName и Instance ограничивают, к чему правило применяется
+type AlertRule struct {
+	ID              int64     `json:"id"`
+	Name            string    `json:"name"`
+	Type            string    `json:"type"`
+	CommonName      string    `json:"common_name,omitempty"`
+	Instance        string    `json:"instance,omitempty"`
+	Threshold       int64     `json:"threshold"`
+	WebhookURL      string    `json:"webhook_url"`
+	CooldownSeconds int64     `json:"cooldown_seconds"` // не чаще раза в cooldown для одного ключа; 0 — при каждом новом срабатывании
+	Enabled         bool      `json:"enabled"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+// Cooldown пауза между повторными уведомлениями
+func (r *AlertRule) Cooldown() time.Duration {
+	return time.Duration(r.CooldownSeconds) * time.Second
+}
+
+// Validate проверяет правило и подставляет порог по умолчанию
+func (r *AlertRule) Validate() error {
+	r.Name = strings.TrimSpace(r.Name)
+	if r.Name == "" {
+		return fmt.Errorf("%w: name обязателен", ErrInvalidAlertRule)
+	}
+	switch r.Type {
+	case AlertUserDailyBytes, AlertConcurrentSessions:
+		if r.Threshold <= 0 {
+			return fmt.Errorf("%w: threshold должен быть больше 0", ErrInvalidAlertRule)
+		}
+	case AlertStaleStatus:
+		if r.Threshold <= 0 {
+			r.Threshold = 300
+		}
+	case AlertNewCountry, AlertZeroConnected:
+	default:
+		return fmt.Errorf("%w: неизвестный type %q", ErrInvalidAlertRule, r.Type)
+	}
+	u, err := url.Parse(r.WebhookURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%w: нужен http(s) webhook_url", ErrInvalidAlertRule)
+	}
+	if r.CooldownSeconds < 0 {
+		return fmt.Errorf("%w: cooldown_seconds не может быть отрицательным", ErrInvalidAlertRule)
+	}
+	return nil
+}
+
+const alertRuleColumns = `id, name, type, common_name, instance, threshold, webhook_url, cooldown_seconds, enabled, created_at, updated_at`
+
+func scanAlertRule(row interface{ Scan(...interface{}) error }) (AlertRule, error) {
+	var r AlertRule
+	err := row.Scan(&r.ID, &r.Name, &r.Type, &r.CommonName, &r.Instance, &r.Threshold, &r.WebhookURL, &r.CooldownSeconds, &r.Enabled, &r.CreatedAt, &r.UpdatedAt)
+	return r, err
+}
+
+// GetAlertRules правила алертов; enabledOnly — только включённые
+func (db *DB) GetAlertRules(enabledOnly bool) ([]AlertRule, error) {
+	query := "SELECT " + alertRuleColumns + " FROM alert_rules"
+	if enabledOnly {
+		query += " WHERE enabled = 1"
+	}
+	rows, err := db.conn.Query(query + " ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]AlertRule, 0, 16)
+	for rows.Next() {
+		r, err := scanAlertRule(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, r)
+	}
+	return result, rows.Err()
+}
+
+// GetAlertRule правило по id
+func (db *DB) GetAlertRule(id int64) (*AlertRule, error) {
+	r, err := scanAlertRule(db.conn.QueryRow("SELECT "+alertRuleColumns+" FROM alert_rules WHERE id = ?", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrAlertRuleNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// CreateAlertRule сохраняет новое правило
+func (db *DB) CreateAlertRule(r *AlertRule) error {
+	if err := r.Validate(); err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	res, err := db.conn.Exec(`
+		INSERT INTO alert_rules (name, type, common_name, instance, threshold, webhook_url, cooldown_seconds, enabled, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		r.Name, r.Type, r.CommonName, r.Instance, r.Threshold, r.WebhookURL, r.CooldownSeconds, r.Enabled, now, now)
+	if err != nil {
+		return err
+	}
+	r.ID, _ = res.LastInsertId()
+	r.CreatedAt, r.UpdatedAt = now, now
+	return nil
+}
+
+// UpdateAlertRule заменяет правило r.ID; состояние срабатываний сбрасывается
+func (db *DB) UpdateAlertRule(r *AlertRule) error {
+	if err := r.Validate(); err != nil {
+		return err
+	}
+	old, err := db.GetAlertRule(r.ID)
+	if err != nil {
+		return err
+	}
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	r.CreatedAt, r.UpdatedAt = old.CreatedAt, time.Now().UTC()
+	if _, err := tx.Exec(`
+		UPDATE alert_rules SET name=?, type=?, common_name=?, instance=?, threshold=?, webhook_url=?, cooldown_seconds=?, enabled=?, updated_at=?
+		WHERE id = ?`,
+		r.Name, r.Type, r.CommonName, r.Instance, r.Threshold, r.WebhookURL, r.CooldownSeconds, r.Enabled, r.UpdatedAt, r.ID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM alert_state WHERE rule_id = ?", r.ID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeleteAlertRule удаляет правило и его состояние; история остаётся
+func (db *DB) DeleteAlertRule(id int64) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM alert_state WHERE rule_id = ?", id); err != nil {
+		return err
+	}
+	res, err := tx.Exec("DELETE FROM alert_rules WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrAlertRuleNotFound
+	}
+	return tx.Commit()
+}
+
+// AlertState состояние срабатывания правила по ключу (пользователь, инстанс, ...)
+type AlertState struct {
+	Key         string
+	Active      bool
+	LastFiredAt time.Time
+}
+
+// GetAlertStates состояние правила по ключам
+func (db *DB) GetAlertStates(ruleID int64) (map[string]AlertState, error) {
+	rows, err := db.conn.Query("SELECT key, active, last_fired_at FROM alert_state WHERE rule_id = ?", ruleID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	states := make(map[string]AlertState)
+	for rows.Next() {
+		var s AlertState
+		var last sql.NullTime
+		if err := rows.Scan(&s.Key, &s.Active, &last); err != nil {
+			return nil, err
+		}
+		s.LastFiredAt = last.Time
+		states[s.Key] = s
+	}
+	return states, rows.Err()
+}
+
+// SetAlertState сохраняет состояние ключа; нулевой LastFiredAt не меняет время последнего уведомления
+func (db *DB) SetAlertState(ruleID int64, s AlertState) error {
+	var last interface{}
+	if !s.LastFiredAt.IsZero() {
+		last = s.LastFiredAt.UTC()
+	}
+	_, err := db.conn.Exec(`
+		INSERT INTO alert_state (rule_id, key, active, last_fired_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT(rule_id, key) DO UPDATE SET active=excluded.active, last_fired_at=COALESCE(excluded.last_fired_at, last_fired_at)`,
+		ruleID, s.Key, s.Active, last)
+	return err
+}
+
+// AlertEvent сработавший алерт (история и тело webhook-а)
+type AlertEvent struct {
+	ID         int64     `json:"id"`
+	RuleID     int64     `json:"rule_id"`
+	RuleName   string    `json:"rule"`
+	Type       string    `json:"type"`
+	Key        string    `json:"key"`
+	Instance   string    `json:"instance,omitempty"`
+	CommonName string    `json:"common_name,omitempty"`
+	Message    string    `json:"message"`
+	Value      int64     `json:"value"`
+	Threshold  int64     `json:"threshold"`
+	FiredAt    time.Time `json:"fired_at"`
+	Delivery   string    `json:"delivery"` // pending, ok, failed
+	Error      string    `json:"error,omitempty"`
+}
+
+// AddAlertEvent записывает алерт в историю со статусом доставки pending
+func (db *DB) AddAlertEvent(e *AlertEvent) error {
+	e.Delivery = "pending"
+	res, err := db.conn.Exec(`
+		INSERT INTO alert_history (rule_id, rule_name, type, key, instance, common_name, message, value, threshold, fired_at, delivery)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		e.RuleID, e.RuleName, e.Type, e.Key, e.Instance, e.CommonName, e.Message, e.Value, e.Threshold, e.FiredAt.UTC(), e.Delivery)
+	if err != nil {
+		return err
+	}
+	e.ID, _ = res.LastInsertId()
+	return nil
+}
+
+// SetAlertDelivery итог отправки webhook-а
+func (db *DB) SetAlertDelivery(id int64, deliveryErr error) error {
+	delivery, msg := "ok", ""
+	if deliveryErr != nil {
+		delivery, msg = "failed", deliveryErr.Error()
+	}
+	_, err := db.conn.Exec("UPDATE alert_history SET delivery = ?, error = ? WHERE id = ?", delivery, msg, id)
+	return err
+}
+
+// GetAlertHistory история алертов, новые первыми. ruleID=0 — по всем правилам
+func (db *DB) GetAlertHistory(ruleID int64, f Filter, limit int) ([]AlertEvent, error) {
+	where := []string{"1=1"}
+	var args []interface{}
+	if ruleID > 0 {
+		where = append(where, "rule_id = ?")
+		args = append(args, ruleID)
+	}
+	if f.Instance != "" {
+		where = append(where, "instance = ?")
+		args = append(args, f.Instance)
+	}
+	if !f.From.IsZero() {
+		where = append(where, "fired_at >= ?")
+		args = append(args, f.From.UTC())
+	}
+	if !f.To.IsZero() {
+		where = append(where, "fired_at < ?")
+		args = append(args, f.To.UTC())
+	}
+	if limit <= 0 {
+		limit = 100
+	}
+	args = append(args, limit)
+	rows, err := db.conn.Query(`
+		SELECT id, rule_id, rule_name, type, key, instance, common_name, message, value, threshold, fired_at, delivery, error
+		FROM alert_history
+		WHERE `+strings.Join(where, " AND ")+`
+		ORDER BY fired_at DESC, id DESC LIMIT ?`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]AlertEvent, 0, 16)
+	for rows.Next() {
+		var e AlertEvent
+		if err := rows.Scan(&e.ID, &e.RuleID, &e.RuleName, &e.Type, &e.Key, &e.Instance, &e.CommonName, &e.Message,
+			&e.Value, &e.Threshold, &e.FiredAt, &e.Delivery, &e.Error); err != nil {
+			return nil, err
+		}
+		result = append(result, e)
+	}
+	return result, rows.Err()
+}
+
+// UsersOverDailyBytes пользователи, у которых трафик за день day (UTC) не меньше threshold
+func (db *DB) UsersOverDailyBytes(day time.Time, threshold int64, commonName string, f Filter) ([]UserTraffic, error) {
+	inst, args := f.instanceWhere("d.instance")
+	args = append([]interface{}{day.UTC().Format("2006-01-02")}, args...)
+	query := `
+		SELECT u.common_name, SUM(d.bytes_received), SUM(d.bytes_sent)
+		FROM user_daily_traffic d
+		JOIN users u ON u.id = d.user_id
+		WHERE d.day = ? AND ` + inst
+	if commonName != "" {
+		query += " AND u.common_name = ?"
+		args = append(args, commonName)
+	}
+	query += " GROUP BY u.id HAVING SUM(d.bytes_received + d.bytes_sent) >= ?"
+	rows, err := db.conn.Query(query, append(args, threshold)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]UserTraffic, 0, 8)
+	for rows.Next() {
+		var ut UserTraffic
+		if err := rows.Scan(&ut.CommonName, &ut.BytesReceived, &ut.BytesSent); err != nil {
+			return nil, err
+		}
+		ut.TotalBytes = ut.BytesReceived + ut.BytesSent
+		result = append(result, ut)
+	}
+	return result, rows.Err()
+}
+
+// RecordUserCountry запоминает страну подключения пользователя. isNew — страны раньше не было,
+// known — у пользователя уже были другие страны (первая страна не считается новой)
+func (db *DB) RecordUserCountry(commonName, country string, at time.Time) (isNew, known bool, err error) {
+	var n int
+	if err := db.conn.QueryRow("SELECT COUNT(*) FROM user_countries WHERE common_name = ?", commonName).Scan(&n); err != nil {
+		return false, false, err
+	}
+	res, err := db.conn.Exec("INSERT OR IGNORE INTO user_countries (common_name, country, first_seen_at) VALUES (?, ?, ?)",
+		commonName, country, at.UTC())
+	if err != nil {
+		return false, false, err
+	}
+	added, err := res.RowsAffected()
+	return added > 0, n > 0, err
+}
@@ -0,0 +1,84 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+)
+
+// UserDailyTraffic трафик одного пользователя за один день
+type UserDailyTraffic struct {
+	CommonName string `json:"common_name"`
+	DailyTraffic
+}
+
+// GetUserDailyTraffic возвращает трафик пользователя по дням в интервале фильтра. Без интервала — последние 30 дней
+func (db *DB) GetUserDailyTraffic(commonName string, f Filter) ([]DailyTraffic, error) {
+	where, args := f.dayWhere("d.day")
+	inst, instArgs := f.instanceWhere("d.instance")
+	args = append(args, instArgs...)
+	query := `
+		SELECT d.day, SUM(d.bytes_received), SUM(d.bytes_sent)
+		FROM user_daily_traffic d
+		JOIN users u ON u.id = d.user_id
+		WHERE u.common_name = ? AND ` + where + ` AND ` + inst + `
+		GROUP BY d.day
+		ORDER BY d.day DESC`
+	if !f.HasRange() {
+		query += ` LIMIT 30`
+	}
+	rows, err := db.conn.Query(query, append([]interface{}{commonName}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanDailyTraffic(rows)
+}
+
+// GetDailyTrafficByUser возвращает трафик по пользователям и дням (для матрицы пользователь × день).
+// Без интервала — последние 30 дней
+func (db *DB) GetDailyTrafficByUser(f Filter) ([]UserDailyTraffic, error) {
+	if !f.HasRange() {
+		f.From = time.Now().UTC().AddDate(0, 0, -29).Truncate(24 * time.Hour)
+	}
+	where, args := f.dayWhere("d.day")
+	inst, instArgs := f.instanceWhere("d.instance")
+	rows, err := db.conn.Query(`
+		SELECT u.common_name, d.day, SUM(d.bytes_received), SUM(d.bytes_sent)
+		FROM user_daily_traffic d
+		JOIN users u ON u.id = d.user_id
+		WHERE `+where+` AND `+inst+`
+		GROUP BY u.id, d.day
+		ORDER BY u.common_name, d.day`, append(args, instArgs...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]UserDailyTraffic, 0, 64)
+	for rows.Next() {
+		var d UserDailyTraffic
+		var day time.Time
+		if err := rows.Scan(&d.CommonName, &day, &d.BytesReceived, &d.BytesSent); err != nil {
+			return nil, err
+		}
+		d.Day = day.Format("2006-01-02")
+		d.TotalBytes = d.BytesReceived + d.BytesSent
+		result = append(result, d)
+	}
+	return result, rows.Err()
+}
+
+func scanDailyTraffic(rows *sql.Rows) ([]DailyTraffic, error) {
+	result := make([]DailyTraffic, 0, 32)
+	for rows.Next() {
+		var d DailyTraffic
+		var day time.Time
+		if err := rows.Scan(&day, &d.BytesReceived, &d.BytesSent); err != nil {
+			return nil, err
+		}
+		d.Day = day.Format("2006-01-02")
+		d.TotalBytes = d.BytesReceived + d.BytesSent
+		result = append(result, d)
+	}
+	return result, rows.Err()
+}
@@ -0,0 +1,83 @@
+package database
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// dailyHistory два дня, два инстанса: alice 100/10 и 350/35, bob 20/2 только в первый день
+func dailyHistory(t *testing.T, db *DB) {
+	t.Helper()
+	since := base.Add(-time.Hour)
+	day2 := base.Add(15 * time.Hour) // 2024-02-24 01:00
+	mustSave(t, db, "vpn1", status(base, client("alice", "1.1.1.1:1000", since, 100, 10), client("bob", "2.2.2.2:2000", since, 20, 2)))
+	mustSave(t, db, "vpn1", status(day2, client("alice", "1.1.1.1:1000", since, 400, 40), client("bob", "2.2.2.2:2000", since, 20, 2)))
+	mustSave(t, db, "vpn2", status(day2.Add(time.Hour), client("alice", "3.3.3.3:3000", day2, 50, 5)))
+}
+
+func TestGetUserDailyTraffic(t *testing.T) {
+	db := newTestDB(t)
+	dailyHistory(t, db)
+	all := Filter{From: base.Truncate(24 * time.Hour), To: base.AddDate(0, 0, 2)}
+	tests := []struct {
+		name string
+		cn   string
+		f    Filter
+		want []string // день:получено/отправлено, новые сверху
+	}{
+		{"все инстансы", "alice", all, []string{"2024-02-24:350/35", "2024-02-23:100/10"}},
+		{"один инстанс", "alice", Filter{From: all.From, To: all.To, Instance: "vpn2"}, []string{"2024-02-24:50/5"}},
+		{"один день", "alice", Filter{From: base.Truncate(24 * time.Hour), To: base.Add(time.Hour)}, []string{"2024-02-23:100/10"}},
+		{"без трафика во второй день", "bob", all, []string{"2024-02-23:20/2"}},
+		{"нет пользователя", "carol", all, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			days, err := db.GetUserDailyTraffic(tt.cn, tt.f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, d := range days {
+				if d.TotalBytes != d.BytesReceived+d.BytesSent {
+					t.Errorf("%s: total_bytes %d", d.Day, d.TotalBytes)
+				}
+				got = append(got, fmt.Sprintf("%s:%d/%d", d.Day, d.BytesReceived, d.BytesSent))
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("%v, ожидалось %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetDailyTrafficByUser(t *testing.T) {
+	db := newTestDB(t)
+	dailyHistory(t, db)
+	tests := []struct {
+		name string
+		f    Filter
+		want []string // пользователь/день:всего
+	}{
+		{"все", Filter{From: base.Truncate(24 * time.Hour), To: base.AddDate(0, 0, 2)},
+			[]string{"alice/2024-02-23:110", "alice/2024-02-24:385", "bob/2024-02-23:22"}},
+		{"второй день на vpn1", Filter{From: base.AddDate(0, 0, 1).Truncate(24 * time.Hour), To: base.AddDate(0, 0, 2), Instance: "vpn1"},
+			[]string{"alice/2024-02-24:330"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows, err := db.GetDailyTrafficByUser(tt.f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, r := range rows {
+				got = append(got, fmt.Sprintf("%s/%s:%d", r.CommonName, r.Day, r.TotalBytes))
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("%v, ожидалось %v", got, tt.want)
+			}
+		})
+	}
+}
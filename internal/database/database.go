@@ -15,11 +15,14 @@ type DB struct {
 	conn        *sql.DB
 	userCache   map[string]int64
 	userCacheMu sync.RWMutex
+	retention   RetentionPolicy
+	retentionMu sync.RWMutex
+	rebuildMu   sync.Mutex // один пересчёт за раз (Rebuild)
 }
 
 // New создаёт подключение к SQLite
 func New(path string) (*DB, error) {
-	conn, err := sql.Open("sqlite3", path+"?_journal_mode=WAL&_busy_timeout=5000&_synchronous=NORMAL&_cache_size=-64000&_temp_store=MEMORY")
+	conn, err := sql.Open(driverName, path+"?_journal_mode=WAL&_busy_timeout=5000&_synchronous=NORMAL&_cache_size=-64000&_temp_store=MEMORY")
 	if err != nil {
 		return nil, fmt.Errorf("открытие БД: %w", err)
 	}
@@ -44,6 +47,7 @@ func (db *DB) migrate() error {
 	CREATE TABLE IF NOT EXISTS traffic_snapshots (
 		id INTEGER PRIMARY KEY AUTOINCREMENT,
 		user_id INTEGER NOT NULL,
+		instance TEXT NOT NULL DEFAULT 'default',
 		real_address TEXT,
 		virtual_address TEXT,
 		bytes_received BIGINT NOT NULL DEFAULT 0,
@@ -53,25 +57,134 @@ func (db *DB) migrate() error {
 		FOREIGN KEY (user_id) REFERENCES users(id)
 	);
 	CREATE TABLE IF NOT EXISTS user_traffic_totals (
-		user_id INTEGER PRIMARY KEY,
+		user_id INTEGER NOT NULL,
+		instance TEXT NOT NULL DEFAULT 'default',
 		bytes_received BIGINT NOT NULL DEFAULT 0,
 		bytes_sent BIGINT NOT NULL DEFAULT 0,
+		PRIMARY KEY (user_id, instance),
 		FOREIGN KEY (user_id) REFERENCES users(id)
 	);
 	CREATE TABLE IF NOT EXISTS daily_traffic_totals (
-		day DATE PRIMARY KEY,
+		instance TEXT NOT NULL DEFAULT 'default',
+		day DATE NOT NULL,
+		bytes_received BIGINT NOT NULL DEFAULT 0,
+		bytes_sent BIGINT NOT NULL DEFAULT 0,
+		PRIMARY KEY (instance, day)
+	);
+	CREATE INDEX IF NOT EXISTS idx_daily_day ON daily_traffic_totals(day);
+	CREATE TABLE IF NOT EXISTS user_daily_traffic (
+		user_id INTEGER NOT NULL,
+		instance TEXT NOT NULL DEFAULT 'default',
+		day DATE NOT NULL,
+		bytes_received BIGINT NOT NULL DEFAULT 0,
+		bytes_sent BIGINT NOT NULL DEFAULT 0,
+		PRIMARY KEY (user_id, instance, day),
+		FOREIGN KEY (user_id) REFERENCES users(id)
+	);
+	CREATE INDEX IF NOT EXISTS idx_user_daily_day ON user_daily_traffic(day);
+	CREATE TABLE IF NOT EXISTS hourly_traffic_totals (
+		instance TEXT NOT NULL DEFAULT 'default',
+		hour DATETIME NOT NULL,
+		bytes_received BIGINT NOT NULL DEFAULT 0,
+		bytes_sent BIGINT NOT NULL DEFAULT 0,
+		PRIMARY KEY (instance, hour)
+	);
+	CREATE INDEX IF NOT EXISTS idx_hourly_hour ON hourly_traffic_totals(hour);
+	CREATE TABLE IF NOT EXISTS user_hourly_traffic (
+		user_id INTEGER NOT NULL,
+		instance TEXT NOT NULL DEFAULT 'default',
+		hour DATETIME NOT NULL,
+		bytes_received BIGINT NOT NULL DEFAULT 0,
+		bytes_sent BIGINT NOT NULL DEFAULT 0,
+		PRIMARY KEY (user_id, instance, hour),
+		FOREIGN KEY (user_id) REFERENCES users(id)
+	);
+	CREATE INDEX IF NOT EXISTS idx_user_hourly_hour ON user_hourly_traffic(hour);
+	CREATE TABLE IF NOT EXISTS traffic_deltas (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		instance TEXT NOT NULL DEFAULT 'default',
+		delta_at DATETIME NOT NULL,
 		bytes_received BIGINT NOT NULL DEFAULT 0,
-		bytes_sent BIGINT NOT NULL DEFAULT 0
+		bytes_sent BIGINT NOT NULL DEFAULT 0,
+		FOREIGN KEY (user_id) REFERENCES users(id)
 	);
+	CREATE INDEX IF NOT EXISTS idx_traffic_deltas_at ON traffic_deltas(delta_at);
+	CREATE INDEX IF NOT EXISTS idx_traffic_deltas_user_at ON traffic_deltas(user_id, delta_at);
 	CREATE TABLE IF NOT EXISTS session_last_bytes (
+		instance TEXT NOT NULL DEFAULT 'default',
+		user_id INTEGER NOT NULL,
+		real_address TEXT NOT NULL,
+		bytes_received BIGINT NOT NULL,
+		bytes_sent BIGINT NOT NULL,
+		connected_since DATETIME,
+		PRIMARY KEY (instance, user_id, real_address),
+		FOREIGN KEY (user_id) REFERENCES users(id)
+	);
+	CREATE TABLE IF NOT EXISTS import_last_bytes (
+		instance TEXT NOT NULL DEFAULT 'default',
+		user_id INTEGER NOT NULL,
+		real_address TEXT NOT NULL,
+		bytes_received BIGINT NOT NULL,
+		bytes_sent BIGINT NOT NULL,
+		connected_since DATETIME,
+		PRIMARY KEY (instance, user_id, real_address),
+		FOREIGN KEY (user_id) REFERENCES users(id)
+	);
+	CREATE TABLE IF NOT EXISTS import_state (
+		instance TEXT PRIMARY KEY,
+		live_from DATETIME,
+		last_at DATETIME
+	);
+	CREATE TABLE IF NOT EXISTS import_log (
+		instance TEXT NOT NULL,
+		snapshot_at DATETIME NOT NULL,
+		hash TEXT NOT NULL,
+		source TEXT NOT NULL,
+		clients INTEGER NOT NULL,
+		imported_at DATETIME NOT NULL,
+		PRIMARY KEY (instance, snapshot_at)
+	);
+	CREATE TABLE IF NOT EXISTS import_adjustments (
+		instance TEXT NOT NULL,
 		user_id INTEGER NOT NULL,
 		real_address TEXT NOT NULL,
+		connected_since DATETIME,
 		bytes_received BIGINT NOT NULL,
 		bytes_sent BIGINT NOT NULL,
-		PRIMARY KEY (user_id, real_address),
+		PRIMARY KEY (instance, user_id, real_address, connected_since)
+	);
+	CREATE TABLE IF NOT EXISTS route_snapshots (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		instance TEXT NOT NULL DEFAULT 'default',
+		virtual_address TEXT NOT NULL,
+		real_address TEXT,
+		last_ref DATETIME,
+		snapshot_at DATETIME NOT NULL,
+		FOREIGN KEY (user_id) REFERENCES users(id)
+	);
+	CREATE TABLE IF NOT EXISTS sessions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		instance TEXT NOT NULL DEFAULT 'default',
+		real_address TEXT NOT NULL,
+		connected_since DATETIME NOT NULL,
+		virtual_address TEXT,
+		username TEXT,
+		cipher TEXT,
+		first_seen_at DATETIME NOT NULL,
+		last_seen_at DATETIME NOT NULL,
+		ended_at DATETIME,
+		bytes_received BIGINT NOT NULL DEFAULT 0,
+		bytes_sent BIGINT NOT NULL DEFAULT 0,
+		UNIQUE (instance, user_id, real_address, connected_since),
 		FOREIGN KEY (user_id) REFERENCES users(id)
 	);
+	CREATE INDEX IF NOT EXISTS idx_sessions_user ON sessions(user_id);
+	CREATE INDEX IF NOT EXISTS idx_sessions_ended_at ON sessions(ended_at);
 	CREATE INDEX IF NOT EXISTS idx_traffic_snapshot_at ON traffic_snapshots(snapshot_at);
+	CREATE INDEX IF NOT EXISTS idx_route_snapshot_at ON route_snapshots(snapshot_at);
 	CREATE INDEX IF NOT EXISTS idx_traffic_user ON traffic_snapshots(user_id);
 	CREATE UNIQUE INDEX IF NOT EXISTS idx_users_common_name ON users(common_name);
 	CREATE TABLE IF NOT EXISTS user_aliases (
@@ -80,20 +193,160 @@ func (db *DB) migrate() error {
 		alias TEXT NOT NULL,
 		PRIMARY KEY (common_name, real_address)
 	);
+	CREATE TABLE IF NOT EXISTS health_probe (
+		id INTEGER PRIMARY KEY CHECK (id = 1),
+		checked_at DATETIME NOT NULL
+	);
+	CREATE TABLE IF NOT EXISTS kill_log (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		at DATETIME NOT NULL,
+		instance TEXT NOT NULL,
+		common_name TEXT NOT NULL,
+		real_address TEXT NOT NULL DEFAULT '',
+		reason TEXT NOT NULL,
+		requested_by TEXT NOT NULL DEFAULT '',
+		note TEXT NOT NULL DEFAULT '',
+		remote_addr TEXT NOT NULL DEFAULT '',
+		result TEXT NOT NULL DEFAULT '',
+		error TEXT NOT NULL DEFAULT ''
+	);
+	CREATE INDEX IF NOT EXISTS idx_kill_log_at ON kill_log(at);
+	CREATE TABLE IF NOT EXISTS quotas (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		common_name TEXT,
+		group_name TEXT,
+		period TEXT NOT NULL,
+		limit_bytes BIGINT NOT NULL,
+		action TEXT NOT NULL,
+		webhook_url TEXT NOT NULL DEFAULT '',
+		created_at DATETIME NOT NULL,
+		updated_at DATETIME NOT NULL,
+		UNIQUE (common_name, period),
+		UNIQUE (group_name, period)
+	);
+	CREATE TABLE IF NOT EXISTS quota_group_members (
+		group_name TEXT NOT NULL,
+		common_name TEXT NOT NULL,
+		PRIMARY KEY (group_name, common_name)
+	);
+	CREATE INDEX IF NOT EXISTS idx_quota_group_members_cn ON quota_group_members(common_name);
+	CREATE TABLE IF NOT EXISTS alert_rules (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		type TEXT NOT NULL,
+		common_name TEXT NOT NULL DEFAULT '',
+		instance TEXT NOT NULL DEFAULT '',
+		threshold BIGINT NOT NULL DEFAULT 0,
+		webhook_url TEXT NOT NULL,
+		cooldown_seconds BIGINT NOT NULL DEFAULT 3600,
+		enabled BOOLEAN NOT NULL DEFAULT 1,
+		created_at DATETIME NOT NULL,
+		updated_at DATETIME NOT NULL
+	);
+	CREATE TABLE IF NOT EXISTS alert_state (
+		rule_id INTEGER NOT NULL,
+		key TEXT NOT NULL,
+		active BOOLEAN NOT NULL,
+		last_fired_at DATETIME,
+		PRIMARY KEY (rule_id, key)
+	);
+	CREATE TABLE IF NOT EXISTS alert_history (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		rule_id INTEGER NOT NULL,
+		rule_name TEXT NOT NULL,
+		type TEXT NOT NULL,
+		key TEXT NOT NULL,
+		instance TEXT NOT NULL DEFAULT '',
+		common_name TEXT NOT NULL DEFAULT '',
+		message TEXT NOT NULL,
+		value BIGINT NOT NULL DEFAULT 0,
+		threshold BIGINT NOT NULL DEFAULT 0,
+		fired_at DATETIME NOT NULL,
+		delivery TEXT NOT NULL,
+		error TEXT NOT NULL DEFAULT ''
+	);
+	CREATE INDEX IF NOT EXISTS idx_alert_history_fired_at ON alert_history(fired_at);
+	CREATE TABLE IF NOT EXISTS user_countries (
+		common_name TEXT NOT NULL,
+		country TEXT NOT NULL,
+		first_seen_at DATETIME NOT NULL,
+		PRIMARY KEY (common_name, country)
+	);
+	CREATE TABLE IF NOT EXISTS quota_breaches (
+		quota_id INTEGER NOT NULL,
+		common_name TEXT NOT NULL,
+		period_start DATETIME NOT NULL,
+		exceeded_at DATETIME NOT NULL,
+		used_bytes BIGINT NOT NULL,
+		PRIMARY KEY (quota_id, common_name, period_start)
+	);
 	`
+	// Таблицы, чей ключ теперь включает инстанс, пересоздаются по схеме ниже
+	if err := db.renameLegacyTables(); err != nil {
+		return err
+	}
 	if _, err := db.conn.Exec(schema); err != nil {
 		return err
 	}
+	// Колонки, добавленные после первой версии схемы
+	for _, col := range []struct{ table, name, decl string }{
+		{"traffic_snapshots", "virtual_ipv6", "TEXT"},
+		{"traffic_snapshots", "username", "TEXT"},
+		{"traffic_snapshots", "client_id", "INTEGER"},
+		{"traffic_snapshots", "peer_id", "INTEGER"},
+		{"traffic_snapshots", "cipher", "TEXT"},
+		{"traffic_snapshots", "instance", "TEXT NOT NULL DEFAULT 'default'"},
+		{"route_snapshots", "instance", "TEXT NOT NULL DEFAULT 'default'"},
+		{"traffic_deltas", "instance", "TEXT NOT NULL DEFAULT 'default'"},
+		{"traffic_snapshots", "rate_received", "REAL"},
+		{"traffic_snapshots", "rate_sent", "REAL"},
+		{"sessions", "peak_rate_received", "REAL NOT NULL DEFAULT 0"},
+		{"sessions", "peak_rate_sent", "REAL NOT NULL DEFAULT 0"},
+		{"session_last_bytes", "seen_at", "DATETIME"},
+		{"import_last_bytes", "seen_at", "DATETIME"},
+	} {
+		if err := db.ensureColumn(col.table, col.name, col.decl); err != nil {
+			return err
+		}
+	}
+	if err := db.copyLegacyTables(); err != nil {
+		return err
+	}
+	if _, err := db.conn.Exec(`
+		CREATE INDEX IF NOT EXISTS idx_traffic_instance_at ON traffic_snapshots(instance, snapshot_at);
+		CREATE INDEX IF NOT EXISTS idx_route_instance_at ON route_snapshots(instance, snapshot_at);
+	`); err != nil {
+		return err
+	}
 	// Удаляем некорректные пользователи (undefined, null, пустые), если есть
 	db.conn.Exec(`DELETE FROM session_last_bytes WHERE user_id IN (SELECT id FROM users WHERE TRIM(common_name) = '' OR LOWER(TRIM(common_name)) IN ('undefined','null'))`)
+	db.conn.Exec(`DELETE FROM user_daily_traffic WHERE user_id IN (SELECT id FROM users WHERE TRIM(common_name) = '' OR LOWER(TRIM(common_name)) IN ('undefined','null'))`)
+	db.conn.Exec(`DELETE FROM user_hourly_traffic WHERE user_id IN (SELECT id FROM users WHERE TRIM(common_name) = '' OR LOWER(TRIM(common_name)) IN ('undefined','null'))`)
+	db.conn.Exec(`DELETE FROM traffic_deltas WHERE user_id IN (SELECT id FROM users WHERE TRIM(common_name) = '' OR LOWER(TRIM(common_name)) IN ('undefined','null'))`)
 	db.conn.Exec(`DELETE FROM user_traffic_totals WHERE user_id IN (SELECT id FROM users WHERE TRIM(common_name) = '' OR LOWER(TRIM(common_name)) IN ('undefined','null'))`)
+	db.conn.Exec(`DELETE FROM sessions WHERE user_id IN (SELECT id FROM users WHERE TRIM(common_name) = '' OR LOWER(TRIM(common_name)) IN ('undefined','null'))`)
+	db.conn.Exec(`DELETE FROM route_snapshots WHERE user_id IN (SELECT id FROM users WHERE TRIM(common_name) = '' OR LOWER(TRIM(common_name)) IN ('undefined','null'))`)
 	db.conn.Exec(`DELETE FROM traffic_snapshots WHERE user_id IN (SELECT id FROM users WHERE TRIM(common_name) = '' OR LOWER(TRIM(common_name)) IN ('undefined','null'))`)
 	db.conn.Exec(`DELETE FROM users WHERE TRIM(common_name) = '' OR LOWER(TRIM(common_name)) IN ('undefined','null')`)
 	return nil
 }
 
-// SaveSnapshot сохраняет снимок и обновляет накопленный трафик
-func (db *DB) SaveSnapshot(status *parser.Status) error {
+// ensureColumn добавляет колонку в существующую таблицу, если её ещё нет
+func (db *DB) ensureColumn(table, column, decl string) error {
+	cols, err := db.tableColumns(table)
+	if err != nil || hasColumn(cols, column) {
+		return err
+	}
+	_, err = db.conn.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, decl))
+	return err
+}
+
+// SaveSnapshot сохраняет снимок инстанса и обновляет его накопленный трафик.
+// Сессии и счётчики разных инстансов не пересекаются: один CN может быть подключён к нескольким серверам
+func (db *DB) SaveSnapshot(instance string, status *parser.Status) error {
+	if instance == "" {
+		instance = DefaultInstance
+	}
 	tx, err := db.conn.Begin()
 	if err != nil {
 		return err
@@ -104,33 +357,62 @@ func (db *DB) SaveSnapshot(status *parser.Status) error {
 	if snapshotAt.IsZero() {
 		snapshotAt = time.Now().UTC()
 	}
+	if _, err := db.writeSnapshot(tx, instance, status, snapshotAt, "session_last_bytes"); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
 
+// writeSnapshot записывает снимок, сессии и маршруты и добавляет приращения к агрегатам.
+// stateTable — таблица счётчиков прошлого снимка (session_last_bytes или import_last_bytes):
+// у живого сбора и импорта архивов свои цепочки. Возвращает счётчики сессий снимка
+func (db *DB) writeSnapshot(tx *sql.Tx, instance string, status *parser.Status, snapshotAt time.Time, stateTable string) (map[sessionKey]sessionBytes, error) {
 	currentSessions := make(map[sessionKey]sessionBytes)
+	prev, err := loadLastBytes(tx, instance, stateTable)
+	if err != nil {
+		return nil, err
+	}
 
-	insert, err := tx.Prepare(`INSERT INTO traffic_snapshots (user_id, real_address, virtual_address, bytes_received, bytes_sent, connected_since, snapshot_at) VALUES (?, ?, ?, ?, ?, ?, ?)`)
+	insert, err := tx.Prepare(`INSERT INTO traffic_snapshots (user_id, instance, real_address, virtual_address, virtual_ipv6, bytes_received, bytes_sent, connected_since, username, client_id, peer_id, cipher, rate_received, rate_sent, snapshot_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer insert.Close()
 
 	for _, c := range status.Clients {
-		if !isValidUserName(c.CommonName) {
+		if !parser.ValidCommonName(c.CommonName) {
 			continue // пропускаем undefined, null, пустые
 		}
 		userID, err := db.ensureUser(tx, c.CommonName)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		if _, err := insert.Exec(userID, c.RealAddress, c.VirtualAddr, c.BytesReceived, c.BytesSent, c.ConnectedSince, snapshotAt); err != nil {
-			return err
+		k := sessionKey{userID, c.RealAddress}
+		v := sessionBytes{r: c.BytesReceived, s: c.BytesSent, cs: c.ConnectedSince}
+		rr, rs := sessionRate(prev, k, v, snapshotAt)
+		if _, err := insert.Exec(userID, instance, c.RealAddress, c.VirtualAddr, c.VirtualIPv6, c.BytesReceived, c.BytesSent, c.ConnectedSince, c.Username, c.ClientID, c.PeerID, c.Cipher, rr, rs, snapshotAt); err != nil {
+			return nil, err
+		}
+		currentSessions[k] = v
+		if err := db.upsertSession(tx, instance, userID, c, rr, rs, snapshotAt); err != nil {
+			return nil, err
 		}
-		currentSessions[sessionKey{userID, c.RealAddress}] = sessionBytes{r: c.BytesReceived, s: c.BytesSent}
 	}
 
-	// Обновить накопленный трафик (deltas)
-	db.updateTrafficTotals(tx, currentSessions, snapshotAt)
+	if err := db.saveRoutes(tx, instance, status.Routes, snapshotAt); err != nil {
+		return nil, err
+	}
 
-	return tx.Commit()
+	// Сессии, которых нет в этом снимке, считаются завершёнными
+	if err := db.closeMissingSessions(tx, instance, snapshotAt); err != nil {
+		return nil, err
+	}
+
+	// Обновить накопленный трафик (deltas)
+	if err := db.updateTrafficTotals(tx, instance, prev, currentSessions, snapshotAt, stateTable); err != nil {
+		return nil, err
+	}
+	return currentSessions, nil
 }
 
 type sessionKey struct {
@@ -140,49 +422,141 @@ type sessionKey struct {
 
 type sessionBytes struct {
 	r, s int64
+	cs   time.Time // connected_since: отличает переподключение с того же адреса
+	at   time.Time // снимок, в котором сняты счётчики (zero — неизвестно)
 }
 
-func (db *DB) updateTrafficTotals(tx *sql.Tx, cur map[sessionKey]sessionBytes, at time.Time) {
-	day := at.UTC().Format("2006-01-02")
+// loadLastBytes счётчики сессий инстанса в прошлом снимке из stateTable
+func loadLastBytes(tx *sql.Tx, instance, stateTable string) (map[sessionKey]sessionBytes, error) {
+	rows, err := tx.Query("SELECT user_id, real_address, bytes_received, bytes_sent, connected_since, seen_at FROM "+stateTable+" WHERE instance = ?", instance)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	prev := make(map[sessionKey]sessionBytes)
+	for rows.Next() {
+		var k sessionKey
+		var v sessionBytes
+		var cs, at sql.NullTime
+		if err := rows.Scan(&k.uid, &k.addr, &v.r, &v.s, &cs, &at); err != nil {
+			return nil, err
+		}
+		if cs.Valid {
+			v.cs = cs.Time
+		}
+		if at.Valid {
+			v.at = at.Time
+		}
+		prev[k] = v
+	}
+	return prev, rows.Err()
+}
 
-	rows, _ := tx.Query("SELECT user_id, real_address, bytes_received, bytes_sent FROM session_last_bytes")
-	if rows != nil {
-		defer rows.Close()
-		for rows.Next() {
-			var uid int64
-			var addr string
-			var lr, ls int64
-			if rows.Scan(&uid, &addr, &lr, &ls) != nil {
-				continue
-			}
-			k := sessionKey{uid, addr}
-			if cr, ok := cur[k]; ok {
-				dr := cr.r - lr
-				ds := cr.s - ls
-				if dr < 0 {
-					dr = cr.r
-					ds = cr.s
-				}
-				if dr > 0 || ds > 0 {
-					tx.Exec("INSERT INTO user_traffic_totals (user_id, bytes_received, bytes_sent) VALUES (?, ?, ?) ON CONFLICT(user_id) DO UPDATE SET bytes_received=bytes_received+excluded.bytes_received, bytes_sent=bytes_sent+excluded.bytes_sent", uid, dr, ds)
-					tx.Exec("INSERT INTO daily_traffic_totals (day, bytes_received, bytes_sent) VALUES (?, ?, ?) ON CONFLICT(day) DO UPDATE SET bytes_received=bytes_received+excluded.bytes_received, bytes_sent=bytes_sent+excluded.bytes_sent", day, dr, ds)
-				}
-			} else {
-				tx.Exec("INSERT INTO user_traffic_totals (user_id, bytes_received, bytes_sent) VALUES (?, ?, ?) ON CONFLICT(user_id) DO UPDATE SET bytes_received=bytes_received+excluded.bytes_received, bytes_sent=bytes_sent+excluded.bytes_sent", uid, lr, ls)
-				tx.Exec("INSERT INTO daily_traffic_totals (day, bytes_received, bytes_sent) VALUES (?, ?, ?) ON CONFLICT(day) DO UPDATE SET bytes_received=bytes_received+excluded.bytes_received, bytes_sent=bytes_sent+excluded.bytes_sent", day, lr, ls)
-				tx.Exec("DELETE FROM session_last_bytes WHERE user_id=? AND real_address=?", uid, addr)
-			}
+// sessionRate скорость сессии k в снимке at, байт в секунду: приращение с прошлого снимка,
+// делённое на прошедшее время. У новой сессии — средняя с подключения. nil — скорость
+// неизвестна (например, счётчики сняты до того, как стало сохраняться время снимка)
+func sessionRate(prev map[sessionKey]sessionBytes, k sessionKey, c sessionBytes, at time.Time) (*float64, *float64) {
+	rate := func(dr, ds int64, from time.Time) (*float64, *float64) {
+		secs := at.Sub(from).Seconds()
+		if from.IsZero() || secs <= 0 {
+			return nil, nil
+		}
+		rr, rs := float64(dr)/secs, float64(ds)/secs
+		return &rr, &rs
+	}
+	if p, ok := prev[k]; ok && (p.cs.IsZero() || p.cs.Equal(c.cs)) && c.r >= p.r && c.s >= p.s {
+		return rate(c.r-p.r, c.s-p.s, p.at)
+	}
+	return rate(c.r, c.s, c.cs)
+}
+
+// updateTrafficTotals добавляет к накопленному трафику приращения счётчиков с прошлого снимка
+// (prev из stateTable) и сохраняет в stateTable счётчики cur.
+// Новая сессия (нет в session_last_bytes, другой connected_since или счётчики сбросились)
+// учитывается целиком — OpenVPN считает байты с момента подключения.
+// Исчезнувшая сессия уже учтена до последнего снимка, где она была видна.
+func (db *DB) updateTrafficTotals(tx *sql.Tx, instance string, prev, cur map[sessionKey]sessionBytes, at time.Time, stateTable string) error {
+	// Приращения по пользователю за этот снимок (для запросов по интервалам)
+	userDeltas := make(map[int64]sessionBytes)
+	for k, c := range cur {
+		dr, ds := sessionDelta(prev, k, c)
+		if dr == 0 && ds == 0 {
+			continue
+		}
+		ud := userDeltas[k.uid]
+		ud.r += dr
+		ud.s += ds
+		userDeltas[k.uid] = ud
+	}
+	for uid, d := range userDeltas {
+		if err := addTraffic(tx, instance, uid, d.r, d.s, at); err != nil {
+			return err
 		}
 	}
 
-	tx.Exec("DELETE FROM session_last_bytes")
+	if _, err := tx.Exec("DELETE FROM "+stateTable+" WHERE instance = ?", instance); err != nil {
+		return err
+	}
 	for k, v := range cur {
-		tx.Exec("INSERT INTO session_last_bytes (user_id, real_address, bytes_received, bytes_sent) VALUES (?, ?, ?, ?)", k.uid, k.addr, v.r, v.s)
+		if _, err := tx.Exec("INSERT INTO "+stateTable+" (instance, user_id, real_address, bytes_received, bytes_sent, connected_since, seen_at) VALUES (?, ?, ?, ?, ?, ?, ?)", instance, k.uid, k.addr, v.r, v.s, v.cs, at); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sessionDelta приращение счётчиков сессии k относительно прошлого снимка
+func sessionDelta(prev map[sessionKey]sessionBytes, k sessionKey, c sessionBytes) (int64, int64) {
+	if p, ok := prev[k]; ok && (p.cs.IsZero() || p.cs.Equal(c.cs)) && c.r >= p.r && c.s >= p.s {
+		return c.r - p.r, c.s - p.s
 	}
+	return c.r, c.s
+}
+
+// addTraffic добавляет приращение пользователя ко всем агрегатам: накопленному, дневному, почасовому
+// (общим и по пользователю) и к сырым приращениям
+func addTraffic(tx *sql.Tx, instance string, uid, dr, ds int64, at time.Time) error {
+	day := at.UTC().Format("2006-01-02")
+	hour := at.UTC().Truncate(time.Hour)
+	for _, q := range []struct {
+		query string
+		args  []interface{}
+	}{
+		{"INSERT INTO user_traffic_totals (user_id, instance, bytes_received, bytes_sent) VALUES (?, ?, ?, ?) ON CONFLICT(user_id, instance) DO UPDATE SET bytes_received=bytes_received+excluded.bytes_received, bytes_sent=bytes_sent+excluded.bytes_sent", []interface{}{uid, instance, dr, ds}},
+		{"INSERT INTO daily_traffic_totals (instance, day, bytes_received, bytes_sent) VALUES (?, ?, ?, ?) ON CONFLICT(instance, day) DO UPDATE SET bytes_received=bytes_received+excluded.bytes_received, bytes_sent=bytes_sent+excluded.bytes_sent", []interface{}{instance, day, dr, ds}},
+		{"INSERT INTO hourly_traffic_totals (instance, hour, bytes_received, bytes_sent) VALUES (?, ?, ?, ?) ON CONFLICT(instance, hour) DO UPDATE SET bytes_received=bytes_received+excluded.bytes_received, bytes_sent=bytes_sent+excluded.bytes_sent", []interface{}{instance, hour, dr, ds}},
+		{"INSERT INTO traffic_deltas (user_id, instance, delta_at, bytes_received, bytes_sent) VALUES (?, ?, ?, ?, ?)", []interface{}{uid, instance, at, dr, ds}},
+		{"INSERT INTO user_daily_traffic (user_id, instance, day, bytes_received, bytes_sent) VALUES (?, ?, ?, ?, ?) ON CONFLICT(user_id, instance, day) DO UPDATE SET bytes_received=bytes_received+excluded.bytes_received, bytes_sent=bytes_sent+excluded.bytes_sent", []interface{}{uid, instance, day, dr, ds}},
+		{"INSERT INTO user_hourly_traffic (user_id, instance, hour, bytes_received, bytes_sent) VALUES (?, ?, ?, ?, ?) ON CONFLICT(user_id, instance, hour) DO UPDATE SET bytes_received=bytes_received+excluded.bytes_received, bytes_sent=bytes_sent+excluded.bytes_sent", []interface{}{uid, instance, hour, dr, ds}},
+	} {
+		if _, err := tx.Exec(q.query, q.args...); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func isValidUserName(name string) bool {
-	return name != "" && name != "undefined" && name != "null"
+// dbTimeFormats форматы, в которых go-sqlite3 пишет и читает время
+var dbTimeFormats = []string{
+	"2006-01-02 15:04:05.999999999-07:00",
+	"2006-01-02T15:04:05.999999999-07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02T15:04",
+	"2006-01-02",
+}
+
+// parseDBTime разбирает время, пришедшее из SQLite строкой (например, результат MIN/MAX)
+func parseDBTime(s string) (time.Time, bool) {
+	for _, layout := range dbTimeFormats {
+		if t, err := time.ParseInLocation(layout, s, time.UTC); err == nil {
+			return t.UTC(), true
+		}
+	}
+	return time.Time{}, false
 }
 
 func (db *DB) ensureUser(tx *sql.Tx, commonName string) (int64, error) {
@@ -221,42 +595,63 @@ func (db *DB) ensureUser(tx *sql.Tx, commonName string) (int64, error) {
 // UserTraffic статистика пользователя
 type UserTraffic struct {
 	CommonName    string `json:"common_name"`
+	Instance      string `json:"instance,omitempty"` // только для данных одного инстанса
 	BytesReceived int64  `json:"bytes_received"`
 	BytesSent     int64  `json:"bytes_sent"`
 	TotalBytes    int64  `json:"total_bytes"`
 }
 
-var maxSnapshotQuery = `SELECT MAX(snapshot_at) FROM traffic_snapshots`
+// latestSnapshot подзапрос времени последнего снимка того же инстанса, что и строка alias
+func latestSnapshot(alias string) string {
+	return "(SELECT MAX(snapshot_at) FROM traffic_snapshots WHERE instance = " + alias + ".instance)"
+}
 
-// GetUsers возвращает список пользователей (без undefined, null, пустых)
-func (db *DB) GetUsers() ([]string, error) {
-	rows, err := db.conn.Query("SELECT common_name FROM users ORDER BY common_name")
+// GetUsers возвращает список пользователей (без undefined, null, пустых) и курсор следующей страницы
+func (db *DB) GetUsers(f Filter, o ListOptions) ([]string, string, error) {
+	users, args := f.userWhere("u.id")
+	inner := `
+		SELECT u.id AS user_id, u.common_name, ` + aliasExpr("u.common_name") + ` AS alias
+		FROM users u
+		WHERE ` + users + ` AND u.common_name NOT IN ('', 'undefined', 'null')`
+	spec := listSpec{sorts: []string{"common_name"}, defaultSort: "common_name", keys: []string{"common_name"}}
+	query, args, order, err := o.listQuery(spec, inner, args, "l.common_name", f.Instance)
 	if err != nil {
-		return nil, err
+		return nil, "", err
+	}
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, "", err
 	}
 	defer rows.Close()
 
-	users := make([]string, 0, 32)
+	result := make([]string, 0, 32)
+	var n int
+	var last pageKeys
 	for rows.Next() {
 		var name string
-		if err := rows.Scan(&name); err != nil {
-			return nil, err
+		keys := make(pageKeys, len(order))
+		if err := rows.Scan(append([]interface{}{&name}, keys.dest()...)...); err != nil {
+			return nil, "", err
 		}
-		if isValidUserName(name) {
-			users = append(users, name)
+		if n++; !o.keep(n) {
+			continue
 		}
+		last = keys
+		result = append(result, name)
 	}
-	return users, rows.Err()
+	return result, o.nextCursor(n, last), rows.Err()
 }
 
-// GetUserTraffic возвращает трафик пользователя из последнего снимка
-func (db *DB) GetUserTraffic(commonName string) (*UserTraffic, error) {
+// GetUserTraffic возвращает трафик пользователя из последних снимков (сумма по его текущим сессиям)
+func (db *DB) GetUserTraffic(commonName string, f Filter) (*UserTraffic, error) {
+	inst, args := f.instanceWhere("t.instance")
 	var ut UserTraffic
 	err := db.conn.QueryRow(`
-		SELECT u.common_name, COALESCE(t.bytes_received, 0), COALESCE(t.bytes_sent, 0)
+		SELECT u.common_name, COALESCE(SUM(t.bytes_received), 0), COALESCE(SUM(t.bytes_sent), 0)
 		FROM users u
-		LEFT JOIN traffic_snapshots t ON u.id = t.user_id AND t.snapshot_at = (`+maxSnapshotQuery+`)
-		WHERE u.common_name = ?`, commonName).Scan(&ut.CommonName, &ut.BytesReceived, &ut.BytesSent)
+		LEFT JOIN traffic_snapshots t ON u.id = t.user_id AND t.snapshot_at = `+latestSnapshot("t")+` AND `+inst+`
+		WHERE u.common_name = ?
+		GROUP BY u.id`, append(args, commonName)...).Scan(&ut.CommonName, &ut.BytesReceived, &ut.BytesSent)
 	if err != nil {
 		return nil, err
 	}
@@ -264,28 +659,97 @@ func (db *DB) GetUserTraffic(commonName string) (*UserTraffic, error) {
 	return &ut, nil
 }
 
-// GetAllTraffic возвращает трафик всех пользователей из последнего снимка
-func (db *DB) GetAllTraffic() ([]UserTraffic, error) {
+// SessionRates скорость одной текущей сессии пользователя
+type SessionRates struct {
+	Instance       string    `json:"instance"`
+	RealAddress    string    `json:"real_address"`
+	ConnectedSince time.Time `json:"connected_since"`
+	Rates
+}
+
+// GetUserSessionRates возвращает скорость текущих сессий пользователя (из последних снимков)
+func (db *DB) GetUserSessionRates(commonName string, f Filter) ([]SessionRates, error) {
+	inst, args := f.instanceWhere("t.instance")
 	rows, err := db.conn.Query(`
-		SELECT u.common_name, COALESCE(t.bytes_received, 0), COALESCE(t.bytes_sent, 0)
-		FROM users u
-		LEFT JOIN traffic_snapshots t ON u.id = t.user_id AND t.snapshot_at = (` + maxSnapshotQuery + `)
-		ORDER BY (COALESCE(t.bytes_received, 0) + COALESCE(t.bytes_sent, 0)) DESC`)
+		SELECT t.instance, COALESCE(t.real_address, ''), t.connected_since, t.snapshot_at, t.bytes_received, t.bytes_sent,
+			t.rate_received, t.rate_sent, COALESCE(s.peak_rate_received, 0), COALESCE(s.peak_rate_sent, 0)
+		FROM traffic_snapshots t
+		JOIN users u ON u.id = t.user_id
+		`+sessionJoin+`
+		WHERE u.common_name = ? AND t.snapshot_at = `+latestSnapshot("t")+` AND `+inst+`
+		ORDER BY t.instance, t.real_address`, append([]interface{}{commonName}, args...)...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
+	result := make([]SessionRates, 0, 4)
+	for rows.Next() {
+		var r SessionRates
+		var connectedSince sql.NullTime
+		var snapshotAt time.Time
+		var received, sent int64
+		var rateR, rateS sql.NullFloat64
+		if err := rows.Scan(&r.Instance, &r.RealAddress, &connectedSince, &snapshotAt, &received, &sent,
+			&rateR, &rateS, &r.PeakRateReceived, &r.PeakRateSent); err != nil {
+			return nil, err
+		}
+		if connectedSince.Valid {
+			r.ConnectedSince = connectedSince.Time
+		}
+		if rateR.Valid && rateS.Valid {
+			r.RateReceived, r.RateSent = &rateR.Float64, &rateS.Float64
+		}
+		r.setAverage(received, sent, r.ConnectedSince, snapshotAt)
+		result = append(result, r)
+	}
+	return result, rows.Err()
+}
+
+// GetAllTraffic возвращает трафик всех пользователей из последних снимков (строка на сессию) и курсор следующей страницы
+func (db *DB) GetAllTraffic(f Filter, o ListOptions) ([]UserTraffic, string, error) {
+	inst, args := f.instanceWhere("t.instance")
+	users, userArgs := f.userWhere("u.id")
+	inner := `
+		SELECT u.id AS user_id, u.common_name, COALESCE(t.instance, '') AS instance, COALESCE(t.real_address, '') AS real_address,
+			COALESCE(t.bytes_received, 0) AS bytes_received, COALESCE(t.bytes_sent, 0) AS bytes_sent,
+			COALESCE(t.bytes_received, 0) + COALESCE(t.bytes_sent, 0) AS total_bytes, ` + aliasExpr("u.common_name") + ` AS alias
+		FROM users u
+		LEFT JOIN traffic_snapshots t ON u.id = t.user_id AND t.snapshot_at = ` + latestSnapshot("t") + ` AND ` + inst + `
+		WHERE ` + users
+	spec := listSpec{
+		sorts:       []string{"common_name", "instance", "bytes_received", "bytes_sent", "total_bytes"},
+		defaultSort: "-total_bytes",
+		keys:        []string{"common_name", "instance", "real_address"},
+		address:     true,
+		bytes:       true,
+	}
+	query, args, order, err := o.listQuery(spec, inner, append(args, userArgs...), "l.common_name, l.instance, l.bytes_received, l.bytes_sent", f.Instance)
+	if err != nil {
+		return nil, "", err
+	}
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
 
 	result := make([]UserTraffic, 0, 32)
+	var n int
+	var last pageKeys
 	for rows.Next() {
 		var ut UserTraffic
-		if err := rows.Scan(&ut.CommonName, &ut.BytesReceived, &ut.BytesSent); err != nil {
-			return nil, err
+		keys := make(pageKeys, len(order))
+		if err := rows.Scan(append([]interface{}{&ut.CommonName, &ut.Instance, &ut.BytesReceived, &ut.BytesSent}, keys.dest()...)...); err != nil {
+			return nil, "", err
+		}
+		if n++; !o.keep(n) {
+			continue
 		}
+		last = keys
 		ut.TotalBytes = ut.BytesReceived + ut.BytesSent
 		result = append(result, ut)
 	}
-	return result, rows.Err()
+	return result, o.nextCursor(n, last), rows.Err()
 }
 
 // GetAlias возвращает читаемое имя: сначала для (common_name, real_address), иначе для (common_name, "")
@@ -352,70 +816,189 @@ func (db *DB) GetAllAliases() ([]struct{ CommonName, RealAddress, Alias string }
 	return result, rows.Err()
 }
 
-// GetLatestSnapshot возвращает последний снимок (текущие подключения)
-func (db *DB) GetLatestSnapshot() ([]parser.Client, error) {
-	rows, err := db.conn.Query(`
-		SELECT u.common_name, t.real_address, t.virtual_address, t.bytes_received, t.bytes_sent, t.connected_since
+// ConnectedClient клиент из последнего снимка своего инстанса
+type ConnectedClient struct {
+	Instance string `json:"instance"`
+	parser.Client
+	Rates
+}
+
+// Rates скорость сессии, байт в секунду
+type Rates struct {
+	RateReceived     *float64 `json:"rate_received"` // между двумя последними снимками; nil — неизвестна
+	RateSent         *float64 `json:"rate_sent"`
+	PeakRateReceived float64  `json:"peak_rate_received"` // наибольшая за сессию
+	PeakRateSent     float64  `json:"peak_rate_sent"`
+	AvgRateReceived  float64  `json:"avg_rate_received"` // средняя с подключения
+	AvgRateSent      float64  `json:"avg_rate_sent"`
+}
+
+// setAverage средняя скорость сессии: счётчики снимка at, делённые на время с подключения
+func (r *Rates) setAverage(received, sent int64, since, at time.Time) {
+	if secs := at.Sub(since).Seconds(); !since.IsZero() && secs > 0 {
+		r.AvgRateReceived = float64(received) / secs
+		r.AvgRateSent = float64(sent) / secs
+	}
+}
+
+// sessionJoin присоединяет к строке снимка t её сессию s (пиковая скорость)
+const sessionJoin = `LEFT JOIN sessions s ON s.instance = t.instance AND s.user_id = t.user_id AND s.real_address = t.real_address AND s.connected_since = t.connected_since`
+
+// GetLatestSnapshot возвращает текущие подключения: последний снимок каждого инстанса
+func (db *DB) GetLatestSnapshot(f Filter) ([]ConnectedClient, error) {
+	clients, _, err := db.GetConnected(f, ListOptions{})
+	return clients, err
+}
+
+// GetConnected возвращает текущие подключения с фильтрами и сортировкой списка и курсор следующей страницы
+func (db *DB) GetConnected(f Filter, o ListOptions) ([]ConnectedClient, string, error) {
+	inst, args := f.instanceWhere("t.instance")
+	inner := `
+		SELECT t.instance, u.id AS user_id, u.common_name, t.real_address, t.virtual_address, COALESCE(t.virtual_ipv6, '') AS virtual_ipv6,
+			t.bytes_received, t.bytes_sent, t.bytes_received + t.bytes_sent AS total_bytes, t.connected_since,
+			COALESCE(t.username, '') AS username, t.client_id, t.peer_id, COALESCE(t.cipher, '') AS cipher, t.snapshot_at,
+			t.rate_received AS rate_received_value, t.rate_sent AS rate_sent_value,
+			COALESCE(t.rate_received, 0) AS rate_received, COALESCE(t.rate_sent, 0) AS rate_sent,
+			COALESCE(s.peak_rate_received, 0) AS peak_rate_received, COALESCE(s.peak_rate_sent, 0) AS peak_rate_sent,
+			` + aliasAddrExpr("u.common_name", "t.real_address") + ` AS alias
 		FROM traffic_snapshots t
 		JOIN users u ON u.id = t.user_id
-		WHERE t.snapshot_at = (` + maxSnapshotQuery + `)
-		ORDER BY u.common_name`)
+		` + sessionJoin + `
+		WHERE t.snapshot_at = ` + latestSnapshot("t") + ` AND ` + inst
+	spec := listSpec{
+		sorts:       []string{"common_name", "instance", "real_address", "bytes_received", "bytes_sent", "total_bytes", "rate_received", "rate_sent"},
+		defaultSort: "common_name",
+		keys:        []string{"common_name", "instance", "real_address"},
+		address:     true,
+		bytes:       true,
+	}
+	query, args, order, err := o.listQuery(spec, inner, args, `l.instance, l.common_name, l.real_address, l.virtual_address, l.virtual_ipv6,
+		l.bytes_received, l.bytes_sent, l.connected_since, l.username, l.client_id, l.peer_id, l.cipher, l.snapshot_at,
+		l.rate_received_value, l.rate_sent_value, l.peak_rate_received, l.peak_rate_sent`, f.Instance)
 	if err != nil {
-		return nil, err
+		return nil, "", err
+	}
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, "", err
 	}
 	defer rows.Close()
 
-	clients := make([]parser.Client, 0, 32)
+	clients := make([]ConnectedClient, 0, 32)
+	var n int
+	var last pageKeys
 	for rows.Next() {
-		var c parser.Client
+		var c ConnectedClient
 		var connectedSince sql.NullTime
-		if err := rows.Scan(&c.CommonName, &c.RealAddress, &c.VirtualAddr, &c.BytesReceived, &c.BytesSent, &connectedSince); err != nil {
-			return nil, err
+		var snapshotAt time.Time
+		var clientID, peerID sql.NullInt64
+		var rateR, rateS sql.NullFloat64
+		keys := make(pageKeys, len(order))
+		if err := rows.Scan(append([]interface{}{&c.Instance, &c.CommonName, &c.RealAddress, &c.VirtualAddr, &c.VirtualIPv6, &c.BytesReceived, &c.BytesSent, &connectedSince,
+			&c.Username, &clientID, &peerID, &c.Cipher, &snapshotAt, &rateR, &rateS, &c.PeakRateReceived, &c.PeakRateSent}, keys.dest()...)...); err != nil {
+			return nil, "", err
+		}
+		if n++; !o.keep(n) {
+			continue
 		}
+		last = keys
 		if connectedSince.Valid {
 			c.ConnectedSince = connectedSince.Time
 		}
+		if clientID.Valid {
+			c.ClientID = &clientID.Int64
+		}
+		if peerID.Valid {
+			c.PeerID = &peerID.Int64
+		}
+		if rateR.Valid && rateS.Valid {
+			c.RateReceived, c.RateSent = &rateR.Float64, &rateS.Float64
+		}
+		c.setAverage(c.BytesReceived, c.BytesSent, c.ConnectedSince, snapshotAt)
 		clients = append(clients, c)
 	}
-	return clients, rows.Err()
+	return clients, o.nextCursor(n, last), rows.Err()
 }
 
-// GetTotalTraffic возвращает накопленный (всего за всё время) трафик пользователя
-func (db *DB) GetTotalTraffic(commonName string) (*UserTraffic, error) {
-	var ut UserTraffic
-	err := db.conn.QueryRow(`
-		SELECT u.common_name, COALESCE(t.bytes_received, 0), COALESCE(t.bytes_sent, 0)
+// GetTotalTraffic возвращает накопленный трафик пользователя: за всё время или за интервал фильтра
+func (db *DB) GetTotalTraffic(commonName string, f Filter) (*UserTraffic, error) {
+	inst, args := f.instanceWhere("t.instance")
+	query := `
+		SELECT u.common_name, COALESCE(SUM(t.bytes_received), 0), COALESCE(SUM(t.bytes_sent), 0)
 		FROM users u
-		LEFT JOIN user_traffic_totals t ON u.id = t.user_id
-		WHERE u.common_name = ?`, commonName).Scan(&ut.CommonName, &ut.BytesReceived, &ut.BytesSent)
-	if err != nil {
+		LEFT JOIN user_traffic_totals t ON u.id = t.user_id AND ` + inst + `
+		WHERE u.common_name = ?
+		GROUP BY u.id`
+	if f.HasRange() {
+		src, srcArgs := db.rangeSource(f)
+		query = `
+		SELECT u.common_name, COALESCE(SUM(d.bytes_received), 0), COALESCE(SUM(d.bytes_sent), 0)
+		FROM users u
+		LEFT JOIN (` + src + `) d ON u.id = d.user_id
+		WHERE u.common_name = ?
+		GROUP BY u.id`
+		args = srcArgs
+	}
+	args = append(args, commonName)
+
+	var ut UserTraffic
+	if err := db.conn.QueryRow(query, args...).Scan(&ut.CommonName, &ut.BytesReceived, &ut.BytesSent); err != nil {
 		return nil, err
 	}
 	ut.TotalBytes = ut.BytesReceived + ut.BytesSent
 	return &ut, nil
 }
 
-// GetTotalTrafficAll возвращает накопленный трафик всех пользователей
-func (db *DB) GetTotalTrafficAll() ([]UserTraffic, error) {
-	rows, err := db.conn.Query(`
-		SELECT u.common_name, COALESCE(t.bytes_received, 0), COALESCE(t.bytes_sent, 0)
+// GetTotalTrafficAll возвращает накопленный трафик всех пользователей: за всё время или за интервал фильтра.
+// Второе значение — курсор следующей страницы
+func (db *DB) GetTotalTrafficAll(f Filter, o ListOptions) ([]UserTraffic, string, error) {
+	users, userArgs := f.userWhere("u.id")
+	inst, args := f.instanceWhere("t.instance")
+	source := "user_traffic_totals t ON u.id = t.user_id AND " + inst
+	if f.HasRange() {
+		src, srcArgs := db.rangeSource(f)
+		source = "(" + src + ") t ON u.id = t.user_id"
+		args = srcArgs
+	}
+	inner := `
+		SELECT u.id AS user_id, u.common_name, COALESCE(SUM(t.bytes_received), 0) AS bytes_received, COALESCE(SUM(t.bytes_sent), 0) AS bytes_sent,
+			COALESCE(SUM(t.bytes_received), 0) + COALESCE(SUM(t.bytes_sent), 0) AS total_bytes, ` + aliasExpr("u.common_name") + ` AS alias
 		FROM users u
-		LEFT JOIN user_traffic_totals t ON u.id = t.user_id
-		ORDER BY (COALESCE(t.bytes_received, 0) + COALESCE(t.bytes_sent, 0)) DESC`)
+		LEFT JOIN ` + source + `
+		WHERE ` + users + `
+		GROUP BY u.id`
+	spec := listSpec{
+		sorts:       []string{"common_name", "bytes_received", "bytes_sent", "total_bytes"},
+		defaultSort: "-total_bytes",
+		keys:        []string{"common_name"},
+		bytes:       true,
+	}
+	query, args, order, err := o.listQuery(spec, inner, append(args, userArgs...), "l.common_name, l.bytes_received, l.bytes_sent", f.Instance)
 	if err != nil {
-		return nil, err
+		return nil, "", err
+	}
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, "", err
 	}
 	defer rows.Close()
 	result := make([]UserTraffic, 0, 32)
+	var n int
+	var last pageKeys
 	for rows.Next() {
 		var ut UserTraffic
-		if err := rows.Scan(&ut.CommonName, &ut.BytesReceived, &ut.BytesSent); err != nil {
-			return nil, err
+		keys := make(pageKeys, len(order))
+		if err := rows.Scan(append([]interface{}{&ut.CommonName, &ut.BytesReceived, &ut.BytesSent}, keys.dest()...)...); err != nil {
+			return nil, "", err
 		}
+		if n++; !o.keep(n) {
+			continue
+		}
+		last = keys
 		ut.TotalBytes = ut.BytesReceived + ut.BytesSent
 		result = append(result, ut)
 	}
-	return result, rows.Err()
+	return result, o.nextCursor(n, last), rows.Err()
 }
 
 // Stats сводная статистика
@@ -428,26 +1011,34 @@ type Stats struct {
 	TotalBytesS    int64 `json:"total_bytes_sent"`
 }
 
-// GetStats возвращает сводную статистику
-func (db *DB) GetStats() (*Stats, error) {
+// GetStats возвращает сводную статистику. С интервалом в фильтре накопленный трафик считается только за него
+func (db *DB) GetStats(f Filter) (*Stats, error) {
 	var s Stats
-	err := db.conn.QueryRow("SELECT COUNT(*) FROM users").Scan(&s.TotalUsers)
+	users, userArgs := f.userWhere("id")
+	err := db.conn.QueryRow("SELECT COUNT(*) FROM users WHERE "+users, userArgs...).Scan(&s.TotalUsers)
 	if err != nil {
 		return nil, err
 	}
+	inst, instArgs := f.instanceWhere("t.instance")
 	err = db.conn.QueryRow(`SELECT COUNT(*), COALESCE(SUM(bytes_received),0), COALESCE(SUM(bytes_sent),0)
-		FROM traffic_snapshots WHERE snapshot_at = (` + maxSnapshotQuery + `)`).Scan(&s.ConnectedCount, &s.SessionBytesR, &s.SessionBytesS)
+		FROM traffic_snapshots t WHERE t.snapshot_at = `+latestSnapshot("t")+` AND `+inst, instArgs...).Scan(&s.ConnectedCount, &s.SessionBytesR, &s.SessionBytesS)
 	if err != nil {
 		return nil, err
 	}
-	err = db.conn.QueryRow("SELECT COALESCE(SUM(bytes_received),0), COALESCE(SUM(bytes_sent),0) FROM user_traffic_totals").Scan(&s.TotalBytesR, &s.TotalBytesS)
+	inst, instArgs = f.instanceWhere("instance")
+	totalsQuery, totalsArgs := "SELECT COALESCE(SUM(bytes_received),0), COALESCE(SUM(bytes_sent),0) FROM user_traffic_totals WHERE "+inst, instArgs
+	if f.HasRange() {
+		src, srcArgs := db.rangeSource(f)
+		totalsQuery, totalsArgs = "SELECT COALESCE(SUM(bytes_received),0), COALESCE(SUM(bytes_sent),0) FROM ("+src+")", srcArgs
+	}
+	err = db.conn.QueryRow(totalsQuery, totalsArgs...).Scan(&s.TotalBytesR, &s.TotalBytesS)
 	if err != nil {
 		return nil, err
 	}
 	return &s, nil
 }
 
-// DailyTraffic агрегированный трафик по дням (всего по всем пользователям)
+// DailyTraffic агрегированный трафик по дням (всего по всем пользователям и инстансам фильтра)
 type DailyTraffic struct {
 	Day           string `json:"day"`
 	BytesReceived int64  `json:"bytes_received"`
@@ -455,45 +1046,27 @@ type DailyTraffic struct {
 	TotalBytes    int64  `json:"total_bytes"`
 }
 
-// GetDailyTraffic возвращает последние N дней агрегированного трафика
-func (db *DB) GetDailyTraffic(limit int) ([]DailyTraffic, error) {
-	if limit <= 0 {
-		limit = 30
+// GetDailyTraffic возвращает агрегированный трафик по дням в интервале фильтра.
+// Без интервала — последние 30 дней
+func (db *DB) GetDailyTraffic(f Filter) ([]DailyTraffic, error) {
+	where, args := f.dayWhere("day")
+	inst, instArgs := f.instanceWhere("instance")
+	query := `SELECT day, SUM(bytes_received), SUM(bytes_sent) FROM daily_traffic_totals WHERE ` + where + ` AND ` + inst + ` GROUP BY day ORDER BY day DESC`
+	args = append(args, instArgs...)
+	if !f.HasRange() {
+		query += ` LIMIT 30`
 	}
-	rows, err := db.conn.Query(`
-		SELECT day, bytes_received, bytes_sent
-		FROM daily_traffic_totals
-		ORDER BY day DESC
-		LIMIT ?`, limit)
+	rows, err := db.conn.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-
-	var result []DailyTraffic
-	for rows.Next() {
-		var d DailyTraffic
-		if err := rows.Scan(&d.Day, &d.BytesReceived, &d.BytesSent); err != nil {
-			return nil, err
-		}
-		d.TotalBytes = d.BytesReceived + d.BytesSent
-		result = append(result, d)
-	}
-	return result, rows.Err()
+	return scanDailyTraffic(rows)
 }
 
-// CleanupOldSnapshots удаляет старые снимки, оставляя последние n
-func (db *DB) CleanupOldSnapshots(keep int) error {
-	if keep <= 0 {
-		return nil
-	}
-	var cnt int64
-	if err := db.conn.QueryRow("SELECT COUNT(*) FROM traffic_snapshots").Scan(&cnt); err != nil || cnt <= int64(keep) {
-		return err
-	}
-	_, err := db.conn.Exec(`DELETE FROM traffic_snapshots WHERE snapshot_at < (
-		SELECT MIN(s) FROM (SELECT snapshot_at AS s FROM traffic_snapshots ORDER BY snapshot_at DESC LIMIT ?)
-	)`, keep)
+// CheckWritable проверяет, что в БД можно писать (диск не заполнен, файл не только для чтения)
+func (db *DB) CheckWritable() error {
+	_, err := db.conn.Exec("INSERT OR REPLACE INTO health_probe (id, checked_at) VALUES (1, ?)", time.Now().UTC())
 	return err
 }
 
@@ -0,0 +1,124 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"open-statistic/internal/parser"
+)
+
+// base начало тестовых данных, выровненное на час
+var base = time.Date(2024, 2, 23, 10, 0, 0, 0, time.UTC)
+
+func newTestDB(t *testing.T) *DB {
+	t.Helper()
+	db, err := New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func client(cn, addr string, since time.Time, received, sent int64) parser.Client {
+	return parser.Client{CommonName: cn, RealAddress: addr, BytesReceived: received, BytesSent: sent, ConnectedSince: since}
+}
+
+func status(at time.Time, clients ...parser.Client) *parser.Status {
+	return &parser.Status{Version: 2, UpdatedAt: at, Clients: clients}
+}
+
+func mustSave(t *testing.T, db *DB, instance string, s *parser.Status) {
+	t.Helper()
+	if err := db.SaveSnapshot(instance, s); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// totals накопленный трафик пользователей: common_name -> {received, sent}
+func totals(t *testing.T, db *DB) map[string][2]int64 {
+	t.Helper()
+	traffic, _, err := db.GetTotalTrafficAll(Filter{}, ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := make(map[string][2]int64, len(traffic))
+	for _, u := range traffic {
+		out[u.CommonName] = [2]int64{u.BytesReceived, u.BytesSent}
+	}
+	return out
+}
+
+func TestSaveSnapshotDeltas(t *testing.T) {
+	db := newTestDB(t)
+	since := base.Add(-time.Hour)
+	mustSave(t, db, "", status(base, client("alice", "1.1.1.1:1000", since, 100, 10)))
+	mustSave(t, db, "", status(base.Add(time.Minute), client("alice", "1.1.1.1:1000", since, 250, 30)))
+	// Переподключение с того же адреса: новая сессия учитывается целиком
+	mustSave(t, db, "", status(base.Add(2*time.Minute), client("alice", "1.1.1.1:1000", base.Add(90*time.Second), 40, 4)))
+	// Сброс счётчиков без смены connected_since тоже считается новой сессией
+	mustSave(t, db, "", status(base.Add(3*time.Minute), client("alice", "1.1.1.1:1000", base.Add(90*time.Second), 5, 1)))
+	// Повтор того же снимка ничего не добавляет
+	mustSave(t, db, "", status(base.Add(4*time.Minute), client("alice", "1.1.1.1:1000", base.Add(90*time.Second), 5, 1)))
+
+	want := [2]int64{250 + 40 + 5, 30 + 4 + 1}
+	if got := totals(t, db)["alice"]; got != want {
+		t.Errorf("накоплено %v, ожидалось %v", got, want)
+	}
+
+	var n int
+	if err := db.conn.QueryRow("SELECT COUNT(*) FROM session_last_bytes").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	mustSave(t, db, "", status(base.Add(5*time.Minute)))
+	var after int
+	if err := db.conn.QueryRow("SELECT COUNT(*) FROM session_last_bytes").Scan(&after); err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 || after != 0 {
+		t.Errorf("session_last_bytes: %d строк до отключения, %d после", n, after)
+	}
+	if got := totals(t, db)["alice"]; got != want {
+		t.Errorf("после отключения накоплено %v, ожидалось %v", got, want)
+	}
+}
+
+// TestExtendedColumns колонки status-version 2/3 сохраняются и отдаются в /connected;
+// у клиентов status-version 1 их нет
+func TestExtendedColumns(t *testing.T) {
+	cid, pid := int64(0), int64(5)
+	tests := []struct {
+		name string
+		in   parser.Client
+	}{
+		{"status-version 1", client("alice", "1.1.1.1:1000", base.Add(-time.Hour), 1, 1)},
+		{"status-version 2", parser.Client{CommonName: "alice", RealAddress: "1.1.1.1:1000", VirtualAddr: "10.8.0.2",
+			VirtualIPv6: "fdde:1234::1000", Username: "al", ClientID: &cid, PeerID: &pid, Cipher: "AES-256-GCM",
+			ConnectedSince: base.Add(-time.Hour), BytesReceived: 1, BytesSent: 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			mustSave(t, db, "vpn1", status(base, tt.in))
+			clients, err := db.GetLatestSnapshot(Filter{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(clients) != 1 {
+				t.Fatalf("клиенты %+v", clients)
+			}
+			got := clients[0].Client
+			if got.VirtualIPv6 != tt.in.VirtualIPv6 || got.Username != tt.in.Username || got.Cipher != tt.in.Cipher {
+				t.Errorf("строковые колонки %+v", got)
+			}
+			if !sameID(got.ClientID, tt.in.ClientID) || !sameID(got.PeerID, tt.in.PeerID) {
+				t.Errorf("Client ID %v, Peer ID %v", got.ClientID, got.PeerID)
+			}
+		})
+	}
+}
+
+func sameID(a, b *int64) bool {
+	return a == nil && b == nil || a != nil && b != nil && *a == *b
+}
@@ -0,0 +1,62 @@
+package database
+
+import "time"
+
+// Filter ограничивает выборку по времени и инстансу. Нулевые From/To и пустой Instance — без ограничения
+type Filter struct {
+	From     time.Time // включительно
+	To       time.Time // не включительно
+	Instance string
+}
+
+// HasRange задан ли временной интервал
+func (f Filter) HasRange() bool {
+	return !f.From.IsZero() || !f.To.IsZero()
+}
+
+// dayWhere условие на колонку-день (YYYY-MM-DD) для дневных таблиц; день попадает, если пересекается с интервалом
+func (f Filter) dayWhere(col string) (string, []interface{}) {
+	where := "1=1"
+	var args []interface{}
+	if !f.From.IsZero() {
+		where += " AND " + col + " >= ?"
+		args = append(args, f.From.UTC().Format("2006-01-02"))
+	}
+	if !f.To.IsZero() {
+		where += " AND " + col + " <= ?"
+		args = append(args, f.To.UTC().Add(-time.Nanosecond).Format("2006-01-02"))
+	}
+	return where, args
+}
+
+// hourWhere условие на колонку-час (начало часа); час попадает, если пересекается с интервалом
+func (f Filter) hourWhere(col string) (string, []interface{}) {
+	where := "1=1"
+	var args []interface{}
+	if !f.From.IsZero() {
+		where += " AND " + col + " >= ?"
+		args = append(args, f.From.UTC().Truncate(time.Hour))
+	}
+	if !f.To.IsZero() {
+		where += " AND " + col + " < ?"
+		args = append(args, f.To.UTC())
+	}
+	return where, args
+}
+
+// instanceWhere условие на колонку инстанса
+func (f Filter) instanceWhere(col string) (string, []interface{}) {
+	if f.Instance == "" {
+		return "1=1", nil
+	}
+	return col + " = ?", []interface{}{f.Instance}
+}
+
+// userWhere оставляет пользователей, которые подключались к инстансу фильтра
+func (f Filter) userWhere(col string) (string, []interface{}) {
+	if f.Instance == "" {
+		return "1=1", nil
+	}
+	return col + ` IN (SELECT user_id FROM sessions WHERE instance = ? UNION SELECT user_id FROM user_traffic_totals WHERE instance = ?)`,
+		[]interface{}{f.Instance, f.Instance}
+}
@@ -0,0 +1,40 @@
+package database
+
+import (
+	"database/sql"
+	"net/netip"
+	"strings"
+
+	"github.com/mattn/go-sqlite3"
+
+	"open-statistic/internal/parser"
+)
+
+// driverName драйвер SQLite с функциями openstat, которые нужны фильтрам в SQL
+const driverName = "sqlite3_openstat"
+
+func init() {
+	sql.Register(driverName, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			if err := conn.RegisterFunc("ip_in_cidr", ipInCIDR, true); err != nil {
+				return err
+			}
+			// lower() в SQLite понимает только ASCII, а алиасы бывают кириллицей
+			return conn.RegisterFunc("casefold", strings.ToLower, true)
+		},
+	})
+}
+
+// ipInCIDR ip_in_cidr(real_address, cidr): адрес клиента (с портом и префиксом протокола,
+// как в status-файле) входит в подсеть
+func ipInCIDR(addr, cidr string) bool {
+	ip, ok := parser.RealIP(addr)
+	if !ok {
+		return false
+	}
+	prefix, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return false
+	}
+	return prefix.Contains(ip)
+}
@@ -0,0 +1,95 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+)
+
+// HourlyTraffic трафик за один час (начало часа в UTC)
+type HourlyTraffic struct {
+	Hour          time.Time `json:"hour"`
+	BytesReceived int64     `json:"bytes_received"`
+	BytesSent     int64     `json:"bytes_sent"`
+	TotalBytes    int64     `json:"total_bytes"`
+}
+
+// RollupResult итог свёртки почасовых данных в дневные
+type RollupResult struct {
+	Cutoff          time.Time `json:"cutoff"`
+	HoursRemoved    int64     `json:"hours_removed"`
+	UserRowsRemoved int64     `json:"user_rows_removed"`
+}
+
+// GetHourlyTraffic возвращает почасовой трафик в интервале фильтра (commonName="" — по всем пользователям).
+// Без интервала — последние 24 часа
+func (db *DB) GetHourlyTraffic(commonName string, f Filter) ([]HourlyTraffic, error) {
+	if !f.HasRange() {
+		f.From = time.Now().UTC().Add(-24 * time.Hour).Truncate(time.Hour)
+	}
+	where, args := f.hourWhere("h.hour")
+	inst, instArgs := f.instanceWhere("h.instance")
+	args = append(args, instArgs...)
+	query := `SELECT h.hour, SUM(h.bytes_received), SUM(h.bytes_sent) FROM hourly_traffic_totals h WHERE ` + where + ` AND ` + inst + ` GROUP BY h.hour ORDER BY h.hour`
+	if commonName != "" {
+		query = `
+			SELECT h.hour, SUM(h.bytes_received), SUM(h.bytes_sent)
+			FROM user_hourly_traffic h
+			JOIN users u ON u.id = h.user_id
+			WHERE u.common_name = ? AND ` + where + ` AND ` + inst + `
+			GROUP BY h.hour
+			ORDER BY h.hour`
+		args = append([]interface{}{commonName}, args...)
+	}
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]HourlyTraffic, 0, 24)
+	for rows.Next() {
+		var h HourlyTraffic
+		if err := rows.Scan(&h.Hour, &h.BytesReceived, &h.BytesSent); err != nil {
+			return nil, err
+		}
+		h.TotalBytes = h.BytesReceived + h.BytesSent
+		result = append(result, h)
+	}
+	return result, rows.Err()
+}
+
+// RollupHourly сворачивает почасовые строки до cutoff (начало суток) в дневные таблицы и удаляет их.
+// Дневные таблицы и так пополняются при каждом снимке, поэтому свёртка лишь сверяет их:
+// значение дня не уменьшается (берётся максимум), а повторный запуск ничего не меняет.
+func (db *DB) RollupHourly(cutoff time.Time) (*RollupResult, error) {
+	res := &RollupResult{Cutoff: cutoff}
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`
+		INSERT INTO daily_traffic_totals (instance, day, bytes_received, bytes_sent)
+		SELECT instance, date(hour), SUM(bytes_received), SUM(bytes_sent) FROM hourly_traffic_totals WHERE hour < ? GROUP BY instance, date(hour)
+		ON CONFLICT(instance, day) DO UPDATE SET bytes_received=MAX(bytes_received, excluded.bytes_received), bytes_sent=MAX(bytes_sent, excluded.bytes_sent)`, cutoff); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(`
+		INSERT INTO user_daily_traffic (user_id, instance, day, bytes_received, bytes_sent)
+		SELECT user_id, instance, date(hour), SUM(bytes_received), SUM(bytes_sent) FROM user_hourly_traffic WHERE hour < ? GROUP BY user_id, instance, date(hour)
+		ON CONFLICT(user_id, instance, day) DO UPDATE SET bytes_received=MAX(bytes_received, excluded.bytes_received), bytes_sent=MAX(bytes_sent, excluded.bytes_sent)`, cutoff); err != nil {
+		return nil, err
+	}
+
+	var r sql.Result
+	if r, err = tx.Exec(`DELETE FROM hourly_traffic_totals WHERE hour < ?`, cutoff); err != nil {
+		return nil, err
+	}
+	res.HoursRemoved, _ = r.RowsAffected()
+	if r, err = tx.Exec(`DELETE FROM user_hourly_traffic WHERE hour < ?`, cutoff); err != nil {
+		return nil, err
+	}
+	res.UserRowsRemoved, _ = r.RowsAffected()
+	return res, tx.Commit()
+}
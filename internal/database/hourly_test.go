@@ -0,0 +1,87 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGetHourlyTraffic(t *testing.T) {
+	db := newTestDB(t)
+	liveHistory(t, db)
+	day := Filter{From: base.Truncate(24 * time.Hour), To: base.Add(12 * time.Hour)}
+	tests := []struct {
+		name string
+		cn   string
+		f    Filter
+		want []string // час:получено/отправлено
+	}{
+		{"все пользователи", "", day, []string{"10:1450/145", "11:4600/460"}},
+		{"один инстанс", "", Filter{From: day.From, To: day.To, Instance: "vpn1"}, []string{"10:450/45", "11:600/60"}},
+		{"пользователь", "alice", day, []string{"10:400/40", "11:530/53"}},
+		{"пользователь до 11:00", "bob", Filter{From: day.From, To: base.Add(59 * time.Minute)}, []string{"10:50/5"}},
+		{"нет пользователя", "dave", day, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hours, err := db.GetHourlyTraffic(tt.cn, tt.f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, h := range hours {
+				if h.TotalBytes != h.BytesReceived+h.BytesSent {
+					t.Errorf("%s: total_bytes %d", h.Hour, h.TotalBytes)
+				}
+				got = append(got, fmt.Sprintf("%02d:%d/%d", h.Hour.UTC().Hour(), h.BytesReceived, h.BytesSent))
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("%v, ожидалось %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestRollupHourly свёртка удаляет почасовые строки до cutoff, не меняя дневных итогов,
+// и повторный запуск ничего не меняет
+func TestRollupHourly(t *testing.T) {
+	db := newTestDB(t)
+	liveHistory(t, db)
+	daily := func() map[string][2]int64 {
+		out := make(map[string][2]int64)
+		for k, v := range aggregates(t, db) {
+			if !strings.Contains(k, "hourly") {
+				out[k] = v
+			}
+		}
+		return out
+	}
+	before := daily()
+
+	tests := []struct {
+		name      string
+		cutoff    time.Time
+		wantHours int64 // строк hourly_traffic_totals удалено
+		wantUser  int64 // строк user_hourly_traffic удалено
+	}{
+		{"до данных", base.Truncate(24 * time.Hour), 0, 0},
+		{"начало следующих суток", base.Truncate(24*time.Hour).AddDate(0, 0, 1), 4, 6},
+		{"повторно", base.Truncate(24*time.Hour).AddDate(0, 0, 1), 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := db.RollupHourly(tt.cutoff)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.HoursRemoved != tt.wantHours || res.UserRowsRemoved != tt.wantUser {
+				t.Errorf("удалено %d/%d, ожидалось %d/%d", res.HoursRemoved, res.UserRowsRemoved, tt.wantHours, tt.wantUser)
+			}
+			sameAggregates(t, daily(), before)
+		})
+	}
+	if hours, err := db.GetHourlyTraffic("", Filter{From: base.Truncate(24 * time.Hour), To: base.Add(12 * time.Hour)}); err != nil || len(hours) != 0 {
+		t.Errorf("почасовые после свёртки: %+v, %v", hours, err)
+	}
+}
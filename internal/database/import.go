@@ -0,0 +1,187 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"open-statistic/internal/parser"
+)
+
+// Итог импорта снимка из архива (ImportSnapshot)
+const (
+	ImportOK        = "imported"
+	ImportDuplicate = "duplicate"     // снимок с этим Updated уже импортирован
+	ImportOlder     = "older"         // старше последнего импортированного снимка инстанса
+	ImportOverlap   = "overlaps_live" // этот период уже собран сервером
+)
+
+// importState состояние импорта инстанса: liveFrom — начало живого сбора на момент первого
+// импорта (zero — данных ещё не было), lastAt — последний импортированный снимок
+type importState struct {
+	exists   bool
+	liveFrom time.Time
+	lastAt   time.Time
+}
+
+// ImportSnapshot воспроизводит снимок из архива status-файлов тем же расчётом приращений, что
+// и SaveSnapshot, но со своей цепочкой счётчиков (import_last_bytes), чтобы не сбить живой сбор.
+// Снимки инстанса подаются по возрастанию Updated; импортированный снимок записывается
+// в import_log, и повторный импорт его пропускает. Снимки не раньше начала живого сбора
+// отклоняются — этот трафик уже учтён. hash и source (файл) сохраняются в журнале
+func (db *DB) ImportSnapshot(instance string, status *parser.Status, hash, source string) (string, error) {
+	if instance == "" {
+		instance = DefaultInstance
+	}
+	if status.UpdatedAt.IsZero() {
+		return "", fmt.Errorf("у снимка нет времени Updated")
+	}
+	at := status.UpdatedAt.UTC()
+
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	var n int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM import_log WHERE instance = ? AND snapshot_at = ?", instance, at).Scan(&n); err != nil {
+		return "", err
+	}
+	if n > 0 {
+		return ImportDuplicate, nil
+	}
+	st, err := loadImportState(tx, instance)
+	if err != nil {
+		return "", err
+	}
+	if !st.lastAt.IsZero() && !at.After(st.lastAt) {
+		return ImportOlder, nil
+	}
+	boundary := st.liveFrom
+	if boundary.IsZero() && st.exists {
+		// Живой сбор начался после импорта и продолжил его цепочку счётчиков:
+		// более поздние архивы пересеклись бы с ним
+		var live int
+		if err := tx.QueryRow(`
+			SELECT (SELECT COUNT(*) FROM traffic_snapshots WHERE instance = ? AND snapshot_at > ?)
+				+ (SELECT COUNT(*) FROM sessions WHERE instance = ? AND (last_seen_at > ? OR ended_at > ?))`,
+			instance, st.lastAt, instance, st.lastAt, st.lastAt).Scan(&live); err != nil {
+			return "", err
+		}
+		if live > 0 {
+			boundary = st.lastAt
+		}
+	}
+	if !boundary.IsZero() && !at.Before(boundary) {
+		return ImportOverlap, nil
+	}
+
+	cur, err := db.writeSnapshot(tx, instance, status, at, "import_last_bytes")
+	if err != nil {
+		return "", err
+	}
+	if st.liveFrom.IsZero() {
+		// Живого сбора ещё не было: он продолжит с последнего импортированного снимка
+		if _, err := tx.Exec("DELETE FROM session_last_bytes WHERE instance = ?", instance); err != nil {
+			return "", err
+		}
+		if _, err := tx.Exec(`
+			INSERT INTO session_last_bytes (instance, user_id, real_address, bytes_received, bytes_sent, connected_since, seen_at)
+			SELECT instance, user_id, real_address, bytes_received, bytes_sent, connected_since, seen_at FROM import_last_bytes WHERE instance = ?`, instance); err != nil {
+			return "", err
+		}
+	} else if err := adjustLiveOverlap(tx, instance, cur, st.liveFrom); err != nil {
+		return "", err
+	}
+
+	if _, err := tx.Exec("INSERT INTO import_log (instance, snapshot_at, hash, source, clients, imported_at) VALUES (?, ?, ?, ?, ?, ?)",
+		instance, at, hash, source, len(cur), time.Now().UTC()); err != nil {
+		return "", err
+	}
+	var liveFrom interface{}
+	if !st.liveFrom.IsZero() {
+		liveFrom = st.liveFrom
+	}
+	if _, err := tx.Exec(`INSERT INTO import_state (instance, live_from, last_at) VALUES (?, ?, ?)
+		ON CONFLICT(instance) DO UPDATE SET last_at=excluded.last_at`, instance, liveFrom, at); err != nil {
+		return "", err
+	}
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+	return ImportOK, nil
+}
+
+// loadImportState читает состояние импорта инстанса. При первом импорте начало живого сбора —
+// самый ранний сохранённый снимок или сессия
+func loadImportState(tx *sql.Tx, instance string) (importState, error) {
+	var st importState
+	var liveFrom, lastAt sql.NullTime
+	err := tx.QueryRow("SELECT live_from, last_at FROM import_state WHERE instance = ?", instance).Scan(&liveFrom, &lastAt)
+	if err == nil {
+		st.exists = true
+		if liveFrom.Valid {
+			st.liveFrom = liveFrom.Time.UTC()
+		}
+		if lastAt.Valid {
+			st.lastAt = lastAt.Time.UTC()
+		}
+		return st, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return st, err
+	}
+	for _, q := range []string{
+		"SELECT MIN(snapshot_at) FROM traffic_snapshots WHERE instance = ?",
+		"SELECT MIN(first_seen_at) FROM sessions WHERE instance = ?",
+	} {
+		var first sql.NullString
+		if err := tx.QueryRow(q, instance).Scan(&first); err != nil {
+			return st, err
+		}
+		if !first.Valid {
+			continue
+		}
+		if t, ok := parseDBTime(first.String); ok && (st.liveFrom.IsZero() || t.Before(st.liveFrom)) {
+			st.liveFrom = t
+		}
+	}
+	return st, nil
+}
+
+// adjustLiveOverlap убирает двойной учёт сессий, продолжившихся в живом сборе: первый живой
+// снимок учёл такую сессию целиком, а её байты до конца архива уже добавил импорт. Эта часть
+// вычитается в момент начала живого сбора; уже вычтенное хранится в import_adjustments
+func adjustLiveOverlap(tx *sql.Tx, instance string, cur map[sessionKey]sessionBytes, liveFrom time.Time) error {
+	for k, v := range cur {
+		var n int
+		if err := tx.QueryRow(`SELECT COUNT(*) FROM sessions WHERE instance = ? AND user_id = ? AND real_address = ? AND connected_since = ? AND last_seen_at >= ?`,
+			instance, k.uid, k.addr, v.cs, liveFrom).Scan(&n); err != nil {
+			return err
+		}
+		if n == 0 {
+			continue
+		}
+		var done sessionBytes
+		err := tx.QueryRow(`SELECT bytes_received, bytes_sent FROM import_adjustments WHERE instance = ? AND user_id = ? AND real_address = ? AND connected_since = ?`,
+			instance, k.uid, k.addr, v.cs).Scan(&done.r, &done.s)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		dr, ds := v.r-done.r, v.s-done.s
+		if dr == 0 && ds == 0 {
+			continue
+		}
+		if err := addTraffic(tx, instance, k.uid, -dr, -ds, liveFrom); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`INSERT INTO import_adjustments (instance, user_id, real_address, connected_since, bytes_received, bytes_sent) VALUES (?, ?, ?, ?, ?, ?)
+			ON CONFLICT(instance, user_id, real_address, connected_since) DO UPDATE SET bytes_received=excluded.bytes_received, bytes_sent=excluded.bytes_sent`,
+			instance, k.uid, k.addr, v.cs, v.r, v.s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+// TestImportBeforeLive архив без живого сбора: живой сбор продолжает цепочку счётчиков импорта
+func TestImportBeforeLive(t *testing.T) {
+	db := newTestDB(t)
+	since := base.Add(-time.Hour)
+	archive := []struct {
+		at       time.Time
+		received int64
+		want     string
+	}{
+		{base, 100, ImportOK},
+		{base.Add(time.Minute), 200, ImportOK},
+		{base.Add(time.Minute), 200, ImportDuplicate},
+	}
+	for _, a := range archive {
+		got, err := db.ImportSnapshot("vpn1", status(a.at, client("alice", "1.1.1.1:1000", since, a.received, 10)), "", "archive")
+		if err != nil || got != a.want {
+			t.Fatalf("импорт %s: %q, %v; ожидалось %q", a.at.Format(time.TimeOnly), got, err, a.want)
+		}
+	}
+	if got, _ := db.ImportSnapshot("vpn1", status(base.Add(-time.Minute)), "", "archive"); got != ImportOlder {
+		t.Errorf("снимок старше последнего: %q", got)
+	}
+	mustSave(t, db, "vpn1", status(base.Add(2*time.Minute), client("alice", "1.1.1.1:1000", since, 250, 20)))
+	if got := totals(t, db)["alice"]; got != [2]int64{250, 20} {
+		t.Errorf("накоплено %v, ожидалось [250 20]", got)
+	}
+	// Живой сбор продолжил импорт: более поздний архив пересекается с ним
+	if got, _ := db.ImportSnapshot("vpn1", status(base.Add(3*time.Minute)), "", "archive"); got != ImportOverlap {
+		t.Errorf("архив после начала живого сбора: %q", got)
+	}
+}
+
+// TestImportOverlapsLive архив за период до живого сбора: сессия, продолжившаяся в живом сборе,
+// уже учтена первым живым снимком целиком, и её архивная часть не добавляется второй раз
+func TestImportOverlapsLive(t *testing.T) {
+	db := newTestDB(t)
+	aliceSince := base.Add(-time.Hour)
+	bobSince := base.Add(-30 * time.Minute)
+	live := base.Add(10 * time.Minute)
+	mustSave(t, db, "vpn1", status(live, client("alice", "1.1.1.1:1000", aliceSince, 500, 50)))
+	mustSave(t, db, "vpn1", status(live.Add(time.Minute), client("alice", "1.1.1.1:1000", aliceSince, 600, 60)))
+	if got := totals(t, db)["alice"]; got != [2]int64{600, 60} {
+		t.Fatalf("живой сбор: %v", got)
+	}
+
+	// bob отключился до живого сбора; в снимке live архив совпадает с живым сбором
+	archive := []struct {
+		at    time.Time
+		alice int64
+		bob   int64
+		want  string
+	}{
+		{base, 100, 40, ImportOK},
+		{base.Add(5 * time.Minute), 300, 70, ImportOK},
+		{live, 500, 0, ImportOverlap},
+	}
+	for _, a := range archive {
+		s := status(a.at, client("alice", "1.1.1.1:1000", aliceSince, a.alice, a.alice/10))
+		if a.bob > 0 {
+			s.Clients = append(s.Clients, client("bob", "2.2.2.2:2000", bobSince, a.bob, a.bob/10))
+		}
+		got, err := db.ImportSnapshot("vpn1", s, "", "archive")
+		if err != nil || got != a.want {
+			t.Fatalf("импорт %s: %q, %v; ожидалось %q", a.at.Format(time.TimeOnly), got, err, a.want)
+		}
+	}
+
+	tot := totals(t, db)
+	// alice: 600 из живого сбора, архивные 300 вычтены в момент начала живого сбора
+	if got := tot["alice"]; got != [2]int64{600, 60} {
+		t.Errorf("alice %v, ожидалось [600 60]", got)
+	}
+	// bob отключился до живого сбора: его трафик есть только в архиве
+	if got := tot["bob"]; got != [2]int64{70, 7} {
+		t.Errorf("bob %v, ожидалось [70 7]", got)
+	}
+	// Архивный трафик остаётся в своём периоде, вычет приходится на начало живого сбора
+	before, err := db.GetTotalTraffic("alice", Filter{To: live.Add(-time.Second)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, err := db.GetTotalTraffic("alice", Filter{From: live})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if before.BytesReceived != 300 || after.BytesReceived != 300 {
+		t.Errorf("alice до живого сбора %d, после %d; ожидалось 300 и 300", before.BytesReceived, after.BytesReceived)
+	}
+
+	// Повторный импорт того же периода ничего не меняет
+	if got, _ := db.ImportSnapshot("vpn1", status(base.Add(5*time.Minute)), "", "archive"); got != ImportDuplicate {
+		t.Errorf("повторный импорт: %q", got)
+	}
+}
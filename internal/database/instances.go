@@ -0,0 +1,185 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DefaultInstance инстанс, к которому относятся данные без явного имени
+// (в том числе собранные до появления нескольких инстансов). Совпадает с DEFAULT в схеме
+const DefaultInstance = "default"
+
+// instanceKeyedTables таблицы, в первичный ключ которых входит инстанс.
+// В БД предыдущих версий их ключ был без инстанса — такие таблицы пересоздаются
+var instanceKeyedTables = []string{
+	"user_traffic_totals",
+	"daily_traffic_totals",
+	"user_daily_traffic",
+	"hourly_traffic_totals",
+	"user_hourly_traffic",
+	"session_last_bytes",
+	"sessions",
+}
+
+const legacySuffix = "_legacy"
+
+// renameLegacyTables переименовывает таблицы со старым ключом в <table>_legacy, чтобы схема
+// создала их заново. Индексы старых таблиц удаляются, иначе CREATE INDEX IF NOT EXISTS их пропустит
+func (db *DB) renameLegacyTables() error {
+	for _, table := range instanceKeyedTables {
+		cols, err := db.tableColumns(table)
+		if err != nil {
+			return err
+		}
+		if len(cols) == 0 || hasColumn(cols, "instance") {
+			continue
+		}
+		if legacy, err := db.tableColumns(table + legacySuffix); err != nil {
+			return err
+		} else if len(legacy) > 0 {
+			return fmt.Errorf("%s: не завершён перенос из %s%s", table, table, legacySuffix)
+		}
+		rows, err := db.conn.Query("SELECT name FROM sqlite_master WHERE type='index' AND tbl_name=? AND sql IS NOT NULL", table)
+		if err != nil {
+			return err
+		}
+		var indexes []string
+		for rows.Next() {
+			var name string
+			if err := rows.Scan(&name); err != nil {
+				rows.Close()
+				return err
+			}
+			indexes = append(indexes, name)
+		}
+		rows.Close()
+		for _, idx := range indexes {
+			if _, err := db.conn.Exec("DROP INDEX " + idx); err != nil {
+				return err
+			}
+		}
+		if _, err := db.conn.Exec(fmt.Sprintf("ALTER TABLE %s RENAME TO %s%s", table, table, legacySuffix)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyLegacyTables переносит строки из <table>_legacy в новые таблицы (инстанс — DefaultInstance)
+// и удаляет старые таблицы
+func (db *DB) copyLegacyTables() error {
+	for _, table := range instanceKeyedTables {
+		cols, err := db.tableColumns(table + legacySuffix)
+		if err != nil {
+			return err
+		}
+		if len(cols) == 0 {
+			continue
+		}
+		list := strings.Join(cols, ", ")
+		tx, err := db.conn.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(fmt.Sprintf("INSERT INTO %s (%s) SELECT %s FROM %s%s", table, list, list, table, legacySuffix)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("перенос %s: %w", table, err)
+		}
+		if _, err := tx.Exec(fmt.Sprintf("DROP TABLE %s%s", table, legacySuffix)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tableColumns имена колонок таблицы; пусто, если таблицы нет
+func (db *DB) tableColumns(table string) ([]string, error) {
+	rows, err := db.conn.Query("SELECT name FROM pragma_table_info(?)", table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var cols []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		cols = append(cols, name)
+	}
+	return cols, rows.Err()
+}
+
+func hasColumn(cols []string, name string) bool {
+	for _, c := range cols {
+		if c == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Instances возвращает имена инстансов, по которым есть данные
+func (db *DB) Instances() ([]string, error) {
+	rows, err := db.conn.Query(`
+		SELECT instance FROM user_traffic_totals
+		UNION SELECT instance FROM sessions
+		ORDER BY instance`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := make([]string, 0, 4)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		result = append(result, name)
+	}
+	return result, rows.Err()
+}
+
+// GetInstanceTotals возвращает накопленный трафик по пользователям отдельно для каждого инстанса
+func (db *DB) GetInstanceTotals(f Filter) ([]UserTraffic, error) {
+	inst, args := f.instanceWhere("t.instance")
+	rows, err := db.conn.Query(`
+		SELECT u.common_name, t.instance, t.bytes_received, t.bytes_sent
+		FROM user_traffic_totals t
+		JOIN users u ON u.id = t.user_id
+		WHERE `+inst+`
+		ORDER BY t.instance, u.common_name`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := make([]UserTraffic, 0, 32)
+	for rows.Next() {
+		var ut UserTraffic
+		if err := rows.Scan(&ut.CommonName, &ut.Instance, &ut.BytesReceived, &ut.BytesSent); err != nil {
+			return nil, err
+		}
+		ut.TotalBytes = ut.BytesReceived + ut.BytesSent
+		result = append(result, ut)
+	}
+	return result, rows.Err()
+}
+
+// LastSnapshotAt время последнего снимка инстанса (zero, если снимков нет)
+func (db *DB) LastSnapshotAt(instance string) (time.Time, error) {
+	var last sql.NullString
+	if err := db.conn.QueryRow("SELECT MAX(snapshot_at) FROM traffic_snapshots WHERE instance = ?", instance).Scan(&last); err != nil {
+		return time.Time{}, err
+	}
+	if !last.Valid {
+		return time.Time{}, nil
+	}
+	t, _ := parseDBTime(last.String)
+	return t, nil
+}
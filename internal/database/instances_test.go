@@ -0,0 +1,101 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestInstancesSeparate один и тот же клиент (CN и адрес) на двух инстансах считается
+// по каждому инстансу отдельно: счётчики одного не дают приращений другому
+func TestInstancesSeparate(t *testing.T) {
+	db := newTestDB(t)
+	since := base.Add(-time.Hour)
+	mustSave(t, db, "udp1", status(base, client("alice", "1.1.1.1:1000", since, 1000, 100)))
+	mustSave(t, db, "tcp1", status(base.Add(time.Second), client("alice", "1.1.1.1:1000", since, 10, 1)))
+	mustSave(t, db, "udp1", status(base.Add(time.Minute), client("alice", "1.1.1.1:1000", since, 1500, 150)))
+	mustSave(t, db, "tcp1", status(base.Add(time.Minute), client("alice", "1.1.1.1:1000", since, 30, 3), client("bob", "2.2.2.2:2000", since, 7, 7)))
+
+	names, err := db.Instances()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(names) != "[tcp1 udp1]" {
+		t.Errorf("инстансы %v", names)
+	}
+	if got := totals(t, db)["alice"]; got != [2]int64{1530, 153} {
+		t.Errorf("alice по всем инстансам %v, ожидалось [1530 153]", got)
+	}
+
+	tests := []struct {
+		name string
+		f    Filter
+		want []string // инстанс/пользователь:получено/отправлено
+	}{
+		{"все", Filter{}, []string{"tcp1/alice:30/3", "tcp1/bob:7/7", "udp1/alice:1500/150"}},
+		{"udp1", Filter{Instance: "udp1"}, []string{"udp1/alice:1500/150"}},
+		{"нет такого", Filter{Instance: "vpn9"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows, err := db.GetInstanceTotals(tt.f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, r := range rows {
+				got = append(got, fmt.Sprintf("%s/%s:%d/%d", r.Instance, r.CommonName, r.BytesReceived, r.BytesSent))
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("%v, ожидалось %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestLegacyInstanceMigration данные БД без инстансов переносятся в DefaultInstance,
+// и сбор продолжается с сохранённых счётчиков сессии
+func TestLegacyInstanceMigration(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "legacy.db")
+	conn, err := sql.Open(driverName, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Exec(`
+		CREATE TABLE users (id INTEGER PRIMARY KEY AUTOINCREMENT, common_name TEXT NOT NULL UNIQUE, created_at DATETIME DEFAULT CURRENT_TIMESTAMP);
+		CREATE TABLE user_traffic_totals (user_id INTEGER PRIMARY KEY, bytes_received BIGINT NOT NULL DEFAULT 0, bytes_sent BIGINT NOT NULL DEFAULT 0);
+		CREATE TABLE daily_traffic_totals (day DATE PRIMARY KEY, bytes_received BIGINT NOT NULL DEFAULT 0, bytes_sent BIGINT NOT NULL DEFAULT 0);
+		CREATE TABLE session_last_bytes (user_id INTEGER NOT NULL, real_address TEXT NOT NULL, bytes_received BIGINT NOT NULL, bytes_sent BIGINT NOT NULL, PRIMARY KEY (user_id, real_address));
+		CREATE INDEX idx_session_last_bytes_user ON session_last_bytes(user_id);
+		INSERT INTO users (id, common_name) VALUES (1, 'alice');
+		INSERT INTO user_traffic_totals VALUES (1, 500, 50);
+		INSERT INTO daily_traffic_totals VALUES ('2024-02-23', 500, 50);
+		INSERT INTO session_last_bytes VALUES (1, '1.1.1.1:1000', 500, 50);`); err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	db, err := New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for _, table := range instanceKeyedTables {
+		if cols, err := db.tableColumns(table + legacySuffix); err != nil || len(cols) > 0 {
+			t.Errorf("%s%s не удалена: %v, %v", table, legacySuffix, cols, err)
+		}
+	}
+	rows, err := db.GetInstanceTotals(Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0].Instance != DefaultInstance || rows[0].BytesReceived != 500 {
+		t.Fatalf("после переноса %+v", rows)
+	}
+	mustSave(t, db, DefaultInstance, status(base, client("alice", "1.1.1.1:1000", base.Add(-time.Hour), 800, 80)))
+	if got := totals(t, db)["alice"]; got != [2]int64{800, 80} {
+		t.Errorf("после снимка %v, ожидалось [800 80]", got)
+	}
+}
@@ -0,0 +1,79 @@
+package database
+
+import (
+	"strings"
+	"time"
+)
+
+// KillRecord запись журнала принудительных отключений (POST /connected/:name/kill)
+type KillRecord struct {
+	ID          int64     `json:"id"`
+	At          time.Time `json:"at"`
+	Instance    string    `json:"instance"`
+	CommonName  string    `json:"common_name"`
+	RealAddress string    `json:"real_address,omitempty"` // пусто — отключались все сессии CN
+	Reason      string    `json:"reason"`
+	RequestedBy string    `json:"requested_by,omitempty"` // кто отключил: ключ API (api_key) или quota
+	Note        string    `json:"note,omitempty"`         // пометка из запроса
+	RemoteAddr  string    `json:"remote_addr"`            // IP, с которого пришёл запрос
+	Result      string    `json:"result,omitempty"`       // ответ OpenVPN на kill
+	Error       string    `json:"error,omitempty"`
+}
+
+// LogKill сохраняет запись о попытке отключения, успешной или нет
+func (db *DB) LogKill(r KillRecord) (int64, error) {
+	res, err := db.conn.Exec(`
+		INSERT INTO kill_log (at, instance, common_name, real_address, reason, requested_by, note, remote_addr, result, error)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		r.At.UTC(), r.Instance, r.CommonName, r.RealAddress, r.Reason, r.RequestedBy, r.Note, r.RemoteAddr, r.Result, r.Error)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// GetKillLog журнал отключений, новые первыми. commonName="" — по всем
+func (db *DB) GetKillLog(commonName string, f Filter, limit int) ([]KillRecord, error) {
+	where := []string{"1=1"}
+	var args []interface{}
+	if commonName != "" {
+		where = append(where, "common_name = ?")
+		args = append(args, commonName)
+	}
+	if f.Instance != "" {
+		where = append(where, "instance = ?")
+		args = append(args, f.Instance)
+	}
+	if !f.From.IsZero() {
+		where = append(where, "at >= ?")
+		args = append(args, f.From.UTC())
+	}
+	if !f.To.IsZero() {
+		where = append(where, "at < ?")
+		args = append(args, f.To.UTC())
+	}
+	if limit <= 0 {
+		limit = 100
+	}
+	args = append(args, limit)
+	rows, err := db.conn.Query(`
+		SELECT id, at, instance, common_name, real_address, reason, requested_by, note, remote_addr, result, error
+		FROM kill_log
+		WHERE `+strings.Join(where, " AND ")+`
+		ORDER BY at DESC, id DESC LIMIT ?`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]KillRecord, 0, 16)
+	for rows.Next() {
+		var r KillRecord
+		if err := rows.Scan(&r.ID, &r.At, &r.Instance, &r.CommonName, &r.RealAddress, &r.Reason,
+			&r.RequestedBy, &r.Note, &r.RemoteAddr, &r.Result, &r.Error); err != nil {
+			return nil, err
+		}
+		result = append(result, r)
+	}
+	return result, rows.Err()
+}
@@ -0,0 +1,237 @@
+package database
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+)
+
+// ErrInvalidList неверные фильтры, сортировка или курсор списка
+var ErrInvalidList = errors.New("неверные параметры списка")
+
+// ListOptions фильтры, сортировка и страницы списков /traffic, /traffic/total, /users и /connected.
+// Всё применяется в SQL. Нулевое значение — весь список в порядке по умолчанию
+type ListOptions struct {
+	Name     string // префикс Common Name или glob-шаблон (* ? [...])
+	Alias    string // подстрока алиаса без учёта регистра
+	MinBytes int64  // total_bytes не меньше
+	CIDR     string // real_address в подсети; у списков без адреса — пользователи с сессиями из подсети
+	Sort     string // колонка сортировки, "-" в начале — по убыванию
+	Limit    int    // 0 — без ограничения
+	Cursor   string // next_cursor предыдущей страницы
+}
+
+// listSpec колонки внутреннего запроса списка, доступные фильтрам и сортировке.
+// Запрос обязан отдавать common_name, alias и user_id
+type listSpec struct {
+	sorts       []string // допустимые колонки сортировки
+	defaultSort string
+	keys        []string // колонки, делающие порядок однозначным (дописываются в ORDER BY)
+	address     bool     // есть колонка real_address
+	bytes       bool     // есть колонка total_bytes
+}
+
+// aliasExpr алиас пользователя (по Common Name) для внутренних запросов списков
+func aliasExpr(cn string) string {
+	return "COALESCE((SELECT alias FROM user_aliases a WHERE a.common_name = " + cn + " AND a.real_address = '' AND a.alias != ''), '')"
+}
+
+// aliasAddrExpr алиас устройства (Common Name и адрес), иначе пользователя
+func aliasAddrExpr(cn, addr string) string {
+	return "COALESCE((SELECT alias FROM user_aliases a WHERE a.common_name = " + cn + " AND a.real_address = " + addr + " AND a.alias != ''), " +
+		"(SELECT alias FROM user_aliases a WHERE a.common_name = " + cn + " AND a.real_address = '' AND a.alias != ''), '')"
+}
+
+// listQuery оборачивает внутренний запрос фильтрами, сортировкой и страницей. В конец
+// выборки после cols добавляются колонки порядка — из них scanList собирает курсор.
+// instance ограничивает сессии для фильтра по подсети у списков без адреса
+func (o ListOptions) listQuery(spec listSpec, inner string, innerArgs []interface{}, cols, instance string) (string, []interface{}, []string, error) {
+	where := []string{"1=1"}
+	args := append([]interface{}{}, innerArgs...)
+
+	if o.Name != "" {
+		pattern := o.Name
+		if !strings.ContainsAny(pattern, "*?[") {
+			pattern = globEscape(pattern) + "*"
+		}
+		where = append(where, "l.common_name GLOB ?")
+		args = append(args, pattern)
+	}
+	if o.Alias != "" {
+		where = append(where, "instr(casefold(l.alias), casefold(?)) > 0")
+		args = append(args, o.Alias)
+	}
+	if o.MinBytes > 0 {
+		if !spec.bytes {
+			return "", nil, nil, fmt.Errorf("%w: min_bytes не поддерживается этим списком", ErrInvalidList)
+		}
+		where = append(where, "l.total_bytes >= ?")
+		args = append(args, o.MinBytes)
+	}
+	if o.CIDR != "" {
+		prefix, err := netip.ParsePrefix(o.CIDR)
+		if err != nil {
+			return "", nil, nil, fmt.Errorf("%w: cidr %q", ErrInvalidList, o.CIDR)
+		}
+		if spec.address {
+			where = append(where, "ip_in_cidr(l.real_address, ?)")
+			args = append(args, prefix.String())
+		} else {
+			cond := "EXISTS (SELECT 1 FROM sessions s WHERE s.user_id = l.user_id AND ip_in_cidr(s.real_address, ?)"
+			args = append(args, prefix.String())
+			if instance != "" {
+				cond += " AND s.instance = ?"
+				args = append(args, instance)
+			}
+			where = append(where, cond+")")
+		}
+	}
+
+	sortCol, desc := o.Sort, false
+	if sortCol == "" {
+		sortCol = spec.defaultSort
+	}
+	if strings.HasPrefix(sortCol, "-") {
+		sortCol, desc = sortCol[1:], true
+	}
+	if !slices.Contains(spec.sorts, sortCol) {
+		return "", nil, nil, fmt.Errorf("%w: sort: допустимо %s (с - — по убыванию)", ErrInvalidList, strings.Join(spec.sorts, ", "))
+	}
+	order := []string{sortCol}
+	for _, k := range spec.keys {
+		if k != sortCol {
+			order = append(order, k)
+		}
+	}
+	dir := func(i int) string {
+		if i == 0 && desc {
+			return "DESC"
+		}
+		return "ASC"
+	}
+
+	if o.Cursor != "" {
+		values, err := decodeCursor(o.Cursor, len(order))
+		if err != nil {
+			return "", nil, nil, err
+		}
+		// Строки после курсора: (c1, c2, ...) дальше по порядку сортировки
+		var or []string
+		for i := range order {
+			var and []string
+			for j := 0; j < i; j++ {
+				and = append(and, "l."+order[j]+" = ?")
+				args = append(args, values[j])
+			}
+			op := ">"
+			if dir(i) == "DESC" {
+				op = "<"
+			}
+			and = append(and, "l."+order[i]+" "+op+" ?")
+			args = append(args, values[i])
+			or = append(or, "("+strings.Join(and, " AND ")+")")
+		}
+		where = append(where, "("+strings.Join(or, " OR ")+")")
+	}
+
+	orderBy := make([]string, len(order))
+	orderCols := make([]string, len(order))
+	for i, col := range order {
+		orderBy[i] = "l." + col + " " + dir(i)
+		orderCols[i] = "l." + col
+	}
+	query := "SELECT " + cols + ", " + strings.Join(orderCols, ", ") + " FROM (" + inner + ") l WHERE " + strings.Join(where, " AND ") +
+		" ORDER BY " + strings.Join(orderBy, ", ")
+	if o.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, o.Limit+1) // лишняя строка — признак следующей страницы
+	}
+	return query, args, order, nil
+}
+
+// pageKeys значения колонок порядка одной строки (хвост выборки listQuery)
+type pageKeys []interface{}
+
+// dest указатели для Scan
+func (k pageKeys) dest() []interface{} {
+	out := make([]interface{}, len(k))
+	for i := range k {
+		out[i] = &k[i]
+	}
+	return out
+}
+
+// keep входит ли n-я по счёту строка выборки в страницу (последняя лишняя — нет)
+func (o ListOptions) keep(n int) bool {
+	return o.Limit <= 0 || n <= o.Limit
+}
+
+// nextCursor курсор следующей страницы: n строк получено, last — ключи последней оставленной.
+// Пусто, если страница последняя
+func (o ListOptions) nextCursor(n int, last pageKeys) string {
+	if o.Limit <= 0 || n <= o.Limit || last == nil {
+		return ""
+	}
+	values := make([]interface{}, len(last))
+	for i, v := range last {
+		if b, ok := v.([]byte); ok {
+			v = string(b)
+		}
+		values[i] = v
+	}
+	data, _ := json.Marshal(values)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor разбирает курсор из n значений: строк и чисел
+func decodeCursor(cursor string, n int) ([]interface{}, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, fmt.Errorf("%w: cursor", ErrInvalidList)
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var raw []interface{}
+	if err := dec.Decode(&raw); err != nil || len(raw) != n {
+		return nil, fmt.Errorf("%w: cursor (другая сортировка?)", ErrInvalidList)
+	}
+	for i, v := range raw {
+		switch x := v.(type) {
+		case string:
+		case json.Number:
+			if n, err := x.Int64(); err == nil {
+				raw[i] = n
+				continue
+			}
+			f, err := x.Float64()
+			if err != nil {
+				return nil, fmt.Errorf("%w: cursor", ErrInvalidList)
+			}
+			raw[i] = f
+		default:
+			return nil, fmt.Errorf("%w: cursor", ErrInvalidList)
+		}
+	}
+	return raw, nil
+}
+
+// globEscape экранирует метасимволы GLOB, чтобы искать строку как есть
+func globEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch r {
+		case '*', '?', '[':
+			b.WriteByte('[')
+			b.WriteRune(r)
+			b.WriteByte(']')
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,171 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// pages проходит список страницами по limit и склеивает их
+func pages[T any](t *testing.T, o ListOptions, limit int, get func(ListOptions) ([]T, string, error)) []T {
+	t.Helper()
+	var out []T
+	o.Limit = limit
+	for i := 0; ; i++ {
+		page, next, err := get(o)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page) > limit {
+			t.Fatalf("страница %d: %d строк при limit %d", i, len(page), limit)
+		}
+		out = append(out, page...)
+		if next == "" {
+			return out
+		}
+		if i > 100 {
+			t.Fatal("курсор не продвигается")
+		}
+		o.Cursor = next
+	}
+}
+
+// TestListCursorTies курсор проходит список без пропусков и повторов, когда у многих строк
+// одинаковое значение колонки сортировки
+func TestListCursorTies(t *testing.T) {
+	db := newTestDB(t)
+	type session struct {
+		cn, addr string
+		bytes    int64
+	}
+	// Трафик повторяется: у alice-0..2 по две сессии (150 на пользователя), user3..5 — 200, user6..8 — 300
+	var clients []session
+	for i := 0; i < 9; i++ {
+		name := fmt.Sprintf("user%d", i)
+		if i < 3 {
+			name = fmt.Sprintf("alice-%d", i)
+			clients = append(clients, session{name, fmt.Sprintf("10.0.1.%d:2000", i), 50})
+		}
+		clients = append(clients, session{name, fmt.Sprintf("10.0.0.%d:1000", i), int64(100 * (1 + i/3))})
+	}
+	s := status(base)
+	for _, c := range clients {
+		s.Clients = append(s.Clients, client(c.cn, c.addr, base.Add(-time.Hour), c.bytes, 0))
+	}
+	mustSave(t, db, "vpn1", s)
+	mustSave(t, db, "vpn2", status(base, client("user0", "10.0.0.100:1000", base.Add(-time.Hour), 100, 0)))
+
+	totalsList := func(o ListOptions) ([]UserTraffic, string, error) { return db.GetTotalTrafficAll(Filter{}, o) }
+	trafficList := func(o ListOptions) ([]UserTraffic, string, error) { return db.GetAllTraffic(Filter{}, o) }
+	connectedList := func(o ListOptions) ([]ConnectedClient, string, error) { return db.GetConnected(Filter{}, o) }
+	for _, sort := range []string{"", "total_bytes", "-total_bytes", "bytes_sent", "-common_name"} {
+		for _, limit := range []int{1, 2, 4} {
+			t.Run(fmt.Sprintf("%s/%d", sort, limit), func(t *testing.T) {
+				o := ListOptions{Sort: sort}
+				if all, _, err := totalsList(o); err != nil {
+					t.Fatal(err)
+				} else if got := pages(t, o, limit, totalsList); !reflect.DeepEqual(got, all) {
+					t.Errorf("/traffic/total страницами:\n%v\nцеликом:\n%v", got, all)
+				}
+				if all, _, err := trafficList(o); err != nil {
+					t.Fatal(err)
+				} else if got := pages(t, o, limit, trafficList); !reflect.DeepEqual(got, all) {
+					t.Errorf("/traffic страницами:\n%v\nцеликом:\n%v", got, all)
+				}
+			})
+		}
+	}
+	for _, sort := range []string{"", "bytes_received", "-bytes_received", "common_name", "instance"} {
+		t.Run("connected "+sort, func(t *testing.T) {
+			o := ListOptions{Sort: sort}
+			all, _, err := connectedList(o)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(all) != len(clients)+1 {
+				t.Fatalf("подключено %d, ожидалось %d", len(all), len(clients)+1)
+			}
+			if got := pages(t, o, 3, connectedList); !reflect.DeepEqual(got, all) {
+				t.Errorf("страницами %d строк, целиком %d", len(got), len(all))
+			}
+		})
+	}
+}
+
+func TestListFilters(t *testing.T) {
+	db := newTestDB(t)
+	since := base.Add(-time.Hour)
+	mustSave(t, db, "vpn1", status(base,
+		client("alice", "10.1.0.5:1000", since, 100, 0),
+		client("alex", "192.168.1.5:1000", since, 300, 0),
+		client("bob", "10.1.0.6:1000", since, 1000, 0),
+		client("a*b", "10.2.0.1:1000", since, 10, 0)))
+	if err := db.SetAlias("bob", "", "Бухгалтерия Офис"); err != nil {
+		t.Fatal(err)
+	}
+
+	names := func(o ListOptions) []string {
+		t.Helper()
+		list, _, err := db.GetTotalTrafficAll(Filter{}, o)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out []string
+		for _, u := range list {
+			out = append(out, u.CommonName)
+		}
+		return out
+	}
+	tests := []struct {
+		name string
+		o    ListOptions
+		want []string
+	}{
+		{"префикс", ListOptions{Name: "al", Sort: "common_name"}, []string{"alex", "alice"}},
+		{"glob", ListOptions{Name: "?l*e", Sort: "common_name"}, []string{"alice"}},
+		{"glob со звёздочкой", ListOptions{Name: "a*", Sort: "common_name"}, []string{"a*b", "alex", "alice"}},
+		{"glob из двух символов", ListOptions{Name: "a?"}, nil},
+		{"алиас без учёта регистра", ListOptions{Alias: "бухгалтерия"}, []string{"bob"}},
+		{"min_bytes", ListOptions{MinBytes: 300}, []string{"bob", "alex"}},
+		{"подсеть по сессиям", ListOptions{CIDR: "10.1.0.0/16", Sort: "common_name"}, []string{"alice", "bob"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := names(tt.o); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("%v, ожидалось %v", got, tt.want)
+			}
+		})
+	}
+
+	connected, _, err := db.GetConnected(Filter{}, ListOptions{CIDR: "192.168.0.0/16"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(connected) != 1 || connected[0].CommonName != "alex" {
+		t.Errorf("/connected в подсети: %+v", connected)
+	}
+}
+
+func TestListInvalid(t *testing.T) {
+	db := newTestDB(t)
+	mustSave(t, db, "", status(base, client("alice", "1.1.1.1:1000", base, 1, 1), client("bob", "1.1.1.2:1000", base, 1, 1)))
+	_, next, err := db.GetTotalTrafficAll(Filter{}, ListOptions{Limit: 1})
+	if err != nil || next == "" {
+		t.Fatalf("первая страница: %q, %v", next, err)
+	}
+	for _, o := range []ListOptions{
+		{Sort: "alias"},
+		{CIDR: "10.0.0.0/33"},
+		{Cursor: "не base64"},
+		{Cursor: next, Sort: "common_name"}, // курсор другой сортировки
+	} {
+		if _, _, err := db.GetTotalTrafficAll(Filter{}, o); !errors.Is(err, ErrInvalidList) {
+			t.Errorf("%+v: %v", o, err)
+		}
+	}
+	if _, _, err := db.GetUsers(Filter{}, ListOptions{MinBytes: 1}); !errors.Is(err, ErrInvalidList) {
+		t.Errorf("min_bytes у /users: %v", err)
+	}
+}
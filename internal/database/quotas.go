@@ -0,0 +1,320 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// Периоды квот (по UTC)
+const (
+	QuotaDaily   = "daily"
+	QuotaMonthly = "monthly"
+)
+
+// Действия при превышении квоты
+const (
+	QuotaActionLog        = "log"
+	QuotaActionWebhook    = "webhook"
+	QuotaActionDisconnect = "disconnect"
+)
+
+var (
+	// ErrQuotaNotFound квоты с таким id нет
+	ErrQuotaNotFound = errors.New("квота не найдена")
+	// ErrInvalidQuota квота не прошла проверку
+	ErrInvalidQuota = errors.New("недопустимая квота")
+	// ErrQuotaExists квота того же пользователя (группы) и периода уже есть
+	ErrQuotaExists = errors.New("квота для этого пользователя (группы) и периода уже есть")
+)
+
+// Quota лимит трафика (получено + отправлено, по всем инстансам) за день или месяц.
+// Задаётся для пользователя (CommonName) или группы (Group); квота группы действует
+// на каждого участника отдельно, а квота пользователя заменяет квоту группы того же периода
+type Quota struct {
+	ID         int64     `json:"id"`
+	CommonName string    `json:"common_name,omitempty"`
+	Group      string    `json:"group,omitempty"`
+	Period     string    `json:"period"`
+	LimitBytes int64     `json:"limit_bytes"`
+	Action     string    `json:"action"`
+	WebhookURL string    `json:"webhook_url,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// Validate проверяет квоту перед сохранением
+func (q *Quota) Validate() error {
+	if (q.CommonName == "") == (q.Group == "") {
+		return fmt.Errorf("%w: нужно указать common_name или group", ErrInvalidQuota)
+	}
+	if q.Period != QuotaDaily && q.Period != QuotaMonthly {
+		return fmt.Errorf("%w: period должен быть %s или %s", ErrInvalidQuota, QuotaDaily, QuotaMonthly)
+	}
+	if q.LimitBytes <= 0 {
+		return fmt.Errorf("%w: limit_bytes должен быть больше 0", ErrInvalidQuota)
+	}
+	switch q.Action {
+	case QuotaActionLog, QuotaActionDisconnect:
+	case QuotaActionWebhook:
+		u, err := url.Parse(q.WebhookURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("%w: для action webhook нужен http(s) webhook_url", ErrInvalidQuota)
+		}
+	default:
+		return fmt.Errorf("%w: action должен быть %s, %s или %s", ErrInvalidQuota, QuotaActionLog, QuotaActionWebhook, QuotaActionDisconnect)
+	}
+	return nil
+}
+
+// PeriodBounds начало текущего периода и момент сброса (начало следующего)
+func (q *Quota) PeriodBounds(now time.Time) (start, reset time.Time) {
+	now = now.UTC()
+	if q.Period == QuotaMonthly {
+		start = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+		return start, start.AddDate(0, 1, 0)
+	}
+	start = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	return start, start.AddDate(0, 0, 1)
+}
+
+// QuotaUsage расход квоты пользователем в текущем периоде
+type QuotaUsage struct {
+	Quota
+	CommonName     string     `json:"common_name"` // пользователь, для которого посчитан расход
+	PeriodStart    time.Time  `json:"period_start"`
+	ResetAt        time.Time  `json:"reset_at"`
+	UsedBytes      int64      `json:"used_bytes"`
+	RemainingBytes int64      `json:"remaining_bytes"`
+	Exceeded       bool       `json:"exceeded"`
+	ExceededAt     *time.Time `json:"exceeded_at,omitempty"`
+}
+
+const quotaColumns = `id, COALESCE(common_name, ''), COALESCE(group_name, ''), period, limit_bytes, action, webhook_url, created_at, updated_at`
+
+func scanQuota(row interface{ Scan(...interface{}) error }) (Quota, error) {
+	var q Quota
+	err := row.Scan(&q.ID, &q.CommonName, &q.Group, &q.Period, &q.LimitBytes, &q.Action, &q.WebhookURL, &q.CreatedAt, &q.UpdatedAt)
+	return q, err
+}
+
+func (db *DB) queryQuotas(where string, args ...interface{}) ([]Quota, error) {
+	rows, err := db.conn.Query("SELECT "+quotaColumns+" FROM quotas WHERE "+where+" ORDER BY id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]Quota, 0, 16)
+	for rows.Next() {
+		q, err := scanQuota(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, q)
+	}
+	return result, rows.Err()
+}
+
+// GetQuotas все квоты
+func (db *DB) GetQuotas() ([]Quota, error) {
+	return db.queryQuotas("1=1")
+}
+
+// GetQuota квота по id
+func (db *DB) GetQuota(id int64) (*Quota, error) {
+	q, err := scanQuota(db.conn.QueryRow("SELECT "+quotaColumns+" FROM quotas WHERE id = ?", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrQuotaNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &q, nil
+}
+
+// CreateQuota сохраняет новую квоту. Квота того же пользователя (группы) и периода — ошибка
+func (db *DB) CreateQuota(q *Quota) error {
+	if err := q.Validate(); err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	res, err := db.conn.Exec(`
+		INSERT INTO quotas (common_name, group_name, period, limit_bytes, action, webhook_url, created_at, updated_at)
+		VALUES (NULLIF(?, ''), NULLIF(?, ''), ?, ?, ?, ?, ?, ?)`,
+		q.CommonName, q.Group, q.Period, q.LimitBytes, q.Action, q.WebhookURL, now, now)
+	if err != nil {
+		return quotaConflict(err)
+	}
+	q.ID, _ = res.LastInsertId()
+	q.CreatedAt, q.UpdatedAt = now, now
+	return nil
+}
+
+// UpdateQuota заменяет квоту q.ID. Состояние превышений сбрасывается: новый лимит проверяется заново
+func (db *DB) UpdateQuota(q *Quota) error {
+	if err := q.Validate(); err != nil {
+		return err
+	}
+	old, err := db.GetQuota(q.ID)
+	if err != nil {
+		return err
+	}
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	q.CreatedAt, q.UpdatedAt = old.CreatedAt, time.Now().UTC()
+	if _, err := tx.Exec(`
+		UPDATE quotas SET common_name=NULLIF(?, ''), group_name=NULLIF(?, ''), period=?, limit_bytes=?, action=?, webhook_url=?, updated_at=?
+		WHERE id = ?`,
+		q.CommonName, q.Group, q.Period, q.LimitBytes, q.Action, q.WebhookURL, q.UpdatedAt, q.ID); err != nil {
+		return quotaConflict(err)
+	}
+	if _, err := tx.Exec("DELETE FROM quota_breaches WHERE quota_id = ?", q.ID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeleteQuota удаляет квоту и её превышения
+func (db *DB) DeleteQuota(id int64) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM quota_breaches WHERE quota_id = ?", id); err != nil {
+		return err
+	}
+	res, err := tx.Exec("DELETE FROM quotas WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrQuotaNotFound
+	}
+	return tx.Commit()
+}
+
+func isUniqueViolation(err error) bool {
+	var se sqlite3.Error
+	return errors.As(err, &se) && se.ExtendedCode == sqlite3.ErrConstraintUnique
+}
+
+func quotaConflict(err error) error {
+	if isUniqueViolation(err) {
+		return ErrQuotaExists
+	}
+	return err
+}
+
+// GetQuotaGroups участники групп квот: группа → common_name
+func (db *DB) GetQuotaGroups() (map[string][]string, error) {
+	rows, err := db.conn.Query("SELECT group_name, common_name FROM quota_group_members ORDER BY group_name, common_name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	groups := make(map[string][]string)
+	for rows.Next() {
+		var g, cn string
+		if err := rows.Scan(&g, &cn); err != nil {
+			return nil, err
+		}
+		groups[g] = append(groups[g], cn)
+	}
+	return groups, rows.Err()
+}
+
+// SetQuotaGroup заменяет состав группы; пустой members удаляет группу
+func (db *DB) SetQuotaGroup(group string, members []string) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM quota_group_members WHERE group_name = ?", group); err != nil {
+		return err
+	}
+	for _, cn := range members {
+		if _, err := tx.Exec("INSERT OR IGNORE INTO quota_group_members (group_name, common_name) VALUES (?, ?)", group, cn); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// QuotasForUser квоты, действующие на пользователя: свои и его групп (по одной на период,
+// своя важнее групповой, среди групп — меньший лимит)
+func (db *DB) QuotasForUser(commonName string) ([]Quota, error) {
+	quotas, err := db.queryQuotas(`common_name = ? OR group_name IN (SELECT group_name FROM quota_group_members WHERE common_name = ?)`,
+		commonName, commonName)
+	if err != nil {
+		return nil, err
+	}
+	byPeriod := make(map[string]int)
+	result := make([]Quota, 0, len(quotas))
+	for _, q := range quotas {
+		i, ok := byPeriod[q.Period]
+		if !ok {
+			byPeriod[q.Period] = len(result)
+			result = append(result, q)
+			continue
+		}
+		cur := result[i]
+		switch {
+		case cur.CommonName != "":
+		case q.CommonName != "", q.LimitBytes < cur.LimitBytes:
+			result[i] = q
+		}
+	}
+	return result, nil
+}
+
+// GetQuotaUsage расход квоты q пользователем commonName на момент now
+func (db *DB) GetQuotaUsage(q Quota, commonName string, now time.Time) (*QuotaUsage, error) {
+	start, reset := q.PeriodBounds(now)
+	u := &QuotaUsage{Quota: q, CommonName: commonName, PeriodStart: start, ResetAt: reset}
+	if err := db.conn.QueryRow(`
+		SELECT COALESCE(SUM(d.bytes_received + d.bytes_sent), 0)
+		FROM user_daily_traffic d
+		JOIN users u ON u.id = d.user_id
+		WHERE u.common_name = ? AND d.day >= ? AND d.day < ?`,
+		commonName, start.Format("2006-01-02"), reset.Format("2006-01-02")).Scan(&u.UsedBytes); err != nil {
+		return nil, err
+	}
+	u.RemainingBytes = q.LimitBytes - u.UsedBytes
+	if u.RemainingBytes < 0 {
+		u.RemainingBytes = 0
+	}
+	u.Exceeded = u.UsedBytes >= q.LimitBytes
+	var at sql.NullTime
+	err := db.conn.QueryRow("SELECT exceeded_at FROM quota_breaches WHERE quota_id = ? AND common_name = ? AND period_start = ?",
+		q.ID, commonName, start).Scan(&at)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	if at.Valid {
+		u.ExceededAt = &at.Time
+	}
+	return u, nil
+}
+
+// MarkQuotaExceeded отмечает превышение квоты в периоде. first=true — впервые в этом периоде
+func (db *DB) MarkQuotaExceeded(u *QuotaUsage, at time.Time) (first bool, err error) {
+	res, err := db.conn.Exec(`
+		INSERT OR IGNORE INTO quota_breaches (quota_id, common_name, period_start, exceeded_at, used_bytes)
+		VALUES (?, ?, ?, ?, ?)`, u.ID, u.CommonName, u.PeriodStart, at.UTC(), u.UsedBytes)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
@@ -0,0 +1,432 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"sort"
+	"time"
+)
+
+// ErrRebuildRunning пересчёт уже выполняется
+var ErrRebuildRunning = errors.New("пересчёт уже выполняется")
+
+// RebuildOptions параметры пересчёта агрегатов
+type RebuildOptions struct {
+	Instance string // пусто — все инстансы
+	DryRun   bool   // посчитать и откатить
+	// Progress вызывается по мере воспроизведения снимков инстанса: каждые 10% и в конце
+	Progress func(instance string, done, total int)
+}
+
+// RebuildInstance что пересчитано у инстанса
+type RebuildInstance struct {
+	Instance      string    `json:"instance"`
+	From          time.Time `json:"from"` // первый сохранённый снимок: от него отсчитываются приращения
+	To            time.Time `json:"to"`
+	Snapshots     int       `json:"snapshots"`
+	DeltasRemoved int64     `json:"deltas_removed"`
+	EndedSessions int       `json:"ended_sessions"` // сессии с трафиком после последнего снимка (management)
+}
+
+// RebuildBytes байты до и после пересчёта
+type RebuildBytes struct {
+	BytesReceivedBefore int64 `json:"bytes_received_before"`
+	BytesSentBefore     int64 `json:"bytes_sent_before"`
+	BytesReceivedAfter  int64 `json:"bytes_received_after"`
+	BytesSentAfter      int64 `json:"bytes_sent_after"`
+	DiffReceived        int64 `json:"diff_received"`
+	DiffSent            int64 `json:"diff_sent"`
+}
+
+// RebuildUserDiff изменение накопленного трафика пользователя
+type RebuildUserDiff struct {
+	Instance   string `json:"instance"`
+	CommonName string `json:"common_name"`
+	RebuildBytes
+}
+
+// RebuildDayDiff изменение трафика инстанса за день
+type RebuildDayDiff struct {
+	Instance string `json:"instance"`
+	Day      string `json:"day"`
+	RebuildBytes
+}
+
+// RebuildReport итог пересчёта: только изменившиеся пользователи и дни
+type RebuildReport struct {
+	DryRun    bool              `json:"dry_run"`
+	Instances []RebuildInstance `json:"instances"`
+	Users     []RebuildUserDiff `json:"users"`
+	Days      []RebuildDayDiff  `json:"days"`
+}
+
+type rebuildKey struct {
+	instance, name string
+}
+
+// Rebuild пересчитывает накопленный, дневной и почасовой трафик по сохранённым снимкам
+// (traffic_snapshots). Снимки старше RETENTION_RAW уже удалены, поэтому пересчитывается окно
+// после первого сохранённого снимка инстанса: приращения окна вычитаются из агрегатов,
+// счётчики сессий берутся из первого снимка, остальные снимки воспроизводятся по порядку.
+// Трафик между последним снимком сессии и её отключением (management) восстанавливается по
+// таблице sessions.
+//
+// Очистить агрегаты и воспроизвести все снимки нельзя: трафик до окна пропал бы вместе с
+// удалёнными снимками. Агрегаты — суммы приращений, поэтому вычесть приращения окна и
+// добавить пересчитанные — то же, что собрать их заново, а трафик до окна остаётся как был.
+// session_last_bytes после первого снимка — его счётчики, как и при живом сборе, поэтому
+// следующие снимки считаются тем же updateTrafficTotals. Приращения самого первого снимка
+// не пересчитываются: они зависят от предыдущего, которого уже нет.
+//
+// Всё выполняется в одной транзакции; DryRun откатывает её. Соединение с БД одно, поэтому
+// сбор и запросы API ждут окончания пересчёта. Второй пересчёт, пока идёт первый, сразу
+// возвращает ErrRebuildRunning
+func (db *DB) Rebuild(opts RebuildOptions) (*RebuildReport, error) {
+	if !db.rebuildMu.TryLock() {
+		return nil, ErrRebuildRunning
+	}
+	defer db.rebuildMu.Unlock()
+
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var instances []string
+	if opts.Instance != "" {
+		instances = []string{opts.Instance}
+	} else if instances, err = queryStrings(tx, "SELECT DISTINCT instance FROM traffic_snapshots ORDER BY instance"); err != nil {
+		return nil, err
+	}
+
+	usersBefore, err := rebuildTotals(tx, `SELECT t.instance, u.common_name, t.bytes_received, t.bytes_sent FROM user_traffic_totals t JOIN users u ON u.id = t.user_id`)
+	if err != nil {
+		return nil, err
+	}
+	daysBefore, err := rebuildTotals(tx, `SELECT instance, CAST(day AS TEXT), bytes_received, bytes_sent FROM daily_traffic_totals`)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &RebuildReport{DryRun: opts.DryRun, Instances: []RebuildInstance{}, Users: []RebuildUserDiff{}, Days: []RebuildDayDiff{}}
+	for _, instance := range instances {
+		ri, err := db.rebuildInstance(tx, instance, opts.Progress)
+		if err != nil {
+			return nil, err
+		}
+		if ri != nil {
+			report.Instances = append(report.Instances, *ri)
+		}
+	}
+
+	usersAfter, err := rebuildTotals(tx, `SELECT t.instance, u.common_name, t.bytes_received, t.bytes_sent FROM user_traffic_totals t JOIN users u ON u.id = t.user_id`)
+	if err != nil {
+		return nil, err
+	}
+	daysAfter, err := rebuildTotals(tx, `SELECT instance, CAST(day AS TEXT), bytes_received, bytes_sent FROM daily_traffic_totals`)
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range rebuildChanged(usersBefore, usersAfter) {
+		report.Users = append(report.Users, RebuildUserDiff{Instance: k.instance, CommonName: k.name, RebuildBytes: rebuildBytes(usersBefore[k], usersAfter[k])})
+	}
+	for _, k := range rebuildChanged(daysBefore, daysAfter) {
+		report.Days = append(report.Days, RebuildDayDiff{Instance: k.instance, Day: k.name, RebuildBytes: rebuildBytes(daysBefore[k], daysAfter[k])})
+	}
+
+	if opts.DryRun {
+		return report, nil
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// rebuildInstance пересчитывает один инстанс; nil — у инстанса нет сохранённых снимков
+func (db *DB) rebuildInstance(tx *sql.Tx, instance string, progress func(string, int, int)) (*RebuildInstance, error) {
+	rows, err := tx.Query("SELECT DISTINCT snapshot_at FROM traffic_snapshots WHERE instance = ? ORDER BY snapshot_at", instance)
+	if err != nil {
+		return nil, err
+	}
+	var times []time.Time
+	for rows.Next() {
+		var t time.Time
+		if err := rows.Scan(&t); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		times = append(times, t)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(times) == 0 {
+		return nil, nil
+	}
+	from := times[0]
+	ri := &RebuildInstance{Instance: instance, From: from, To: times[len(times)-1], Snapshots: len(times)}
+
+	if ri.DeltasRemoved, err = removeDeltasAfter(tx, instance, from); err != nil {
+		return nil, err
+	}
+
+	// Счётчики первого снимка — точка отсчёта: его собственные приращения остаются как были
+	first, err := snapshotSessions(tx, instance, from)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec("DELETE FROM session_last_bytes WHERE instance = ?", instance); err != nil {
+		return nil, err
+	}
+	for k, v := range first {
+		if _, err := tx.Exec("INSERT INTO session_last_bytes (instance, user_id, real_address, bytes_received, bytes_sent, connected_since, seen_at) VALUES (?, ?, ?, ?, ?, ?, ?)", instance, k.uid, k.addr, v.r, v.s, v.cs, from); err != nil {
+			return nil, err
+		}
+	}
+	report := func(done int) {
+		if progress != nil && (done == len(times) || done*10/len(times) != (done-1)*10/len(times)) {
+			progress(instance, done, len(times))
+		}
+	}
+	report(1)
+	for i, at := range times[1:] {
+		cur, err := snapshotSessions(tx, instance, at)
+		if err != nil {
+			return nil, err
+		}
+		prev, err := loadLastBytes(tx, instance, "session_last_bytes")
+		if err != nil {
+			return nil, err
+		}
+		if err := db.updateTrafficTotals(tx, instance, prev, cur, at, "session_last_bytes"); err != nil {
+			return nil, err
+		}
+		report(i + 2)
+	}
+
+	if ri.EndedSessions, err = addSessionTails(tx, instance, from); err != nil {
+		return nil, err
+	}
+	return ri, nil
+}
+
+// removeDeltasAfter вычитает из агрегатов приращения инстанса позже from и удаляет их.
+// Строки агрегатов, уже удалённые или свёрнутые очисткой, не создаются заново
+func removeDeltasAfter(tx *sql.Tx, instance string, from time.Time) (int64, error) {
+	type bucket struct {
+		uid int64
+		key string
+	}
+	users := make(map[int64]sessionBytes)
+	days := make(map[string]sessionBytes)
+	hours := make(map[time.Time]sessionBytes)
+	userDays := make(map[bucket]sessionBytes)
+	userHours := make(map[bucket]time.Time)
+	userHourBytes := make(map[bucket]sessionBytes)
+
+	rows, err := tx.Query("SELECT user_id, delta_at, bytes_received, bytes_sent FROM traffic_deltas WHERE instance = ? AND delta_at > ?", instance, from)
+	if err != nil {
+		return 0, err
+	}
+	var n int64
+	add := func(b sessionBytes, r, s int64) sessionBytes {
+		b.r += r
+		b.s += s
+		return b
+	}
+	for rows.Next() {
+		var uid, r, s int64
+		var at time.Time
+		if err := rows.Scan(&uid, &at, &r, &s); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		n++
+		day := at.UTC().Format("2006-01-02")
+		hour := at.UTC().Truncate(time.Hour)
+		users[uid] = add(users[uid], r, s)
+		days[day] = add(days[day], r, s)
+		hours[hour] = add(hours[hour], r, s)
+		userDays[bucket{uid, day}] = add(userDays[bucket{uid, day}], r, s)
+		hk := bucket{uid, hour.Format(time.RFC3339)}
+		userHours[hk] = hour
+		userHourBytes[hk] = add(userHourBytes[hk], r, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	sub := func(query string, b sessionBytes, args ...interface{}) error {
+		_, err := tx.Exec(query, append([]interface{}{b.r, b.s}, args...)...)
+		return err
+	}
+	for uid, b := range users {
+		if err := sub("UPDATE user_traffic_totals SET bytes_received=bytes_received-?, bytes_sent=bytes_sent-? WHERE instance = ? AND user_id = ?", b, instance, uid); err != nil {
+			return 0, err
+		}
+	}
+	for day, b := range days {
+		if err := sub("UPDATE daily_traffic_totals SET bytes_received=bytes_received-?, bytes_sent=bytes_sent-? WHERE instance = ? AND day = ?", b, instance, day); err != nil {
+			return 0, err
+		}
+	}
+	for hour, b := range hours {
+		if err := sub("UPDATE hourly_traffic_totals SET bytes_received=bytes_received-?, bytes_sent=bytes_sent-? WHERE instance = ? AND hour = ?", b, instance, hour); err != nil {
+			return 0, err
+		}
+	}
+	for k, b := range userDays {
+		if err := sub("UPDATE user_daily_traffic SET bytes_received=bytes_received-?, bytes_sent=bytes_sent-? WHERE instance = ? AND user_id = ? AND day = ?", b, instance, k.uid, k.key); err != nil {
+			return 0, err
+		}
+	}
+	for k, b := range userHourBytes {
+		if err := sub("UPDATE user_hourly_traffic SET bytes_received=bytes_received-?, bytes_sent=bytes_sent-? WHERE instance = ? AND user_id = ? AND hour = ?", b, instance, k.uid, userHours[k]); err != nil {
+			return 0, err
+		}
+	}
+	if _, err := tx.Exec("DELETE FROM traffic_deltas WHERE instance = ? AND delta_at > ?", instance, from); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// snapshotSessions счётчики сессий инстанса в снимке at
+func snapshotSessions(tx *sql.Tx, instance string, at time.Time) (map[sessionKey]sessionBytes, error) {
+	rows, err := tx.Query("SELECT user_id, COALESCE(real_address, ''), bytes_received, bytes_sent, connected_since FROM traffic_snapshots WHERE instance = ? AND snapshot_at = ?", instance, at)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	cur := make(map[sessionKey]sessionBytes)
+	for rows.Next() {
+		var k sessionKey
+		var v sessionBytes
+		var cs sql.NullTime
+		if err := rows.Scan(&k.uid, &k.addr, &v.r, &v.s, &cs); err != nil {
+			return nil, err
+		}
+		if cs.Valid {
+			v.cs = cs.Time
+		}
+		cur[k] = v
+	}
+	return cur, rows.Err()
+}
+
+// addSessionTails добавляет трафик сессий, завершённых после from, которого нет в снимках:
+// итог из >CLIENT:DISCONNECT больше счётчиков последнего снимка (см. EndSession)
+func addSessionTails(tx *sql.Tx, instance string, from time.Time) (int, error) {
+	rows, err := tx.Query(`
+		SELECT s.user_id, s.ended_at, s.first_seen_at, s.bytes_received, s.bytes_sent,
+			(SELECT t.bytes_received FROM traffic_snapshots t WHERE t.instance = s.instance AND t.user_id = s.user_id AND t.real_address = s.real_address AND t.connected_since = s.connected_since ORDER BY t.snapshot_at DESC LIMIT 1),
+			(SELECT t.bytes_sent FROM traffic_snapshots t WHERE t.instance = s.instance AND t.user_id = s.user_id AND t.real_address = s.real_address AND t.connected_since = s.connected_since ORDER BY t.snapshot_at DESC LIMIT 1)
+		FROM sessions s WHERE s.instance = ? AND s.ended_at > ?`, instance, from)
+	if err != nil {
+		return 0, err
+	}
+	type tail struct {
+		uid  int64
+		at   time.Time
+		r, s int64
+	}
+	var tails []tail
+	for rows.Next() {
+		var uid, r, s int64
+		var ended, firstSeen time.Time
+		var seenR, seenS sql.NullInt64
+		if err := rows.Scan(&uid, &ended, &firstSeen, &r, &s, &seenR, &seenS); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if !seenR.Valid && !firstSeen.After(from) {
+			continue // сессия из снимков, удалённых очисткой: её трафик остался до окна
+		}
+		if dr, ds := r-seenR.Int64, s-seenS.Int64; dr >= 0 && ds >= 0 && (dr > 0 || ds > 0) {
+			tails = append(tails, tail{uid, ended, dr, ds})
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	for _, t := range tails {
+		if err := addTraffic(tx, instance, t.uid, t.r, t.s, t.at); err != nil {
+			return 0, err
+		}
+	}
+	return len(tails), nil
+}
+
+func queryStrings(tx *sql.Tx, query string, args ...interface{}) ([]string, error) {
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []string
+	for rows.Next() {
+		var s string
+		if err := rows.Scan(&s); err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
+
+// rebuildTotals читает (instance, имя, received, sent) в карту
+func rebuildTotals(tx *sql.Tx, query string) (map[rebuildKey][2]int64, error) {
+	rows, err := tx.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := make(map[rebuildKey][2]int64)
+	for rows.Next() {
+		var k rebuildKey
+		var r, s int64
+		if err := rows.Scan(&k.instance, &k.name, &r, &s); err != nil {
+			return nil, err
+		}
+		out[k] = [2]int64{r, s}
+	}
+	return out, rows.Err()
+}
+
+// rebuildChanged ключи, значения которых изменились, по порядку
+func rebuildChanged(before, after map[rebuildKey][2]int64) []rebuildKey {
+	var keys []rebuildKey
+	for k, v := range after {
+		if before[k] != v {
+			keys = append(keys, k)
+		}
+	}
+	for k := range before {
+		if _, ok := after[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].instance != keys[j].instance {
+			return keys[i].instance < keys[j].instance
+		}
+		return keys[i].name < keys[j].name
+	})
+	return keys
+}
+
+func rebuildBytes(before, after [2]int64) RebuildBytes {
+	return RebuildBytes{
+		BytesReceivedBefore: before[0],
+		BytesSentBefore:     before[1],
+		BytesReceivedAfter:  after[0],
+		BytesSentAfter:      after[1],
+		DiffReceived:        after[0] - before[0],
+		DiffSent:            after[1] - before[1],
+	}
+}
@@ -0,0 +1,189 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// aggregates все агрегаты трафика: таблица/ключ -> {received, sent}
+func aggregates(t *testing.T, db *DB) map[string][2]int64 {
+	t.Helper()
+	out := make(map[string][2]int64)
+	for _, q := range []struct{ table, key string }{
+		{"user_traffic_totals", "instance || '/' || user_id"},
+		{"daily_traffic_totals", "instance || '/' || day"},
+		{"hourly_traffic_totals", "instance || '/' || hour"},
+		{"user_daily_traffic", "instance || '/' || user_id || '/' || day"},
+		{"user_hourly_traffic", "instance || '/' || user_id || '/' || hour"},
+	} {
+		rows, err := db.conn.Query(fmt.Sprintf("SELECT %s, bytes_received, bytes_sent FROM %s", q.key, q.table))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for rows.Next() {
+			var k string
+			var r, s int64
+			if err := rows.Scan(&k, &r, &s); err != nil {
+				t.Fatal(err)
+			}
+			// Нулевые строки после вычитания равны отсутствующим
+			if r != 0 || s != 0 {
+				out[q.table+" "+k] = [2]int64{r, s}
+			}
+		}
+		rows.Close()
+	}
+	return out
+}
+
+func sameAggregates(t *testing.T, got, want map[string][2]int64) {
+	t.Helper()
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%s: %v, ожидалось %v", k, got[k], v)
+		}
+	}
+	for k, v := range got {
+		if _, ok := want[k]; !ok {
+			t.Errorf("%s: лишняя строка %v", k, v)
+		}
+	}
+}
+
+// liveHistory живой сбор двух инстансов: переход через час, переподключение и отключение
+// через management-интерфейс с трафиком после последнего снимка
+func liveHistory(t *testing.T, db *DB) {
+	t.Helper()
+	aliceSince := base.Add(-time.Hour)
+	bobSince := base.Add(20 * time.Minute)
+	mustSave(t, db, "vpn1", status(base, client("alice", "1.1.1.1:1000", aliceSince, 100, 10)))
+	mustSave(t, db, "vpn1", status(base.Add(30*time.Minute),
+		client("alice", "1.1.1.1:1000", aliceSince, 400, 40),
+		client("bob", "2.2.2.2:2000", bobSince, 50, 5)))
+	mustSave(t, db, "vpn1", status(base.Add(70*time.Minute),
+		client("alice", "1.1.1.1:1000", aliceSince, 900, 90),
+		client("bob", "2.2.2.2:2000", bobSince, 80, 8)))
+	if err := db.EndSession("vpn1", SessionEnd{CommonName: "bob", RealAddress: "2.2.2.2:2000", ConnectedSince: bobSince,
+		EndedAt: base.Add(75 * time.Minute), BytesReceived: 120, BytesSent: 12}); err != nil {
+		t.Fatal(err)
+	}
+	mustSave(t, db, "vpn1", status(base.Add(80*time.Minute),
+		client("alice", "1.1.1.1:1000", base.Add(78*time.Minute), 30, 3)))
+	mustSave(t, db, "vpn2", status(base, client("carol", "3.3.3.3:3000", aliceSince, 1000, 100)))
+	mustSave(t, db, "vpn2", status(base.Add(90*time.Minute), client("carol", "3.3.3.3:3000", aliceSince, 5000, 500)))
+}
+
+// TestRebuildMatchesLive пересчёт по снимкам даёт те же агрегаты, что и живой сбор, и убирает
+// приращения, которых в снимках нет
+func TestRebuildMatchesLive(t *testing.T) {
+	db := newTestDB(t)
+	liveHistory(t, db)
+	live := aggregates(t, db)
+	if got := totals(t, db); got["alice"] != [2]int64{930, 93} || got["bob"] != [2]int64{120, 12} || got["carol"] != [2]int64{5000, 500} {
+		t.Fatalf("живой сбор: %v", got)
+	}
+
+	report, err := db.Rebuild(RebuildOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Instances) != 2 || len(report.Users) != 0 || len(report.Days) != 0 {
+		t.Errorf("пересчёт без расхождений: %+v", report)
+	}
+	if ri := report.Instances[0]; ri.Instance != "vpn1" || ri.Snapshots != 4 || ri.EndedSessions != 1 {
+		t.Errorf("vpn1: %+v", ri)
+	}
+	sameAggregates(t, aggregates(t, db), live)
+
+	// Ошибочное приращение внутри окна (как от прежнего расчёта) пересчёт убирает
+	tx, err := db.conn.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	uid, err := db.ensureUser(tx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := addTraffic(tx, "vpn1", uid, 1000, 100, base.Add(40*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	broken := aggregates(t, db)
+
+	report, err = db.Rebuild(RebuildOptions{DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Users) != 1 || report.Users[0].CommonName != "alice" || report.Users[0].DiffReceived != -1000 || report.Users[0].DiffSent != -100 {
+		t.Errorf("пробный пересчёт: %+v", report.Users)
+	}
+	sameAggregates(t, aggregates(t, db), broken)
+
+	// Пересчёт другого инстанса vpn1 не трогает
+	if _, err := db.Rebuild(RebuildOptions{Instance: "vpn2"}); err != nil {
+		t.Fatal(err)
+	}
+	sameAggregates(t, aggregates(t, db), broken)
+
+	if _, err := db.Rebuild(RebuildOptions{Instance: "vpn1"}); err != nil {
+		t.Fatal(err)
+	}
+	sameAggregates(t, aggregates(t, db), live)
+
+	// Живой сбор продолжает со счётчиков, оставленных пересчётом
+	mustSave(t, db, "vpn1", status(base.Add(90*time.Minute), client("alice", "1.1.1.1:1000", base.Add(78*time.Minute), 50, 5)))
+	if got := totals(t, db)["alice"]; got != [2]int64{950, 95} {
+		t.Errorf("после пересчёта и нового снимка alice %v, ожидалось [950 95]", got)
+	}
+}
+
+func TestRebuildRunning(t *testing.T) {
+	db := newTestDB(t)
+	db.rebuildMu.Lock()
+	if _, err := db.Rebuild(RebuildOptions{}); !errors.Is(err, ErrRebuildRunning) {
+		t.Errorf("второй пересчёт: %v", err)
+	}
+	db.rebuildMu.Unlock()
+	if _, err := db.Rebuild(RebuildOptions{}); err != nil {
+		t.Errorf("пересчёт после первого: %v", err)
+	}
+}
+
+// TestRebuildAfterRetention пересчёт после свёртки почасовых данных не учитывает свёрнутые
+// периоды второй раз; политика, при которой снимки таких периодов остались бы, отклоняется
+func TestRebuildAfterRetention(t *testing.T) {
+	db := newTestDB(t)
+	if err := db.SetRetention(RetentionPolicy{Hourly: 7 * 24 * time.Hour}); err == nil {
+		t.Error("политика с бессрочными снимками и ограниченными почасовыми данными принята")
+	}
+	if err := db.SetRetention(RetentionPolicy{Raw: 48 * time.Hour, Hourly: 7 * 24 * time.Hour}); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().UTC()
+	old := now.Add(-10 * 24 * time.Hour)
+	since := old.Add(-time.Minute)
+	mustSave(t, db, "vpn1", status(old, client("alice", "1.1.1.1:1000", since, 100, 10)))
+	mustSave(t, db, "vpn1", status(now.Add(-time.Hour), client("alice", "1.1.1.1:1000", since, 200, 20)))
+	mustSave(t, db, "vpn1", status(now, client("alice", "1.1.1.1:1000", since, 300, 30)))
+	if _, err := db.ApplyRetention(now); err != nil {
+		t.Fatal(err)
+	}
+	before := aggregates(t, db)
+
+	if _, err := db.Rebuild(RebuildOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	sameAggregates(t, aggregates(t, db), before)
+	q, args := db.rangeSource(Filter{})
+	var r int64
+	if err := db.conn.QueryRow("SELECT SUM(bytes_received) FROM ("+q+")", args...).Scan(&r); err != nil {
+		t.Fatal(err)
+	}
+	if r != 300 {
+		t.Errorf("по уровням после пересчёта %d, ожидалось 300", r)
+	}
+}
@@ -0,0 +1,213 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// RetentionPolicy сроки хранения по уровням детализации. 0 — хранить всегда.
+// raw — снимки, маршруты и приращения; hourly — почасовые агрегаты
+// (перед удалением сворачиваются в дневные); daily — дневные агрегаты.
+type RetentionPolicy struct {
+	Raw    time.Duration
+	Hourly time.Duration
+	Daily  time.Duration
+}
+
+// Validate проверяет, что более грубый уровень хранится не меньше более детального. Сырые данные
+// без срока при ограниченных почасовых недопустимы: пересчёт по снимкам записал бы почасовые
+// строки за уже свёрнутые в дневные периоды, и трафик учёлся бы дважды
+func (p RetentionPolicy) Validate() error {
+	if p.Raw < 0 || p.Hourly < 0 || p.Daily < 0 {
+		return fmt.Errorf("срок хранения не может быть отрицательным")
+	}
+	if p.Hourly > 0 && (p.Raw == 0 || p.Hourly < p.Raw) {
+		return fmt.Errorf("почасовые данные должны храниться не меньше сырых")
+	}
+	if p.Hourly > 0 && p.Daily > 0 && p.Daily < p.Hourly {
+		return fmt.Errorf("дневные данные должны храниться не меньше почасовых")
+	}
+	return nil
+}
+
+// rawCutoff граница сырых данных, выровненная на час (zero — хранить всегда)
+func (p RetentionPolicy) rawCutoff(now time.Time) time.Time {
+	if p.Raw <= 0 {
+		return time.Time{}
+	}
+	return now.UTC().Add(-p.Raw).Truncate(time.Hour)
+}
+
+// hourlyCutoff граница почасовых данных, выровненная на начало суток
+func (p RetentionPolicy) hourlyCutoff(now time.Time) time.Time {
+	if p.Hourly <= 0 {
+		return time.Time{}
+	}
+	return now.UTC().Add(-p.Hourly).Truncate(24 * time.Hour)
+}
+
+// dailyCutoff граница дневных данных, выровненная на начало суток
+func (p RetentionPolicy) dailyCutoff(now time.Time) time.Time {
+	if p.Daily <= 0 {
+		return time.Time{}
+	}
+	return now.UTC().Add(-p.Daily).Truncate(24 * time.Hour)
+}
+
+// SetRetention задаёт политику хранения (используется и при чтении интервалов)
+func (db *DB) SetRetention(p RetentionPolicy) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	db.retentionMu.Lock()
+	db.retention = p
+	db.retentionMu.Unlock()
+	return nil
+}
+
+// Retention текущая политика хранения
+func (db *DB) Retention() RetentionPolicy {
+	db.retentionMu.RLock()
+	defer db.retentionMu.RUnlock()
+	return db.retention
+}
+
+// RetentionTable состояние одной таблицы относительно политики хранения
+type RetentionTable struct {
+	Tier        string     `json:"tier"`
+	Table       string     `json:"table"`
+	Cutoff      *time.Time `json:"cutoff"` // nil — хранится всегда
+	Rows        int64      `json:"rows"`
+	RowsToPurge int64      `json:"rows_to_purge"`
+	Oldest      *time.Time `json:"oldest"`
+}
+
+var retentionTables = []struct{ tier, table, column string }{
+	{"raw", "traffic_snapshots", "snapshot_at"},
+	{"raw", "route_snapshots", "snapshot_at"},
+	{"raw", "traffic_deltas", "delta_at"},
+	{"hourly", "hourly_traffic_totals", "hour"},
+	{"hourly", "user_hourly_traffic", "hour"},
+	{"daily", "daily_traffic_totals", "day"},
+	{"daily", "user_daily_traffic", "day"},
+}
+
+func (p RetentionPolicy) cutoff(tier string, now time.Time) time.Time {
+	switch tier {
+	case "raw":
+		return p.rawCutoff(now)
+	case "hourly":
+		return p.hourlyCutoff(now)
+	default:
+		return p.dailyCutoff(now)
+	}
+}
+
+// cutoffArg значение границы для сравнения с колонкой: дни хранятся строкой YYYY-MM-DD
+func cutoffArg(tier string, cutoff time.Time) interface{} {
+	if tier == "daily" {
+		return cutoff.Format("2006-01-02")
+	}
+	return cutoff
+}
+
+// RetentionPreview показывает, сколько строк удалит следующий запуск ApplyRetention
+func (db *DB) RetentionPreview(now time.Time) ([]RetentionTable, error) {
+	p := db.Retention()
+	result := make([]RetentionTable, 0, len(retentionTables))
+	for _, t := range retentionTables {
+		rt := RetentionTable{Tier: t.tier, Table: t.table}
+		var oldest sql.NullString
+		if err := db.conn.QueryRow(fmt.Sprintf("SELECT COUNT(*), MIN(%s) FROM %s", t.column, t.table)).Scan(&rt.Rows, &oldest); err != nil {
+			return nil, err
+		}
+		if oldest.Valid {
+			if ts, ok := parseDBTime(oldest.String); ok {
+				rt.Oldest = &ts
+			}
+		}
+		if cutoff := p.cutoff(t.tier, now); !cutoff.IsZero() {
+			rt.Cutoff = &cutoff
+			q := fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE %s < ?", t.table, t.column)
+			if err := db.conn.QueryRow(q, cutoffArg(t.tier, cutoff)).Scan(&rt.RowsToPurge); err != nil {
+				return nil, err
+			}
+		}
+		result = append(result, rt)
+	}
+	return result, nil
+}
+
+// ApplyRetention удаляет данные старше сроков политики. Почасовые строки перед удалением
+// сворачиваются в дневные. Возвращает число удалённых строк по таблицам
+func (db *DB) ApplyRetention(now time.Time) (map[string]int64, error) {
+	p := db.Retention()
+	removed := make(map[string]int64)
+
+	if cutoff := p.hourlyCutoff(now); !cutoff.IsZero() {
+		res, err := db.RollupHourly(cutoff)
+		if err != nil {
+			return removed, fmt.Errorf("свёртка почасовых данных: %w", err)
+		}
+		removed["hourly_traffic_totals"] = res.HoursRemoved
+		removed["user_hourly_traffic"] = res.UserRowsRemoved
+	}
+
+	for _, t := range retentionTables {
+		if t.tier == "hourly" {
+			continue
+		}
+		cutoff := p.cutoff(t.tier, now)
+		if cutoff.IsZero() {
+			continue
+		}
+		res, err := db.conn.Exec(fmt.Sprintf("DELETE FROM %s WHERE %s < ?", t.table, t.column), cutoffArg(t.tier, cutoff))
+		if err != nil {
+			return removed, fmt.Errorf("%s: %w", t.table, err)
+		}
+		removed[t.table], _ = res.RowsAffected()
+	}
+	return removed, nil
+}
+
+// rangeSource подзапрос приращений трафика в окне фильтра: (user_id, at, bytes_received, bytes_sent).
+// Данные берутся из самого детального уровня, который ещё хранится: сырые приращения после
+// границы raw, почасовые агрегаты между границами hourly и raw, дневные — до границы hourly
+func (db *DB) rangeSource(f Filter) (string, []interface{}) {
+	now := time.Now().UTC()
+	p := db.Retention()
+	inst, instArgs := f.instanceWhere("instance")
+
+	query := `SELECT user_id, delta_at AS at, bytes_received, bytes_sent FROM traffic_deltas WHERE ` + inst
+	args := append([]interface{}(nil), instArgs...)
+	if !f.From.IsZero() {
+		query += ` AND delta_at >= ?`
+		args = append(args, f.From.UTC())
+	}
+	if !f.To.IsZero() {
+		query += ` AND delta_at < ?`
+		args = append(args, f.To.UTC())
+	}
+	rawCut := p.rawCutoff(now)
+	if rawCut.IsZero() {
+		return query, args
+	}
+	query += ` AND delta_at >= ?`
+	args = append(args, rawCut)
+
+	where, hourArgs := f.hourWhere("hour")
+	query += ` UNION ALL SELECT user_id, hour AS at, bytes_received, bytes_sent FROM user_hourly_traffic WHERE ` + where + ` AND ` + inst + ` AND hour < ?`
+	args = append(append(append(args, hourArgs...), instArgs...), rawCut)
+	hourlyCut := p.hourlyCutoff(now)
+	if hourlyCut.IsZero() {
+		return query, args
+	}
+	query += ` AND hour >= ?`
+	args = append(args, hourlyCut)
+
+	where, dayArgs := f.dayWhere("day")
+	query += ` UNION ALL SELECT user_id, day AS at, bytes_received, bytes_sent FROM user_daily_traffic WHERE ` + where + ` AND ` + inst + ` AND day < ?`
+	args = append(append(append(args, dayArgs...), instArgs...), hourlyCut.Format("2006-01-02"))
+	return query, args
+}
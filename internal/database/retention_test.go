@@ -0,0 +1,180 @@
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetentionPolicyValidate(t *testing.T) {
+	day := 24 * time.Hour
+	tests := []struct {
+		name string
+		p    RetentionPolicy
+		ok   bool
+	}{
+		{"всё бессрочно", RetentionPolicy{}, true},
+		{"по возрастанию", RetentionPolicy{Raw: 2 * day, Hourly: 30 * day, Daily: 365 * day}, true},
+		{"только сырые", RetentionPolicy{Raw: day}, true},
+		{"почасовые короче сырых", RetentionPolicy{Raw: 7 * day, Hourly: day}, false},
+		{"сырые бессрочно, почасовые нет", RetentionPolicy{Hourly: 30 * day}, false},
+		{"сырые и почасовые бессрочно", RetentionPolicy{Daily: 365 * day}, true},
+		{"дневные короче почасовых", RetentionPolicy{Hourly: 30 * day, Daily: 7 * day}, false},
+		{"отрицательный срок", RetentionPolicy{Raw: -day}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.p.Validate(); (err == nil) != tt.ok {
+				t.Errorf("Validate() = %v", err)
+			}
+		})
+	}
+}
+
+// TestRangeSourceTiers каждый уровень отдаёт только свой отрезок времени: строки старше границы
+// уровня (ещё не удалённые ApplyRetention) берутся из более грубого уровня, а не дважды
+func TestRangeSourceTiers(t *testing.T) {
+	db := newTestDB(t)
+	p := RetentionPolicy{Raw: 48 * time.Hour, Hourly: 7 * 24 * time.Hour}
+	if err := db.SetRetention(p); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().UTC()
+	rawCut, hourlyCut := p.rawCutoff(now), p.hourlyCutoff(now)
+	if rawCut.IsZero() || hourlyCut.IsZero() {
+		t.Fatal("границы уровней не заданы")
+	}
+
+	tx, err := db.conn.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	uid, err := db.ensureUser(tx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Каждая строка — свой бит, по сумме видно, какие строки попали в выборку
+	rows := []struct {
+		query string
+		args  []interface{}
+	}{
+		{"INSERT INTO traffic_deltas (user_id, instance, delta_at, bytes_received, bytes_sent) VALUES (?, 'default', ?, ?, 0)", []interface{}{uid, rawCut, 1}},
+		{"INSERT INTO traffic_deltas (user_id, instance, delta_at, bytes_received, bytes_sent) VALUES (?, 'default', ?, ?, 0)", []interface{}{uid, rawCut.Add(-time.Minute), 2}},
+		{"INSERT INTO user_hourly_traffic (user_id, instance, hour, bytes_received, bytes_sent) VALUES (?, 'default', ?, ?, 0)", []interface{}{uid, rawCut, 4}},
+		{"INSERT INTO user_hourly_traffic (user_id, instance, hour, bytes_received, bytes_sent) VALUES (?, 'default', ?, ?, 0)", []interface{}{uid, rawCut.Add(-time.Hour), 8}},
+		{"INSERT INTO user_hourly_traffic (user_id, instance, hour, bytes_received, bytes_sent) VALUES (?, 'default', ?, ?, 0)", []interface{}{uid, hourlyCut, 16}},
+		{"INSERT INTO user_hourly_traffic (user_id, instance, hour, bytes_received, bytes_sent) VALUES (?, 'default', ?, ?, 0)", []interface{}{uid, hourlyCut.Add(-time.Hour), 32}},
+		{"INSERT INTO user_daily_traffic (user_id, instance, day, bytes_received, bytes_sent) VALUES (?, 'default', ?, ?, 0)", []interface{}{uid, hourlyCut.Format("2006-01-02"), 64}},
+		{"INSERT INTO user_daily_traffic (user_id, instance, day, bytes_received, bytes_sent) VALUES (?, 'default', ?, ?, 0)", []interface{}{uid, hourlyCut.Add(-24 * time.Hour).Format("2006-01-02"), 128}},
+	}
+	for _, r := range rows {
+		if _, err := tx.Exec(r.query, r.args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	sum := func(f Filter) int64 {
+		t.Helper()
+		q, args := db.rangeSource(f)
+		var n int64
+		if err := db.conn.QueryRow("SELECT COALESCE(SUM(bytes_received), 0) FROM ("+q+")", args...).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	tests := []struct {
+		name string
+		f    Filter
+		want int64
+	}{
+		// сырые с границы raw, почасовые от границы hourly до raw, дневные до hourly
+		{"без интервала", Filter{}, 1 + 8 + 16 + 128},
+		{"только сырые", Filter{From: rawCut}, 1},
+		{"сырые и почасовые", Filter{From: hourlyCut}, 1 + 8 + 16},
+		{"только почасовые", Filter{From: hourlyCut, To: rawCut}, 8 + 16},
+		{"только дневные", Filter{To: hourlyCut}, 128},
+		{"другой инстанс", Filter{Instance: "other"}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sum(tt.f); got != tt.want {
+				t.Errorf("сумма %d, ожидалось %d", got, tt.want)
+			}
+		})
+	}
+
+	// Без сроков хранения всё берётся из сырых приращений
+	if err := db.SetRetention(RetentionPolicy{}); err != nil {
+		t.Fatal(err)
+	}
+	if got := sum(Filter{}); got != 1+2 {
+		t.Errorf("без политики сумма %d, ожидалось 3", got)
+	}
+}
+
+func TestApplyRetention(t *testing.T) {
+	db := newTestDB(t)
+	if err := db.SetRetention(RetentionPolicy{Raw: 48 * time.Hour, Hourly: 7 * 24 * time.Hour}); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().UTC()
+	old := now.Add(-10 * 24 * time.Hour)
+	mustSave(t, db, "", status(old, client("alice", "1.1.1.1:1000", old.Add(-time.Minute), 100, 10)))
+	mustSave(t, db, "", status(now, client("alice", "1.1.1.1:1000", old.Add(-time.Minute), 300, 30)))
+
+	removed, err := db.ApplyRetention(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed["traffic_snapshots"] != 1 || removed["traffic_deltas"] != 1 || removed["user_hourly_traffic"] != 1 {
+		t.Errorf("удалено %v", removed)
+	}
+	// Накопленный трафик и трафик за всё время после удаления не меняются
+	if got := totals(t, db)["alice"]; got != [2]int64{300, 30} {
+		t.Errorf("накоплено %v", got)
+	}
+	q, args := db.rangeSource(Filter{})
+	var r int64
+	if err := db.conn.QueryRow("SELECT SUM(bytes_received) FROM ("+q+")", args...).Scan(&r); err != nil {
+		t.Fatal(err)
+	}
+	if r != 300 {
+		t.Errorf("по уровням %d, ожидалось 300", r)
+	}
+}
+
+// TestTotalsInRange трафик за интервал — сумма приращений снимков внутри [From, To)
+func TestTotalsInRange(t *testing.T) {
+	db := newTestDB(t)
+	since := base.Add(-time.Hour)
+	mustSave(t, db, "vpn1", status(base, client("alice", "1.1.1.1:1000", since, 100, 10)))
+	mustSave(t, db, "vpn1", status(base.Add(30*time.Minute), client("alice", "1.1.1.1:1000", since, 400, 40)))
+	mustSave(t, db, "vpn1", status(base.Add(90*time.Minute), client("alice", "1.1.1.1:1000", since, 900, 90)))
+
+	tests := []struct {
+		name string
+		f    Filter
+		want [2]int64
+	}{
+		{"всё время", Filter{}, [2]int64{900, 90}},
+		{"первый час без первого снимка", Filter{From: base.Add(time.Minute), To: base.Add(time.Hour)}, [2]int64{300, 30}},
+		{"с начала второго часа", Filter{From: base.Add(time.Hour)}, [2]int64{500, 50}},
+		{"до второго снимка включительно", Filter{To: base.Add(31 * time.Minute)}, [2]int64{400, 40}},
+		{"граница To не включается", Filter{To: base.Add(30 * time.Minute)}, [2]int64{100, 10}},
+		{"другой инстанс", Filter{Instance: "vpn2"}, [2]int64{0, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr, err := db.GetTotalTraffic("alice", tt.f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := [2]int64{tr.BytesReceived, tr.BytesSent}; got != tt.want {
+				t.Errorf("%v, ожидалось %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"open-statistic/internal/parser"
+)
+
+// RouteEntry маршрут из последнего снимка (ROUTING TABLE)
+type RouteEntry struct {
+	Instance    string    `json:"instance"`
+	VirtualAddr string    `json:"virtual_address"`
+	CommonName  string    `json:"common_name"`
+	RealAddress string    `json:"real_address"`
+	LastRef     time.Time `json:"last_ref"`
+	SnapshotAt  time.Time `json:"snapshot_at"`
+}
+
+func (db *DB) saveRoutes(tx *sql.Tx, instance string, routes []parser.Route, snapshotAt time.Time) error {
+	if len(routes) == 0 {
+		return nil
+	}
+	insert, err := tx.Prepare(`INSERT INTO route_snapshots (user_id, instance, virtual_address, real_address, last_ref, snapshot_at) VALUES (?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer insert.Close()
+
+	for _, r := range routes {
+		if !parser.ValidCommonName(r.CommonName) {
+			continue
+		}
+		userID, err := db.ensureUser(tx, r.CommonName)
+		if err != nil {
+			return err
+		}
+		if _, err := insert.Exec(userID, instance, r.VirtualAddr, r.RealAddress, r.LastRef, snapshotAt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetLatestRoutes возвращает таблицу маршрутов из последнего снимка каждого инстанса. commonName="" — все клиенты
+func (db *DB) GetLatestRoutes(commonName string, f Filter) ([]RouteEntry, error) {
+	inst, args := f.instanceWhere("r.instance")
+	rows, err := db.conn.Query(`
+		SELECT r.instance, r.virtual_address, u.common_name, COALESCE(r.real_address, ''), r.last_ref, r.snapshot_at
+		FROM route_snapshots r
+		JOIN users u ON u.id = r.user_id
+		WHERE r.snapshot_at = `+latestSnapshot("r")+` AND (? = '' OR u.common_name = ?) AND `+inst+`
+		ORDER BY u.common_name, r.instance, r.virtual_address`, append([]interface{}{commonName, commonName}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	routes := make([]RouteEntry, 0, 32)
+	for rows.Next() {
+		var r RouteEntry
+		var lastRef sql.NullTime
+		if err := rows.Scan(&r.Instance, &r.VirtualAddr, &r.CommonName, &r.RealAddress, &lastRef, &r.SnapshotAt); err != nil {
+			return nil, err
+		}
+		if lastRef.Valid {
+			r.LastRef = lastRef.Time
+		}
+		routes = append(routes, r)
+	}
+	return routes, rows.Err()
+}
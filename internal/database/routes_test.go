@@ -0,0 +1,62 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"open-statistic/internal/parser"
+)
+
+func TestGetLatestRoutes(t *testing.T) {
+	db := newTestDB(t)
+	since := base.Add(-time.Hour)
+	route := func(addr, cn, real string) parser.Route {
+		return parser.Route{VirtualAddr: addr, CommonName: cn, RealAddress: real, LastRef: base}
+	}
+	s := status(base, client("alice", "1.1.1.1:1000", since, 1, 1), client("bob", "2.2.2.2:2000", since, 1, 1))
+	s.Routes = []parser.Route{route("10.8.0.2", "alice", "1.1.1.1:1000"), route("10.8.0.3", "bob", "2.2.2.2:2000")}
+	mustSave(t, db, "vpn1", s)
+	// В следующем снимке vpn1 маршрут bob исчез, у alice появилась подсеть; UNDEF не сохраняется
+	s = status(base.Add(time.Minute), client("alice", "1.1.1.1:1000", since, 2, 2))
+	s.Routes = []parser.Route{route("10.8.0.2", "alice", "1.1.1.1:1000"), route("192.168.50.0/24", "alice", "1.1.1.1:1000"),
+		route("10.8.0.9", "UNDEF", "9.9.9.9:9000")}
+	mustSave(t, db, "vpn1", s)
+	s = status(base, client("carol", "3.3.3.3:3000", since, 1, 1))
+	s.Routes = []parser.Route{route("10.9.0.2", "carol", "3.3.3.3:3000")}
+	mustSave(t, db, "vpn2", s)
+
+	tests := []struct {
+		name       string
+		commonName string
+		instance   string
+		want       []string // инстанс/адрес
+	}{
+		{"все", "", "", []string{"vpn1/10.8.0.2", "vpn1/192.168.50.0/24", "vpn2/10.9.0.2"}},
+		{"по CN", "alice", "", []string{"vpn1/10.8.0.2", "vpn1/192.168.50.0/24"}},
+		{"по инстансу", "", "vpn2", []string{"vpn2/10.9.0.2"}},
+		{"нет в последнем снимке", "bob", "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			routes, err := db.GetLatestRoutes(tt.commonName, Filter{Instance: tt.instance})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, r := range routes {
+				got = append(got, r.Instance+"/"+r.VirtualAddr)
+				if !r.LastRef.Equal(base) {
+					t.Errorf("%s: last_ref %v", r.VirtualAddr, r.LastRef)
+				}
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("маршруты %v, ожидалось %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("маршрут %d: %s, ожидалось %s", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,242 @@
+package database
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"open-statistic/internal/parser"
+)
+
+// Session одна сессия клиента: от подключения до первого снимка, в котором её уже нет
+type Session struct {
+	ID             int64      `json:"id"`
+	Instance       string     `json:"instance"`
+	CommonName     string     `json:"common_name"`
+	RealAddress    string     `json:"real_address"`
+	VirtualAddr    string     `json:"virtual_address,omitempty"`
+	Username       string     `json:"username,omitempty"`
+	Cipher         string     `json:"cipher,omitempty"`
+	ConnectedSince time.Time  `json:"connected_since"`
+	FirstSeenAt    time.Time  `json:"first_seen_at"`
+	LastSeenAt     time.Time  `json:"last_seen_at"`
+	EndedAt        *time.Time `json:"ended_at"`
+	BytesReceived  int64      `json:"bytes_received"`
+	BytesSent      int64      `json:"bytes_sent"`
+}
+
+// Active сессия ещё не завершена
+func (s *Session) Active() bool {
+	return s.EndedAt == nil
+}
+
+// Duration длительность сессии: до завершения или до последнего снимка, где она была видна
+func (s *Session) Duration() time.Duration {
+	start := s.ConnectedSince
+	if start.IsZero() {
+		start = s.FirstSeenAt
+	}
+	end := s.LastSeenAt
+	if s.EndedAt != nil {
+		end = *s.EndedAt
+	}
+	return end.Sub(start)
+}
+
+// SessionFilter фильтры для GetSessions. Пустые поля не ограничивают выборку
+type SessionFilter struct {
+	CommonName  string
+	Instance    string
+	RealAddress string
+	Active      *bool     // true — только открытые, false — только завершённые
+	From        time.Time // сессии, активные в окне [From, To)
+	To          time.Time
+	Limit       int
+}
+
+// upsertSession создаёт или продлевает сессию и обновляет её пиковую скорость (rr, rs — скорость
+// в этом снимке, nil — неизвестна). Снимок старше уже записанного (импорт архива) сессию не откатывает
+func (db *DB) upsertSession(tx *sql.Tx, instance string, userID int64, c parser.Client, rr, rs *float64, at time.Time) error {
+	var peakR, peakS float64
+	if rr != nil {
+		peakR, peakS = *rr, *rs
+	}
+	_, err := tx.Exec(`
+		INSERT INTO sessions (user_id, instance, real_address, connected_since, virtual_address, username, cipher,
+			first_seen_at, last_seen_at, bytes_received, bytes_sent, peak_rate_received, peak_rate_sent)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(instance, user_id, real_address, connected_since) DO UPDATE SET
+			virtual_address=excluded.virtual_address,
+			username=excluded.username,
+			cipher=excluded.cipher,
+			last_seen_at=excluded.last_seen_at,
+			ended_at=NULL,
+			bytes_received=excluded.bytes_received,
+			bytes_sent=excluded.bytes_sent,
+			peak_rate_received=MAX(peak_rate_received, excluded.peak_rate_received),
+			peak_rate_sent=MAX(peak_rate_sent, excluded.peak_rate_sent)
+		WHERE excluded.last_seen_at >= sessions.last_seen_at`,
+		userID, instance, c.RealAddress, c.ConnectedSince, c.VirtualAddr, c.Username, c.Cipher,
+		at, at, c.BytesReceived, c.BytesSent, peakR, peakS)
+	return err
+}
+
+// closeMissingSessions завершает открытые сессии инстанса, не попавшие в снимок at
+func (db *DB) closeMissingSessions(tx *sql.Tx, instance string, at time.Time) error {
+	_, err := tx.Exec(`UPDATE sessions SET ended_at=? WHERE instance = ? AND ended_at IS NULL AND last_seen_at < ?`, at, instance, at)
+	return err
+}
+
+// GetSessions возвращает сессии, новые сверху
+func (db *DB) GetSessions(f SessionFilter) ([]Session, error) {
+	var where []string
+	var args []interface{}
+	if f.CommonName != "" {
+		where = append(where, "u.common_name = ?")
+		args = append(args, f.CommonName)
+	}
+	if f.Instance != "" {
+		where = append(where, "s.instance = ?")
+		args = append(args, f.Instance)
+	}
+	if f.RealAddress != "" {
+		where = append(where, "s.real_address = ?")
+		args = append(args, f.RealAddress)
+	}
+	if f.Active != nil {
+		if *f.Active {
+			where = append(where, "s.ended_at IS NULL")
+		} else {
+			where = append(where, "s.ended_at IS NOT NULL")
+		}
+	}
+	if !f.From.IsZero() {
+		where = append(where, "(s.ended_at IS NULL OR s.ended_at >= ?)")
+		args = append(args, f.From.UTC())
+	}
+	if !f.To.IsZero() {
+		where = append(where, "s.first_seen_at < ?")
+		args = append(args, f.To.UTC())
+	}
+	limit := f.Limit
+	if limit <= 0 {
+		limit = 100
+	}
+
+	query := `
+		SELECT s.id, s.instance, u.common_name, s.real_address, COALESCE(s.virtual_address, ''), COALESCE(s.username, ''), COALESCE(s.cipher, ''),
+			s.connected_since, s.first_seen_at, s.last_seen_at, s.ended_at,
+			s.bytes_received, s.bytes_sent
+		FROM sessions s
+		JOIN users u ON u.id = s.user_id`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY s.first_seen_at DESC, s.id DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]Session, 0, 32)
+	for rows.Next() {
+		var s Session
+		var endedAt sql.NullTime
+		if err := rows.Scan(&s.ID, &s.Instance, &s.CommonName, &s.RealAddress, &s.VirtualAddr, &s.Username, &s.Cipher,
+			&s.ConnectedSince, &s.FirstSeenAt, &s.LastSeenAt, &endedAt,
+			&s.BytesReceived, &s.BytesSent); err != nil {
+			return nil, err
+		}
+		if endedAt.Valid {
+			s.EndedAt = &endedAt.Time
+		}
+		result = append(result, s)
+	}
+	return result, rows.Err()
+}
+
+// SessionEnd отключение клиента, о котором OpenVPN сообщил через management-интерфейс
+type SessionEnd struct {
+	CommonName     string
+	RealAddress    string
+	VirtualAddr    string
+	Username       string
+	ConnectedSince time.Time // zero — последняя сессия с этого адреса, открытая или завершённая не раньше endSessionWindow до EndedAt
+	EndedAt        time.Time
+	BytesReceived  int64 // итоговые счётчики на момент отключения
+	BytesSent      int64
+}
+
+// endSessionWindow насколько раньше отключения могла быть уже завершена сессия без connected_since:
+// повторным DISCONNECT или снимком, в который она не попала
+const endSessionWindow = 10 * time.Minute
+
+// EndSession завершает сессию точным временем отключения и учитывает трафик между последним
+// снимком, где сессия была видна, и отключением — при опросе он теряется. Сессия, не попавшая
+// ни в один снимок, создаётся и учитывается целиком. Повторный вызов ничего не добавляет
+func (db *DB) EndSession(instance string, e SessionEnd) error {
+	if !parser.ValidCommonName(e.CommonName) {
+		return nil
+	}
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	uid, err := db.ensureUser(tx, e.CommonName)
+	if err != nil {
+		return err
+	}
+	query := `SELECT id, bytes_received, bytes_sent FROM sessions WHERE instance = ? AND user_id = ? AND real_address = ? AND connected_since = ?`
+	args := []interface{}{instance, uid, e.RealAddress, e.ConnectedSince}
+	if e.ConnectedSince.IsZero() {
+		// Уже завершённая сессия тоже подходит: иначе повтор создал бы вторую и учёл трафик дважды
+		query = `SELECT id, bytes_received, bytes_sent FROM sessions WHERE instance = ? AND user_id = ? AND real_address = ?
+			AND (ended_at IS NULL OR ended_at >= ?) ORDER BY first_seen_at DESC LIMIT 1`
+		args = append(args[:3], e.EndedAt.Add(-endSessionWindow))
+	}
+	var id, seenR, seenS int64
+	err = tx.QueryRow(query, args...).Scan(&id, &seenR, &seenS)
+	switch {
+	case err == sql.ErrNoRows:
+		connected := e.ConnectedSince
+		if connected.IsZero() {
+			connected = e.EndedAt
+		}
+		if _, err := tx.Exec(`
+			INSERT INTO sessions (user_id, instance, real_address, connected_since, virtual_address, username,
+				first_seen_at, last_seen_at, ended_at, bytes_received, bytes_sent)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			uid, instance, e.RealAddress, connected, e.VirtualAddr, e.Username,
+			e.EndedAt, e.EndedAt, e.EndedAt, e.BytesReceived, e.BytesSent); err != nil {
+			return err
+		}
+	case err != nil:
+		return err
+	default:
+		if _, err := tx.Exec(`
+			UPDATE sessions SET ended_at=?, bytes_received=MAX(bytes_received, ?), bytes_sent=MAX(bytes_sent, ?)
+			WHERE id=?`, e.EndedAt, e.BytesReceived, e.BytesSent, id); err != nil {
+			return err
+		}
+	}
+
+	// Счётчики сессии в последнем снимке уже учтены; итог меньше них — не та сессия, ничего не добавляем
+	dr, ds := e.BytesReceived-seenR, e.BytesSent-seenS
+	if dr < 0 || ds < 0 {
+		dr, ds = 0, 0
+	}
+	if dr > 0 || ds > 0 {
+		if err := addTraffic(tx, instance, uid, dr, ds, e.EndedAt); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(`DELETE FROM session_last_bytes WHERE instance = ? AND user_id = ? AND real_address = ?`, instance, uid, e.RealAddress); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,52 @@
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+// TestEndSessionRepeat повторный DISCONNECT без connected_since не создаёт вторую сессию
+// и не учитывает трафик ещё раз — в том числе после закрытия сессии снимком
+func TestEndSessionRepeat(t *testing.T) {
+	since := base.Add(-time.Hour)
+	tests := []struct {
+		name     string
+		snapshot bool // сессия была видна в снимке
+		closed   bool // и закрыта следующим снимком до DISCONNECT
+		want     [2]int64
+	}{
+		{"не попала в снимок", false, false, [2]int64{500, 50}},
+		{"открыта", true, false, [2]int64{500, 50}},
+		{"закрыта снимком", true, true, [2]int64{500, 50}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			if tt.snapshot {
+				mustSave(t, db, "vpn1", status(base, client("alice", "1.1.1.1:1000", since, 300, 30)))
+			}
+			if tt.closed {
+				mustSave(t, db, "vpn1", status(base.Add(time.Minute)))
+			}
+			end := SessionEnd{CommonName: "alice", RealAddress: "1.1.1.1:1000", EndedAt: base.Add(30 * time.Second),
+				BytesReceived: 500, BytesSent: 50}
+			for i := 0; i < 2; i++ {
+				if err := db.EndSession("vpn1", end); err != nil {
+					t.Fatal(err)
+				}
+				end.EndedAt = end.EndedAt.Add(time.Second)
+			}
+
+			if got := totals(t, db)["alice"]; got != tt.want {
+				t.Errorf("трафик %v, ожидалось %v", got, tt.want)
+			}
+			sessions, err := db.GetSessions(SessionFilter{CommonName: "alice"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(sessions) != 1 || sessions[0].Active() || sessions[0].BytesReceived != 500 {
+				t.Errorf("сессии %+v", sessions)
+			}
+		})
+	}
+}
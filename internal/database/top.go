@@ -0,0 +1,99 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// Метрика, по которой ранжируются пользователи (GetTopTraffic)
+const (
+	TopByTotal    = "total"
+	TopBySent     = "sent"
+	TopByReceived = "received"
+)
+
+// TopTalker место пользователя в рейтинге за период. Value — трафик по метрике рейтинга,
+// Previous* — за предыдущий период той же длины (PreviousRank 0 — трафика не было)
+type TopTalker struct {
+	Rank          int    `json:"rank"`
+	CommonName    string `json:"common_name"`
+	BytesReceived int64  `json:"bytes_received"`
+	BytesSent     int64  `json:"bytes_sent"`
+	TotalBytes    int64  `json:"total_bytes"`
+	Value         int64  `json:"value"`
+	PreviousValue int64  `json:"previous_value"`
+	PreviousRank  int    `json:"previous_rank,omitempty"`
+}
+
+// TopReport первые пользователи рейтинга и трафик всех пользователей по метрике рейтинга
+type TopReport struct {
+	Total         int64       `json:"total"`
+	PreviousTotal int64       `json:"previous_total"`
+	Users         []TopTalker `json:"users"`
+}
+
+// topMetric выражение метрики рейтинга над колонками r (получено) и s (отправлено)
+func topMetric(by string) (string, error) {
+	switch by {
+	case TopByTotal:
+		return "r + s", nil
+	case TopBySent:
+		return "s", nil
+	case TopByReceived:
+		return "r", nil
+	}
+	return "", fmt.Errorf("неизвестная метрика рейтинга %q", by)
+}
+
+// GetTopTraffic возвращает n пользователей с наибольшим трафиком за интервал cur по метрике by.
+// Трафик считается по приращениям (как интервалы /traffic/total), а не по накопленным итогам;
+// prev — предыдущий период для сравнения. Пользователи с равным трафиком делят место
+func (db *DB) GetTopTraffic(cur, prev Filter, by string, n int) (*TopReport, error) {
+	metric, err := topMetric(by)
+	if err != nil {
+		return nil, err
+	}
+	curSrc, curArgs := db.rangeSource(cur)
+	prevSrc, prevArgs := db.rangeSource(prev)
+	ranked := func(src string) string {
+		return `SELECT user_id, r, s, m, RANK() OVER (ORDER BY m DESC) AS rnk, SUM(m) OVER () AS total
+			FROM (SELECT user_id, r, s, ` + metric + ` AS m
+				FROM (SELECT user_id, SUM(bytes_received) AS r, SUM(bytes_sent) AS s FROM (` + src + `) GROUP BY user_id))
+			WHERE m > 0`
+	}
+	query := `
+		WITH cur AS (` + ranked(curSrc) + `), prev AS (` + ranked(prevSrc) + `)
+		SELECT c.rnk, u.common_name, c.r, c.s, c.m, c.total, COALESCE(p.m, 0), COALESCE(p.rnk, 0)
+		FROM cur c
+		JOIN users u ON u.id = c.user_id
+		LEFT JOIN prev p ON p.user_id = c.user_id
+		ORDER BY c.rnk, u.common_name
+		LIMIT ?`
+	args := append(append(curArgs, prevArgs...), n)
+
+	report := &TopReport{Users: make([]TopTalker, 0, n)}
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t TopTalker
+		if err := rows.Scan(&t.Rank, &t.CommonName, &t.BytesReceived, &t.BytesSent, &t.Value, &report.Total, &t.PreviousValue, &t.PreviousRank); err != nil {
+			return nil, err
+		}
+		t.TotalBytes = t.BytesReceived + t.BytesSent
+		report.Users = append(report.Users, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Общий трафик предыдущего периода нужен, даже если никто из первых n тогда не был активен
+	var prevTotal sql.NullInt64
+	if err := db.conn.QueryRow(`SELECT SUM(m) FROM (`+ranked(prevSrc)+`)`, prevArgs...).Scan(&prevTotal); err != nil {
+		return nil, err
+	}
+	report.PreviousTotal = prevTotal.Int64
+	return report, nil
+}
@@ -0,0 +1,165 @@
+// Package events — публикация событий сбора (подключения, отключения, скорость, итоги)
+// подписчикам внутри процесса, например SSE-потоку /events.
+package events
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// Event событие для подписчиков. ID растёт монотонно в пределах процесса
+type Event struct {
+	ID       uint64          `json:"id"`
+	Type     string          `json:"type"`
+	Instance string          `json:"instance"`
+	At       time.Time       `json:"at"`
+	Data     json.RawMessage `json:"data"`
+}
+
+// Subscriber подписка на события. C закрывается при Unsubscribe, при закрытии брокера и если
+// подписчик не успевает читать (тогда Lagged() == true)
+type Subscriber struct {
+	C      <-chan Event
+	ch     chan Event
+	filter func(Event) bool
+	lagged bool
+}
+
+// Lagged отключён ли подписчик за переполнение буфера. Читать после закрытия C
+func (s *Subscriber) Lagged() bool {
+	return s.lagged
+}
+
+// Broker рассылает события подписчикам. Publish никогда не ждёт: подписчик с заполненным
+// буфером отключается, чтобы медленный клиент не задерживал сбор и остальных. Последние
+// события хранятся, чтобы переподключившийся клиент продолжил с места обрыва
+type Broker struct {
+	mu      sync.Mutex
+	subs    map[*Subscriber]struct{}
+	history []Event // кольцевой буфер последних событий
+	next    int     // позиция следующей записи в history
+	lastID  uint64
+	dropped uint64 // отключено медленных подписчиков
+	closed  bool
+}
+
+// NewBroker создаёт брокер, который хранит history последних событий
+func NewBroker(history int) *Broker {
+	return &Broker{subs: make(map[*Subscriber]struct{}), history: make([]Event, 0, history)}
+}
+
+// Publish рассылает событие typ инстанса instance; data кодируется в JSON один раз
+func (b *Broker) Publish(typ, instance string, at time.Time, data interface{}) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil
+	}
+	b.lastID++
+	ev := Event{ID: b.lastID, Type: typ, Instance: instance, At: at.UTC(), Data: raw}
+	if cap(b.history) > 0 {
+		if len(b.history) < cap(b.history) {
+			b.history = append(b.history, ev)
+		} else {
+			b.history[b.next] = ev
+		}
+		b.next = (b.next + 1) % cap(b.history)
+	}
+	for s := range b.subs {
+		if s.filter != nil && !s.filter(ev) {
+			continue
+		}
+		select {
+		case s.ch <- ev:
+		default:
+			s.lagged = true
+			b.dropped++
+			b.remove(s)
+		}
+	}
+	return nil
+}
+
+// Subscribe подписывает на события, для которых filter возвращает true (nil — на все).
+// buffer — сколько событий может ждать чтения. lastID > 0 — сначала отдать сохранённые
+// события после него; ok == false, если часть из них уже вытеснена из истории
+func (b *Broker) Subscribe(buffer int, lastID uint64, filter func(Event) bool) (s *Subscriber, ok bool) {
+	ch := make(chan Event, buffer)
+	s = &Subscriber{C: ch, ch: ch, filter: filter}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ok = true
+	if b.closed {
+		close(ch)
+		return s, ok
+	}
+	if lastID > 0 {
+		var missed []Event
+		for i := 0; i < len(b.history); i++ {
+			ev := b.history[(b.next+i)%len(b.history)]
+			if ev.ID > lastID && (filter == nil || filter(ev)) {
+				missed = append(missed, ev)
+			}
+		}
+		switch {
+		case lastID > b.lastID:
+			// ID из прошлого запуска сервера: что было между запусками, неизвестно
+			ok = false
+		case lastID < b.lastID && (len(b.history) == 0 || b.history[b.next%len(b.history)].ID > lastID+1):
+			// Следующее за lastID событие уже вытеснено из истории
+			ok = false
+		}
+		if len(missed) > buffer {
+			missed, ok = missed[len(missed)-buffer:], false
+		}
+		for _, ev := range missed {
+			ch <- ev
+		}
+	}
+	b.subs[s] = struct{}{}
+	return s, ok
+}
+
+// Unsubscribe отменяет подписку
+func (b *Broker) Unsubscribe(s *Subscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.remove(s)
+}
+
+// remove удаляет подписчика и закрывает его канал; b.mu удерживается
+func (b *Broker) remove(s *Subscriber) {
+	if _, ok := b.subs[s]; ok {
+		delete(b.subs, s)
+		close(s.ch)
+	}
+}
+
+// Subscribers число подписчиков
+func (b *Broker) Subscribers() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subs)
+}
+
+// Dropped сколько подписчиков отключено за то, что не успевали читать
+func (b *Broker) Dropped() uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.dropped
+}
+
+// Close отключает всех подписчиков; дальнейшие Publish ничего не делают
+func (b *Broker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for s := range b.subs {
+		b.remove(s)
+	}
+}
@@ -0,0 +1,118 @@
+package events
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func publish(t *testing.T, b *Broker, n int, typ string) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if err := b.Publish(typ, "vpn1", time.Now(), map[string]int{"n": i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// received события, уже лежащие в буфере подписчика
+func received(s *Subscriber) []uint64 {
+	var ids []uint64
+	for {
+		select {
+		case ev, ok := <-s.C:
+			if !ok {
+				return ids
+			}
+			ids = append(ids, ev.ID)
+		default:
+			return ids
+		}
+	}
+}
+
+func TestBrokerReplay(t *testing.T) {
+	b := NewBroker(5)
+	publish(t, b, 3, "connect")
+
+	s, ok := b.Subscribe(10, 1, nil)
+	if !ok {
+		t.Error("история с события 2 цела, ok == false")
+	}
+	publish(t, b, 1, "connect")
+	if got := received(s); !slices.Equal(got, []uint64{2, 3, 4}) {
+		t.Errorf("после Last-Event-ID 1: %v", got)
+	}
+
+	// История хранит 5 событий: после 8 публикаций в ней 4..8
+	publish(t, b, 4, "connect")
+	tests := []struct {
+		name   string
+		lastID uint64
+		buffer int
+		want   []uint64
+		ok     bool
+	}{
+		{"без пропуска", 3, 10, []uint64{4, 5, 6, 7, 8}, true},
+		{"событие 3 вытеснено", 2, 10, []uint64{4, 5, 6, 7, 8}, false},
+		{"последнее событие", 8, 10, nil, true},
+		{"ID прошлого запуска", 100, 10, nil, false},
+		{"пропущенное не влезает в буфер", 3, 2, []uint64{7, 8}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, ok := b.Subscribe(tt.buffer, tt.lastID, nil)
+			defer b.Unsubscribe(s)
+			if ok != tt.ok {
+				t.Errorf("ok = %v, ожидалось %v", ok, tt.ok)
+			}
+			if got := received(s); !slices.Equal(got, tt.want) {
+				t.Errorf("отдано %v, ожидалось %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBrokerFilter(t *testing.T) {
+	b := NewBroker(10)
+	publish(t, b, 2, "connect")
+	publish(t, b, 2, "throughput")
+	onlyConnect := func(ev Event) bool { return ev.Type == "connect" }
+	s, _ := b.Subscribe(10, 1, onlyConnect)
+	publish(t, b, 1, "throughput")
+	publish(t, b, 1, "connect")
+	if got := received(s); !slices.Equal(got, []uint64{2, 6}) {
+		t.Errorf("только connect: %v", got)
+	}
+}
+
+// TestBrokerLagged подписчик с полным буфером отключается, не задерживая остальных
+func TestBrokerLagged(t *testing.T) {
+	b := NewBroker(0)
+	slow, _ := b.Subscribe(1, 0, nil)
+	fast, _ := b.Subscribe(10, 0, nil)
+	publish(t, b, 3, "totals")
+
+	if got := received(slow); !slices.Equal(got, []uint64{1}) {
+		t.Errorf("медленный подписчик получил %v", got)
+	}
+	if _, open := <-slow.C; open || !slow.Lagged() {
+		t.Errorf("медленный подписчик не отключён: lagged=%v", slow.Lagged())
+	}
+	if got := received(fast); !slices.Equal(got, []uint64{1, 2, 3}) {
+		t.Errorf("быстрый подписчик получил %v", got)
+	}
+	if fast.Lagged() || b.Subscribers() != 1 || b.Dropped() != 1 {
+		t.Errorf("lagged=%v, подписчиков %d, отключено %d", fast.Lagged(), b.Subscribers(), b.Dropped())
+	}
+
+	b.Close()
+	if _, open := <-fast.C; open || fast.Lagged() {
+		t.Error("после Close канал должен быть закрыт без lagged")
+	}
+	publish(t, b, 1, "totals")
+	s, _ := b.Subscribe(1, 0, nil)
+	if _, open := <-s.C; open {
+		t.Error("подписка после Close открыта")
+	}
+}
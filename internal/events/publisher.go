@@ -0,0 +1,167 @@
+package events
+
+import (
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"open-statistic/internal/database"
+	"open-statistic/internal/parser"
+)
+
+// Типы событий
+const (
+	TypeConnect    = "connect"
+	TypeDisconnect = "disconnect"
+	TypeThroughput = "throughput"
+	TypeTotals     = "totals"
+)
+
+// Client клиент в событиях connect и disconnect
+type Client struct {
+	CommonName     string    `json:"common_name"`
+	RealAddress    string    `json:"real_address"`
+	VirtualAddr    string    `json:"virtual_address,omitempty"`
+	ConnectedSince time.Time `json:"connected_since"`
+	BytesReceived  int64     `json:"bytes_received"`
+	BytesSent      int64     `json:"bytes_sent"`
+}
+
+// ClientRate скорость клиента, сохранённая с последним снимком (как rate_received/rate_sent
+// в /connected), байт в секунду; nil — неизвестна
+type ClientRate struct {
+	CommonName    string   `json:"common_name"`
+	RealAddress   string   `json:"real_address"`
+	BytesReceived int64    `json:"bytes_received"`
+	BytesSent     int64    `json:"bytes_sent"`
+	RateReceived  *float64 `json:"rate_received"`
+	RateSent      *float64 `json:"rate_sent"`
+}
+
+// Throughput событие throughput: скорость всех подключённых клиентов инстанса
+type Throughput struct {
+	RateReceived float64      `json:"rate_received"` // сумма известных скоростей клиентов
+	RateSent     float64      `json:"rate_sent"`
+	Clients      []ClientRate `json:"clients"`
+}
+
+// Totals событие totals: подключения и накопленный трафик инстанса после снимка
+type Totals struct {
+	Connected     int   `json:"connected"`
+	BytesReceived int64 `json:"bytes_received"`
+	BytesSent     int64 `json:"bytes_sent"`
+}
+
+// snapshot клиенты последнего снимка инстанса по ключу сессии
+type snapshot struct {
+	clients map[string]parser.Client
+}
+
+// Publisher сравнивает каждый сохранённый снимок с предыдущим того же инстанса и публикует
+// события в брокер. По первому снимку инстанса после запуска подключения и отключения
+// не публикуются: сравнивать не с чем
+type Publisher struct {
+	db     *database.DB
+	broker *Broker
+
+	mu   sync.Mutex
+	prev map[string]snapshot
+}
+
+// NewPublisher создаёт публикатор событий сбора
+func NewPublisher(db *database.DB, broker *Broker) *Publisher {
+	return &Publisher{db: db, broker: broker, prev: make(map[string]snapshot)}
+}
+
+// sessionKey сессия клиента: переподключение с того же адреса — новая сессия
+func sessionKey(cl parser.Client) string {
+	return cl.CommonName + "|" + cl.RealAddress + "|" + strconv.FormatInt(cl.ConnectedSince.Unix(), 10)
+}
+
+// OnSave публикует события после сохранения снимка инстанса (collector.SaveHook)
+func (p *Publisher) OnSave(instance string, status *parser.Status) {
+	at := status.UpdatedAt
+	if at.IsZero() {
+		at = time.Now()
+	}
+	cur := snapshot{clients: make(map[string]parser.Client, len(status.Clients))}
+	for _, cl := range status.Clients {
+		if parser.ValidCommonName(cl.CommonName) {
+			cur.clients[sessionKey(cl)] = cl
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	prev, ok := p.prev[instance]
+	p.prev[instance] = cur
+	if ok {
+		for k, cl := range prev.clients {
+			if _, still := cur.clients[k]; !still {
+				p.publish(TypeDisconnect, instance, at, eventClient(cl))
+			}
+		}
+		for k, cl := range cur.clients {
+			if _, was := prev.clients[k]; !was {
+				p.publish(TypeConnect, instance, at, eventClient(cl))
+			}
+		}
+	}
+
+	if tp, err := p.throughput(instance); err != nil {
+		log.Printf("События [%s]: скорость: %v", instance, err)
+	} else {
+		p.publish(TypeThroughput, instance, at, tp)
+	}
+
+	stats, err := p.db.GetStats(database.Filter{Instance: instance})
+	if err != nil {
+		log.Printf("События [%s]: итоги: %v", instance, err)
+		return
+	}
+	p.publish(TypeTotals, instance, at, Totals{Connected: stats.ConnectedCount, BytesReceived: stats.TotalBytesR, BytesSent: stats.TotalBytesS})
+}
+
+// throughput скорости клиентов инстанса, сохранённые вместе со снимком
+func (p *Publisher) throughput(instance string) (Throughput, error) {
+	clients, _, err := p.db.GetConnected(database.Filter{Instance: instance}, database.ListOptions{})
+	if err != nil {
+		return Throughput{}, err
+	}
+	tp := Throughput{Clients: make([]ClientRate, 0, len(clients))}
+	for _, cl := range clients {
+		if cl.RateReceived != nil {
+			tp.RateReceived += *cl.RateReceived
+		}
+		if cl.RateSent != nil {
+			tp.RateSent += *cl.RateSent
+		}
+		tp.Clients = append(tp.Clients, ClientRate{
+			CommonName:    cl.CommonName,
+			RealAddress:   cl.RealAddress,
+			BytesReceived: cl.BytesReceived,
+			BytesSent:     cl.BytesSent,
+			RateReceived:  cl.RateReceived,
+			RateSent:      cl.RateSent,
+		})
+	}
+	return tp, nil
+}
+
+func (p *Publisher) publish(typ, instance string, at time.Time, data interface{}) {
+	if err := p.broker.Publish(typ, instance, at, data); err != nil {
+		log.Printf("События [%s]: %s: %v", instance, typ, err)
+	}
+}
+
+func eventClient(cl parser.Client) Client {
+	return Client{
+		CommonName:     cl.CommonName,
+		RealAddress:    cl.RealAddress,
+		VirtualAddr:    cl.VirtualAddr,
+		ConnectedSince: cl.ConnectedSince,
+		BytesReceived:  cl.BytesReceived,
+		BytesSent:      cl.BytesSent,
+	}
+}
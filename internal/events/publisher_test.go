@@ -0,0 +1,64 @@
+package events
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"open-statistic/internal/database"
+	"open-statistic/internal/parser"
+)
+
+// TestPublisherThroughput throughput отдаёт скорости, сохранённые со снимком, — те же, что /connected
+func TestPublisherThroughput(t *testing.T) {
+	db, err := database.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	b := NewBroker(0)
+	p := NewPublisher(db, b)
+	s, _ := b.Subscribe(16, 0, func(ev Event) bool { return ev.Type == TypeThroughput })
+
+	base := time.Date(2024, 2, 23, 10, 0, 0, 0, time.UTC)
+	since := base.Add(-time.Minute)
+	save := func(at time.Time, received, sent int64) {
+		t.Helper()
+		status := &parser.Status{Version: 2, UpdatedAt: at, Clients: []parser.Client{
+			{CommonName: "alice", RealAddress: "1.1.1.1:1000", ConnectedSince: since, BytesReceived: received, BytesSent: sent},
+		}}
+		if err := db.SaveSnapshot("vpn1", status); err != nil {
+			t.Fatal(err)
+		}
+		p.OnSave("vpn1", status)
+	}
+	save(base, 6000, 600)
+	save(base.Add(10*time.Second), 16000, 1600)
+
+	connected, _, err := db.GetConnected(database.Filter{Instance: "vpn1"}, database.ListOptions{})
+	if err != nil || len(connected) != 1 || connected[0].RateReceived == nil {
+		t.Fatalf("/connected: %+v, %v", connected, err)
+	}
+	var last Throughput
+	for i := 0; i < 2; i++ {
+		select {
+		case ev := <-s.C:
+			if err := json.Unmarshal(ev.Data, &last); err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("нет события throughput %d", i+1)
+		}
+	}
+	if len(last.Clients) != 1 {
+		t.Fatalf("клиенты: %+v", last.Clients)
+	}
+	cl := last.Clients[0]
+	if cl.RateReceived == nil || *cl.RateReceived != *connected[0].RateReceived || *cl.RateReceived != 1000 {
+		t.Errorf("rate_received %v, в /connected %v", cl.RateReceived, *connected[0].RateReceived)
+	}
+	if last.RateReceived != 1000 || last.RateSent != 100 {
+		t.Errorf("скорость инстанса %v/%v, ожидалось 1000/100", last.RateReceived, last.RateSent)
+	}
+}
@@ -0,0 +1,100 @@
+// Package geoip — определение страны по IP из CSV-базы диапазонов.
+package geoip
+
+import (
+	"bufio"
+	"fmt"
+	"net/netip"
+	"os"
+	"sort"
+	"strings"
+)
+
+type ipRange struct {
+	from, to netip.Addr
+	country  string
+}
+
+// DB диапазоны адресов, отсортированные по началу
+type DB struct {
+	ranges []ipRange
+}
+
+// Load читает CSV: "сеть/маска,страна" или "начало,конец,страна" (формат db-ip / ip2location lite).
+// Строки, которые не разбираются (заголовок, комментарии), пропускаются
+func Load(path string) (*DB, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	db := &DB{}
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if r, ok := parseLine(sc.Text()); ok {
+			db.ranges = append(db.ranges, r)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(db.ranges) == 0 {
+		return nil, fmt.Errorf("%s: нет ни одного диапазона", path)
+	}
+	sort.Slice(db.ranges, func(i, j int) bool { return db.ranges[i].from.Less(db.ranges[j].from) })
+	return db, nil
+}
+
+func parseLine(line string) (ipRange, bool) {
+	fields := strings.Split(line, ",")
+	for i := range fields {
+		fields[i] = strings.Trim(strings.TrimSpace(fields[i]), `"`)
+	}
+	switch len(fields) {
+	case 2:
+		p, err := netip.ParsePrefix(fields[0])
+		if err != nil || fields[1] == "" {
+			return ipRange{}, false
+		}
+		p = p.Masked()
+		return ipRange{from: p.Addr(), to: lastAddr(p), country: strings.ToUpper(fields[1])}, true
+	case 3:
+		from, err1 := netip.ParseAddr(fields[0])
+		to, err2 := netip.ParseAddr(fields[1])
+		if err1 != nil || err2 != nil || fields[2] == "" || to.Less(from) {
+			return ipRange{}, false
+		}
+		return ipRange{from: from.Unmap(), to: to.Unmap(), country: strings.ToUpper(fields[2])}, true
+	}
+	return ipRange{}, false
+}
+
+// lastAddr последний адрес подсети
+func lastAddr(p netip.Prefix) netip.Addr {
+	b := p.Addr().AsSlice()
+	for i := p.Bits(); i < len(b)*8; i++ {
+		b[i/8] |= 1 << (7 - i%8)
+	}
+	a, _ := netip.AddrFromSlice(b)
+	return a
+}
+
+// Country код страны для ip или "" если адрес не найден
+func (db *DB) Country(ip netip.Addr) string {
+	if db == nil || !ip.IsValid() {
+		return ""
+	}
+	ip = ip.Unmap()
+	// Последний диапазон, начинающийся не позже ip
+	i := sort.Search(len(db.ranges), func(i int) bool { return ip.Less(db.ranges[i].from) }) - 1
+	if i >= 0 && !db.ranges[i].to.Less(ip) {
+		return db.ranges[i].country
+	}
+	return ""
+}
+
+// Len число диапазонов
+func (db *DB) Len() int {
+	return len(db.ranges)
+}
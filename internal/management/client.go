@@ -0,0 +1,301 @@
+// Package management — клиент management-интерфейса OpenVPN: команды (status 3, bytecount, kill, client-auth-nt)
+// и уведомления реального времени (>CLIENT, >BYTECOUNT_CLI).
+package management
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrClosed соединение с management-интерфейсом закрыто
+var ErrClosed = errors.New("management: соединение закрыто")
+
+// eventBuffer сколько уведомлений держится, пока их не забрали; сверх этого новые отбрасываются,
+// чтобы медленный потребитель не останавливал чтение ответов на команды
+const eventBuffer = 1024
+
+// Client соединение с management-интерфейсом. Команды выполняются по одной
+type Client struct {
+	conn net.Conn
+
+	cmdMu  sync.Mutex
+	lines  chan string // строки ответов на команды
+	events chan Event
+	done   chan struct{}
+
+	mu      sync.Mutex
+	err     error
+	dropped int64
+}
+
+// Dial подключается к management-интерфейсу. addr — "tcp://host:port", "unix:///path/to/socket",
+// "host:port" или путь к unix-сокету. password — пароль из management-директивы (пусто — без пароля)
+func Dial(ctx context.Context, addr, password string) (*Client, error) {
+	network, address := ParseAddr(addr)
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	r := bufio.NewReader(conn)
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetReadDeadline(deadline)
+	}
+	if password != "" {
+		if err := login(conn, r, password); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	c := &Client{
+		conn:   conn,
+		lines:  make(chan string, 64),
+		events: make(chan Event, eventBuffer),
+		done:   make(chan struct{}),
+	}
+	go c.readLoop(r)
+	return c, nil
+}
+
+// ParseAddr разбирает адрес management-интерфейса в сеть и адрес для net.Dial
+func ParseAddr(addr string) (network, address string) {
+	switch {
+	case strings.HasPrefix(addr, "tcp://"):
+		return "tcp", strings.TrimPrefix(addr, "tcp://")
+	case strings.HasPrefix(addr, "unix://"):
+		return "unix", strings.TrimPrefix(addr, "unix://")
+	case strings.HasPrefix(addr, "/"):
+		return "unix", addr
+	default:
+		return "tcp", addr
+	}
+}
+
+// login отвечает на приглашение "ENTER PASSWORD:" (оно приходит без перевода строки)
+func login(conn net.Conn, r *bufio.Reader, password string) error {
+	const prompt = "ENTER PASSWORD:"
+	var buf []byte
+	for !strings.HasSuffix(string(buf), prompt) {
+		b, err := r.ReadByte()
+		if err != nil {
+			return fmt.Errorf("management: ожидание запроса пароля: %w", err)
+		}
+		buf = append(buf, b)
+		if len(buf) > 4096 {
+			return fmt.Errorf("management: нет запроса пароля")
+		}
+	}
+	if _, err := io.WriteString(conn, password+"\n"); err != nil {
+		return err
+	}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return fmt.Errorf("management: проверка пароля: %w", err)
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "":
+			continue
+		case strings.HasPrefix(line, "SUCCESS:"):
+			return nil
+		default:
+			return fmt.Errorf("management: неверный пароль")
+		}
+	}
+}
+
+func (c *Client) readLoop(r *bufio.Reader) {
+	defer close(c.done)
+	var pending *Event // уведомление >CLIENT, для которого ещё идут строки ENV
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			c.setErr(err)
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if !strings.HasPrefix(line, ">") {
+			select {
+			case c.lines <- line:
+			case <-time.After(time.Minute):
+				// Ответ никто не читает (команда отменена) — не блокируем уведомления
+			}
+			continue
+		}
+		if pending != nil {
+			if env, ok := strings.CutPrefix(line, ">CLIENT:ENV,"); ok {
+				if env == "END" {
+					c.emit(*pending)
+					pending = nil
+				} else if k, v, ok := strings.Cut(env, "="); ok {
+					pending.Env[k] = v
+				}
+				continue
+			}
+			// Блок ENV оборвался — отдаём то, что есть
+			c.emit(*pending)
+			pending = nil
+		}
+		ev, ok := parseNotification(line)
+		if !ok {
+			continue
+		}
+		if ev.Env != nil {
+			pending = &ev
+			continue
+		}
+		c.emit(ev)
+	}
+}
+
+func (c *Client) emit(ev Event) {
+	select {
+	case c.events <- ev:
+	default:
+		c.mu.Lock()
+		c.dropped++
+		c.mu.Unlock()
+	}
+}
+
+func (c *Client) setErr(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err == nil {
+		c.err = err
+	}
+}
+
+// Err причина закрытия соединения
+func (c *Client) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err == nil {
+		return nil
+	}
+	if errors.Is(c.err, io.EOF) || errors.Is(c.err, net.ErrClosed) {
+		return ErrClosed
+	}
+	return c.err
+}
+
+// Dropped сколько уведомлений отброшено из-за переполнения буфера
+func (c *Client) Dropped() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.dropped
+}
+
+// Events уведомления реального времени
+func (c *Client) Events() <-chan Event {
+	return c.events
+}
+
+// Done закрывается, когда соединение разорвано
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Close закрывает соединение
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// Command выполняет команду с однострочным ответом SUCCESS:/ERROR: и возвращает текст после префикса
+func (c *Client) Command(ctx context.Context, cmd string) (string, error) {
+	var result string
+	err := c.exec(ctx, cmd, func(line string) (bool, error) {
+		if msg, ok := strings.CutPrefix(line, "SUCCESS:"); ok {
+			result = strings.TrimSpace(msg)
+			return true, nil
+		}
+		if msg, ok := strings.CutPrefix(line, "ERROR:"); ok {
+			return true, fmt.Errorf("management: %s", strings.TrimSpace(msg))
+		}
+		return false, nil
+	})
+	return result, err
+}
+
+// Status выполняет "status 3" и возвращает вывод в формате status-version 3
+func (c *Client) Status(ctx context.Context) ([]byte, error) {
+	var b strings.Builder
+	err := c.exec(ctx, "status 3", func(line string) (bool, error) {
+		if msg, ok := strings.CutPrefix(line, "ERROR:"); ok {
+			return true, fmt.Errorf("management: %s", strings.TrimSpace(msg))
+		}
+		if line == "END" {
+			return true, nil
+		}
+		b.WriteString(line)
+		b.WriteByte('\n')
+		return false, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return []byte(b.String()), nil
+}
+
+// exec отправляет команду и передаёт строки ответа в handle, пока тот не вернёт done
+func (c *Client) exec(ctx context.Context, cmd string, handle func(line string) (done bool, err error)) error {
+	c.cmdMu.Lock()
+	defer c.cmdMu.Unlock()
+	// Остатки ответа на прерванную команду
+	for drained := false; !drained; {
+		select {
+		case <-c.lines:
+		default:
+			drained = true
+		}
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		c.conn.SetWriteDeadline(deadline)
+		defer c.conn.SetWriteDeadline(time.Time{})
+	}
+	if _, err := io.WriteString(c.conn, cmd+"\n"); err != nil {
+		return err
+	}
+	for {
+		select {
+		case line := <-c.lines:
+			done, err := handle(line)
+			if done || err != nil {
+				return err
+			}
+		case <-c.done:
+			if err := c.Err(); err != nil {
+				return err
+			}
+			return ErrClosed
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Kill отключает клиента: target — Common Name (все его сессии) или реальный адрес ip:port.
+// Возвращает ответ OpenVPN, например "common name 'alice' found, 1 client(s) killed"
+func (c *Client) Kill(ctx context.Context, target string) (string, error) {
+	if target == "" || strings.ContainsAny(target, "\r\n") {
+		return "", fmt.Errorf("management: недопустимая цель kill %q", target)
+	}
+	return c.Command(ctx, "kill "+target)
+}
+
+// ClientAuthNT разрешает подключение клиента, ожидающего авторизации (management-client-auth),
+// без изменения его конфигурации: ответ на >CLIENT:CONNECT и >CLIENT:REAUTH
+func (c *Client) ClientAuthNT(ctx context.Context, clientID, keyID int64) error {
+	_, err := c.Command(ctx, fmt.Sprintf("client-auth-nt %d %d", clientID, keyID))
+	return err
+}
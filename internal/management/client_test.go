@@ -0,0 +1,259 @@
+package management
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeServer management-интерфейс OpenVPN для тестов: одно соединение, ответы на команды
+// задаёт handle, уведомления отправляет push
+type fakeServer struct {
+	t        *testing.T
+	ln       net.Listener
+	password string
+	handle   func(cmd string) []string
+
+	mu    sync.Mutex
+	conn  net.Conn
+	ready chan struct{} // вход выполнен
+}
+
+func newFakeServer(t *testing.T, password string, handle func(cmd string) []string) *fakeServer {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeServer{t: t, ln: ln, password: password, handle: handle, ready: make(chan struct{})}
+	t.Cleanup(func() {
+		ln.Close()
+		s.mu.Lock()
+		if s.conn != nil {
+			s.conn.Close()
+		}
+		s.mu.Unlock()
+	})
+	go s.serve()
+	return s
+}
+
+func (s *fakeServer) addr() string {
+	return "tcp://" + s.ln.Addr().String()
+}
+
+func (s *fakeServer) serve() {
+	conn, err := s.ln.Accept()
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	s.conn = conn
+	s.mu.Unlock()
+	r := bufio.NewReader(conn)
+	if s.password != "" {
+		s.write("ENTER PASSWORD:")
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		if strings.TrimSpace(line) != s.password {
+			s.write("ERROR: bad password\n")
+			conn.Close()
+			return
+		}
+		s.write("SUCCESS: password is correct\n")
+	}
+	s.write(">INFO:OpenVPN Management Interface Version 5 -- type 'help' for more info\n")
+	close(s.ready)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		for _, out := range s.handle(strings.TrimSpace(line)) {
+			s.write(out + "\n")
+		}
+	}
+}
+
+func (s *fakeServer) write(data string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	io.WriteString(s.conn, data)
+}
+
+// push отправляет уведомления после входа
+func (s *fakeServer) push(lines ...string) {
+	s.t.Helper()
+	select {
+	case <-s.ready:
+	case <-time.After(5 * time.Second):
+		s.t.Fatal("клиент не подключился")
+	}
+	for _, l := range lines {
+		s.write(l + "\n")
+	}
+}
+
+func dial(t *testing.T, s *fakeServer, password string) *Client {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c, err := Dial(ctx, s.addr(), password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func nextEvent(t *testing.T, c *Client) Event {
+	t.Helper()
+	select {
+	case ev := <-c.Events():
+		return ev
+	case <-time.After(5 * time.Second):
+		t.Fatal("нет уведомления")
+	}
+	return Event{}
+}
+
+func TestDialPassword(t *testing.T) {
+	s := newFakeServer(t, "secret", func(string) []string { return nil })
+	dial(t, s, "secret")
+
+	s = newFakeServer(t, "secret", func(string) []string { return nil })
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if c, err := Dial(ctx, s.addr(), "wrong"); err == nil {
+		c.Close()
+		t.Fatal("вход с неверным паролем")
+	}
+}
+
+func TestStatus(t *testing.T) {
+	s := newFakeServer(t, "", func(cmd string) []string {
+		if cmd != "status 3" {
+			return []string{"ERROR: unknown command"}
+		}
+		return []string{
+			"TITLE\tOpenVPN 2.6.8",
+			"TIME\tTue Feb 23 12:00:00 2024\t1708689600",
+			// Уведомление посреди ответа не попадает в вывод status
+			">BYTECOUNT_CLI:7,1000,100",
+			"HEADER\tCLIENT_LIST\tCommon Name\tReal Address\tBytes Received\tBytes Sent\tClient ID",
+			"CLIENT_LIST\talice\t1.2.3.4:5555\t1000\t100\t7",
+			"END",
+		}
+	})
+	c := dial(t, s, "")
+	out, err := c.Status(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(out), "BYTECOUNT") || strings.Contains(string(out), "END") {
+		t.Errorf("вывод status 3:\n%s", out)
+	}
+	if !strings.Contains(string(out), "CLIENT_LIST\talice") {
+		t.Errorf("в выводе нет клиента:\n%s", out)
+	}
+	ev := nextEvent(t, c)
+	if ev.Type != EventBytecount || ev.ClientID != 7 || ev.BytesIn != 1000 || ev.BytesOut != 100 {
+		t.Errorf("уведомление %+v", ev)
+	}
+
+	if _, err := c.Command(context.Background(), "bogus"); err == nil || !strings.Contains(err.Error(), "unknown command") {
+		t.Errorf("ошибка команды: %v", err)
+	}
+}
+
+func TestClientEnvEvents(t *testing.T) {
+	s := newFakeServer(t, "", func(string) []string { return nil })
+	c := dial(t, s, "")
+	s.push(
+		">CLIENT:CONNECT,5,1",
+		">CLIENT:ENV,common_name=alice",
+		">CLIENT:ENV,trusted_ip=1.2.3.4",
+		">CLIENT:ENV,trusted_port=5555",
+		">CLIENT:ENV,time_unix=1708689600",
+		">CLIENT:ENV,END",
+		// Блок ENV, оборванный другим уведомлением, отдаётся как есть
+		">CLIENT:DISCONNECT,5",
+		">CLIENT:ENV,bytes_received=1500",
+		">BYTECOUNT_CLI:6,10,20",
+		">CLIENT:ADDRESS,5,10.8.0.2,1",
+	)
+
+	ev := nextEvent(t, c)
+	if ev.Type != EventConnect || ev.ClientID != 5 || ev.KeyID != 1 {
+		t.Fatalf("уведомление %+v", ev)
+	}
+	if ev.CommonName() != "alice" || ev.RealAddress() != "1.2.3.4:5555" || !ev.ConnectedSince().Equal(time.Unix(1708689600, 0)) {
+		t.Errorf("ENV %v", ev.Env)
+	}
+
+	ev = nextEvent(t, c)
+	if ev.Type != EventDisconnect || ev.ClientID != 5 || ev.Env["bytes_received"] != "1500" {
+		t.Errorf("уведомление %+v", ev)
+	}
+	if _, _, ok := ev.Bytes(); ok {
+		t.Error("без bytes_sent итоговых счётчиков нет")
+	}
+	if ev = nextEvent(t, c); ev.Type != EventBytecount || ev.ClientID != 6 {
+		t.Errorf("уведомление %+v", ev)
+	}
+	// >CLIENT:ADDRESS не нужен и отбрасывается
+	select {
+	case ev := <-c.Events():
+		t.Errorf("лишнее уведомление %+v", ev)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestKill(t *testing.T) {
+	s := newFakeServer(t, "", func(cmd string) []string {
+		switch cmd {
+		case "kill alice":
+			return []string{"SUCCESS: common name 'alice' found, 1 client(s) killed"}
+		case "kill 1.2.3.4:5555":
+			return []string{"SUCCESS: 1 client(s) at address 1.2.3.4:5555 killed"}
+		}
+		return []string{"ERROR: common name '" + strings.TrimPrefix(cmd, "kill ") + "' not found"}
+	})
+	c := dial(t, s, "")
+	ctx := context.Background()
+
+	msg, err := c.Kill(ctx, "alice")
+	if err != nil || msg != "common name 'alice' found, 1 client(s) killed" {
+		t.Errorf("kill alice: %q, %v", msg, err)
+	}
+	if _, err := c.Kill(ctx, "1.2.3.4:5555"); err != nil {
+		t.Errorf("kill по адресу: %v", err)
+	}
+	if _, err := c.Kill(ctx, "bob"); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("kill bob: %v", err)
+	}
+	if _, err := c.Kill(ctx, "alice\nsignal SIGTERM"); err == nil {
+		t.Error("цель с переводом строки принята")
+	}
+}
+
+func TestParseAddr(t *testing.T) {
+	tests := []struct{ addr, network, address string }{
+		{"tcp://127.0.0.1:7505", "tcp", "127.0.0.1:7505"},
+		{"127.0.0.1:7505", "tcp", "127.0.0.1:7505"},
+		{"unix:///run/openvpn/mgmt.sock", "unix", "/run/openvpn/mgmt.sock"},
+		{"/run/openvpn/mgmt.sock", "unix", "/run/openvpn/mgmt.sock"},
+	}
+	for _, tt := range tests {
+		if network, address := ParseAddr(tt.addr); network != tt.network || address != tt.address {
+			t.Errorf("ParseAddr(%q) = %s %s", tt.addr, network, address)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package management
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Типы уведомлений
+const (
+	EventConnect     = "CONNECT"
+	EventReauth      = "REAUTH"
+	EventEstablished = "ESTABLISHED"
+	EventDisconnect  = "DISCONNECT"
+	EventBytecount   = "BYTECOUNT"
+)
+
+// Event уведомление реального времени: >CLIENT:<тип>,<cid>[,<kid>] с блоком ENV
+// или >BYTECOUNT_CLI:<cid>,<bytes in>,<bytes out>
+type Event struct {
+	Type     string
+	ClientID int64
+	KeyID    int64             // для CONNECT и REAUTH: нужен в ответе client-auth-nt
+	Env      map[string]string // переменные окружения клиента (для >CLIENT)
+	BytesIn  int64             // для BYTECOUNT: получено от клиента
+	BytesOut int64             // для BYTECOUNT: отправлено клиенту
+}
+
+func parseNotification(line string) (Event, bool) {
+	if rest, ok := strings.CutPrefix(line, ">BYTECOUNT_CLI:"); ok {
+		f := strings.Split(rest, ",")
+		if len(f) != 3 {
+			return Event{}, false
+		}
+		ev := Event{Type: EventBytecount}
+		var err1, err2, err3 error
+		ev.ClientID, err1 = strconv.ParseInt(f[0], 10, 64)
+		ev.BytesIn, err2 = strconv.ParseInt(f[1], 10, 64)
+		ev.BytesOut, err3 = strconv.ParseInt(f[2], 10, 64)
+		if err1 != nil || err2 != nil || err3 != nil {
+			return Event{}, false
+		}
+		return ev, true
+	}
+	if rest, ok := strings.CutPrefix(line, ">CLIENT:"); ok {
+		typ, args, _ := strings.Cut(rest, ",")
+		switch typ {
+		case EventConnect, EventReauth, EventEstablished, EventDisconnect:
+		default:
+			return Event{}, false // ADDRESS, CR_RESPONSE и прочие не нужны
+		}
+		cid, kid, _ := strings.Cut(args, ",")
+		id, err := strconv.ParseInt(cid, 10, 64)
+		if err != nil {
+			return Event{}, false
+		}
+		ev := Event{Type: typ, ClientID: id, Env: make(map[string]string)}
+		if typ == EventConnect || typ == EventReauth {
+			if ev.KeyID, err = strconv.ParseInt(kid, 10, 64); err != nil {
+				return Event{}, false
+			}
+		}
+		return ev, true
+	}
+	return Event{}, false
+}
+
+// CommonName common_name из ENV
+func (e Event) CommonName() string {
+	return e.Env["common_name"]
+}
+
+// RealAddress адрес клиента в том же виде, что в status-файле: ip:port
+func (e Event) RealAddress() string {
+	ip := e.Env["trusted_ip"]
+	if ip == "" {
+		ip = e.Env["trusted_ip6"]
+	}
+	if ip == "" {
+		return ""
+	}
+	if port := e.Env["trusted_port"]; port != "" {
+		return ip + ":" + port
+	}
+	return ip
+}
+
+// ConnectedSince время подключения (time_unix), zero — нет в ENV
+func (e Event) ConnectedSince() time.Time {
+	return envUnix(e.Env, "time_unix")
+}
+
+// Bytes итоговые счётчики из ENV при отключении
+func (e Event) Bytes() (received, sent int64, ok bool) {
+	r, err1 := strconv.ParseInt(e.Env["bytes_received"], 10, 64)
+	s, err2 := strconv.ParseInt(e.Env["bytes_sent"], 10, 64)
+	return r, s, err1 == nil && err2 == nil
+}
+
+func envUnix(env map[string]string, key string) time.Time {
+	sec, err := strconv.ParseInt(env[key], 10, 64)
+	if err != nil || sec <= 0 {
+		return time.Time{}
+	}
+	return time.Unix(sec, 0).UTC()
+}
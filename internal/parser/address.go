@@ -0,0 +1,29 @@
+package parser
+
+import (
+	"net/netip"
+	"strings"
+)
+
+// RealIP IP-адрес клиента из колонки Real Address: "1.2.3.4:5555", "udp4:1.2.3.4:5555",
+// "[2001:db8::1]:1194", "2001:db8::1" или адрес без порта
+func RealIP(addr string) (netip.Addr, bool) {
+	addr = strings.TrimSpace(addr)
+	// Префикс протокола (udp4:, tcp4-server:, udp6:) у адресов из OpenVPN 2.5+
+	if proto, rest, ok := strings.Cut(addr, ":"); ok && (strings.HasPrefix(proto, "udp") || strings.HasPrefix(proto, "tcp")) {
+		addr = rest
+	}
+	if ap, err := netip.ParseAddrPort(addr); err == nil {
+		return ap.Addr().Unmap(), true
+	}
+	if a, err := netip.ParseAddr(addr); err == nil {
+		return a.Unmap(), true
+	}
+	// IPv6 с портом без скобок не отличить от адреса без порта; пробуем отрезать порт
+	if i := strings.LastIndexByte(addr, ':'); i > 0 {
+		if a, err := netip.ParseAddr(addr[:i]); err == nil {
+			return a.Unmap(), true
+		}
+	}
+	return netip.Addr{}, false
+}
@@ -11,18 +11,34 @@ import (
 
 // Client представляет подключённого VPN-клиента
 type Client struct {
-	CommonName    string    `json:"common_name"`
-	RealAddress   string    `json:"real_address"`
-	VirtualAddr   string    `json:"virtual_address,omitempty"`
-	BytesReceived int64     `json:"bytes_received"`
-	BytesSent     int64     `json:"bytes_sent"`
+	CommonName     string    `json:"common_name"`
+	RealAddress    string    `json:"real_address"`
+	VirtualAddr    string    `json:"virtual_address,omitempty"`
+	VirtualIPv6    string    `json:"virtual_ipv6_address,omitempty"`
+	BytesReceived  int64     `json:"bytes_received"`
+	BytesSent      int64     `json:"bytes_sent"`
 	ConnectedSince time.Time `json:"connected_since"`
+	// Колонки status-version 2/3; пустые (nil), если в файле их нет
+	Username string `json:"username,omitempty"`
+	ClientID *int64 `json:"client_id,omitempty"`
+	PeerID   *int64 `json:"peer_id,omitempty"`
+	Cipher   string `json:"cipher,omitempty"`
+}
+
+// Route строка из секции ROUTING TABLE: какой клиент владеет адресом/подсетью (iroute)
+type Route struct {
+	VirtualAddr string    `json:"virtual_address"`
+	CommonName  string    `json:"common_name"`
+	RealAddress string    `json:"real_address"`
+	LastRef     time.Time `json:"last_ref"`
 }
 
 // Status содержит распарсенные данные из status-файла OpenVPN
 type Status struct {
-	UpdatedAt   time.Time `json:"updated_at"`
-	Clients     []Client  `json:"clients"`
+	Version     int          `json:"version"`
+	UpdatedAt   time.Time    `json:"updated_at"` // zero — в файле нет времени обновления
+	Clients     []Client     `json:"clients"`
+	Routes      []Route      `json:"routes"`
 	GlobalStats *GlobalStats `json:"global_stats,omitempty"`
 }
 
@@ -34,6 +50,10 @@ type GlobalStats struct {
 var timeFormat = "Mon Jan 2 15:04:05 2006"
 var timeFormatSpace = "Mon Jan  2 15:04:05 2006"
 
+// Location часовой пояс строкового времени OpenVPN: сервер пишет его по своим локальным часам
+// без зоны. Важно для status-version 1, где рядом нет time_t
+var Location = time.Local
+
 // ParseFile читает и парсит OpenVPN status-файл
 func ParseFile(path string) (*Status, error) {
 	data, err := os.ReadFile(path)
@@ -43,14 +63,71 @@ func ParseFile(path string) (*Status, error) {
 	return ParseBytes(data)
 }
 
-// ParseBytes парсит содержимое status-файла без аллокации строки
+// ParseBytes парсит содержимое status-файла, версия формата (status-version 1, 2, 3) определяется автоматически.
+// Если в файле нет строки Updated/TIME, UpdatedAt остаётся нулевым — время подставляет вызывающий.
+// Пустое содержимое или файл, начинающийся не с заголовка (OpenVPN как раз переписывает его), —
+// не ошибка: возвращается пустой Status с Version 0, такой снимок сохранять нельзя
 func ParseBytes(data []byte) (*Status, error) {
-	s := &Status{Clients: make([]Client, 0, 16)}
-	lines := bytes.Split(data, []byte{'\n'})
-	var inClientList bool
-	var clientColumns []string
+	switch detectVersion(data) {
+	case 1:
+		return parseV1(data), nil
+	case 2:
+		return parseTagged(data, ','), nil
+	case 3:
+		return parseTagged(data, '\t'), nil
+	}
+	return &Status{Clients: make([]Client, 0), Routes: make([]Route, 0)}, nil
+}
+
+// Incomplete содержимое не распознано как status-файл (пустое или недописанное)
+func (s *Status) Incomplete() bool {
+	return s.Version == 0
+}
+
+// Parse — алиас для совместимости
+func Parse(content string) (*Status, error) {
+	return ParseBytes([]byte(content))
+}
+
+// detectVersion определяет status-version по первой значимой строке.
+// Версии 2 и 3 отличаются только разделителем (запятая / табуляция).
+func detectVersion(data []byte) int {
+	for _, raw := range bytes.Split(data, []byte{'\n'}) {
+		line := bytes.TrimSpace(raw)
+		if len(line) == 0 {
+			continue
+		}
+		if bytes.HasPrefix(bytes.ToUpper(line), []byte("OPENVPN CLIENT LIST")) {
+			return 1
+		}
+		tag, _, found := bytes.Cut(line, []byte{'\t'})
+		if found && isV2Tag(string(tag)) {
+			return 3
+		}
+		tag, _, found = bytes.Cut(line, []byte{','})
+		if found && isV2Tag(string(tag)) {
+			return 2
+		}
+		return 0
+	}
+	return 0
+}
+
+func isV2Tag(tag string) bool {
+	switch tag {
+	case "TITLE", "TIME", "HEADER", "CLIENT_LIST", "ROUTING_TABLE", "GLOBAL_STATS":
+		return true
+	}
+	return false
+}
+
+// parseV1 разбирает формат status-version 1 ("OpenVPN CLIENT LIST" + секции)
+func parseV1(data []byte) *Status {
+	s := &Status{Version: 1, Clients: make([]Client, 0, 16), Routes: make([]Route, 0, 16)}
+	var inClientList, inRoutingTable bool
+	var clientColumns, routeColumns []string
 
-	for _, raw := range lines {
+	for _, raw := range bytes.Split(data, []byte{'\n'}) {
 		line := bytes.TrimSpace(raw)
 		if len(line) == 0 {
 			continue
@@ -63,6 +140,28 @@ func ParseBytes(data []byte) (*Status, error) {
 			continue
 		}
 
+		if bytes.HasPrefix(bytes.ToUpper(line), []byte("ROUTING TABLE")) {
+			inClientList = false
+			inRoutingTable = true
+			routeColumns = nil
+			continue
+		}
+
+		if inRoutingTable {
+			if bytes.HasPrefix(bytes.ToUpper(line), []byte("GLOBAL STATS")) || bytes.Equal(line, []byte("END")) {
+				inRoutingTable = false
+			} else if bytes.Contains(line, []byte("Common Name")) {
+				routeColumns = parseColumns(strings.Split(lineStr, ","))
+				continue
+			} else if routeColumns != nil {
+				r := parseRouteLine(strings.Split(lineStr, ","), routeColumns)
+				if ValidCommonName(r.CommonName) {
+					s.Routes = append(s.Routes, r)
+				}
+				continue
+			}
+		}
+
 		if inClientList {
 			if bytes.HasPrefix(line, []byte("Updated,")) {
 				if _, t, ok := bytes.Cut(line, []byte(",")); ok {
@@ -74,20 +173,19 @@ func ParseBytes(data []byte) (*Status, error) {
 			}
 
 			if bytes.Contains(line, []byte("Common Name")) {
-				clientColumns = parseColumns(lineStr)
+				clientColumns = parseColumns(strings.Split(lineStr, ","))
 				continue
 			}
 
-			if bytes.HasPrefix(bytes.ToUpper(line), []byte("ROUTING TABLE")) ||
-				bytes.HasPrefix(bytes.ToUpper(line), []byte("GLOBAL STATS")) ||
+			if bytes.HasPrefix(bytes.ToUpper(line), []byte("GLOBAL STATS")) ||
 				bytes.Equal(line, []byte("END")) {
 				inClientList = false
 				continue
 			}
 
-			if clientColumns != nil && !bytes.HasPrefix(line, []byte("GLOBAL STATS")) {
-				c := parseClientLine(lineStr, clientColumns)
-				if isValidCommonName(c.CommonName) {
+			if clientColumns != nil {
+				c := parseClientLine(strings.Split(lineStr, ","), clientColumns)
+				if ValidCommonName(c.CommonName) {
 					s.Clients = append(s.Clients, c)
 				}
 			}
@@ -100,28 +198,84 @@ func ParseBytes(data []byte) (*Status, error) {
 			s.GlobalStats.MaxBcastMcastQueueLen, _ = strconv.Atoi(string(bytes.TrimSpace(val)))
 		}
 	}
+	return s
+}
 
-	if s.UpdatedAt.IsZero() {
-		s.UpdatedAt = time.Now().UTC()
+// parseTagged разбирает форматы status-version 2 и 3: каждая строка начинается с тега
+// (TIME, HEADER, CLIENT_LIST, ...), колонки задаются строкой HEADER
+func parseTagged(data []byte, sep byte) *Status {
+	s := &Status{Version: 2, Clients: make([]Client, 0, 16), Routes: make([]Route, 0, 16)}
+	if sep == '\t' {
+		s.Version = 3
 	}
-	return s, nil
-}
+	var clientColumns, routeColumns []string
 
-// Parse — алиас для совместимости
-func Parse(content string) (*Status, error) {
-	return ParseBytes([]byte(content))
+	for _, raw := range bytes.Split(data, []byte{'\n'}) {
+		line := bytes.TrimRight(raw, "\r")
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		fields := strings.Split(string(line), string(sep))
+		switch strings.TrimSpace(fields[0]) {
+		case "TIME":
+			// TIME,<строка>,<time_t>
+			if len(fields) > 2 {
+				if t, err := parseUnixTime(fields[2]); err == nil {
+					s.UpdatedAt = t
+					continue
+				}
+			}
+			if len(fields) > 1 {
+				if t, err := parseOpenVPNTime(fields[1]); err == nil {
+					s.UpdatedAt = t
+				}
+			}
+		case "HEADER":
+			if len(fields) > 2 {
+				switch strings.TrimSpace(fields[1]) {
+				case "CLIENT_LIST":
+					clientColumns = parseColumns(fields[2:])
+				case "ROUTING_TABLE":
+					routeColumns = parseColumns(fields[2:])
+				}
+			}
+		case "CLIENT_LIST":
+			if clientColumns != nil {
+				c := parseClientLine(fields[1:], clientColumns)
+				if ValidCommonName(c.CommonName) {
+					s.Clients = append(s.Clients, c)
+				}
+			}
+		case "ROUTING_TABLE":
+			if routeColumns != nil {
+				r := parseRouteLine(fields[1:], routeColumns)
+				if ValidCommonName(r.CommonName) {
+					s.Routes = append(s.Routes, r)
+				}
+			}
+		case "GLOBAL_STATS":
+			if s.GlobalStats == nil {
+				s.GlobalStats = &GlobalStats{}
+			}
+			if len(fields) > 2 && strings.Contains(fields[1], "Max bcast") {
+				s.GlobalStats.MaxBcastMcastQueueLen, _ = strconv.Atoi(strings.TrimSpace(fields[2]))
+			}
+		}
+	}
+	return s
 }
 
-func isValidCommonName(name string) bool {
-	if name == "" {
+// ValidCommonName CN принадлежит настоящему клиенту: пустой, UNDEF (клиент ещё не прошёл
+// авторизацию) и заглушки undefined/null не учитываются
+func ValidCommonName(name string) bool {
+	if name == "" || name == "UNDEF" {
 		return false
 	}
 	s := strings.ToLower(strings.TrimSpace(name))
 	return s != "undefined" && s != "null"
 }
 
-func parseColumns(line string) []string {
-	cols := strings.Split(line, ",")
+func parseColumns(cols []string) []string {
 	result := make([]string, 0, len(cols))
 	for _, c := range cols {
 		result = append(result, strings.TrimSpace(strings.ToLower(c)))
@@ -129,8 +283,7 @@ func parseColumns(line string) []string {
 	return result
 }
 
-func parseClientLine(line string, columns []string) Client {
-	values := strings.Split(line, ",")
+func parseClientLine(values []string, columns []string) Client {
 	c := Client{}
 	for i, col := range columns {
 		if i >= len(values) {
@@ -144,24 +297,87 @@ func parseClientLine(line string, columns []string) Client {
 			c.RealAddress = val
 		case "virtual address":
 			c.VirtualAddr = val
+		case "virtual ipv6 address":
+			c.VirtualIPv6 = val
+		case "username":
+			// OpenVPN пишет UNDEF, если клиент не передавал логин
+			if val != "UNDEF" {
+				c.Username = val
+			}
+		case "client id":
+			c.ClientID = parseOptionalInt(val)
+		case "peer id":
+			c.PeerID = parseOptionalInt(val)
+		case "data channel cipher":
+			c.Cipher = val
 		case "bytes received":
 			c.BytesReceived, _ = strconv.ParseInt(val, 10, 64)
 		case "bytes sent":
 			c.BytesSent, _ = strconv.ParseInt(val, 10, 64)
 		case "connected since":
-			c.ConnectedSince, _ = parseOpenVPNTime(val)
+			if c.ConnectedSince.IsZero() {
+				c.ConnectedSince, _ = parseOpenVPNTime(val)
+			}
+		case "connected since (time_t)":
+			// time_t точнее строкового представления (не зависит от часового пояса сервера)
+			if t, err := parseUnixTime(val); err == nil {
+				c.ConnectedSince = t
+			}
 		}
 	}
 	return c
 }
 
+func parseOptionalInt(s string) *int64 {
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return nil
+	}
+	return &n
+}
+
+func parseRouteLine(values []string, columns []string) Route {
+	r := Route{}
+	for i, col := range columns {
+		if i >= len(values) {
+			break
+		}
+		val := strings.TrimSpace(values[i])
+		switch col {
+		case "virtual address":
+			r.VirtualAddr = val
+		case "common name":
+			r.CommonName = val
+		case "real address":
+			r.RealAddress = val
+		case "last ref":
+			if r.LastRef.IsZero() {
+				r.LastRef, _ = parseOpenVPNTime(val)
+			}
+		case "last ref (time_t)":
+			if t, err := parseUnixTime(val); err == nil {
+				r.LastRef = t
+			}
+		}
+	}
+	return r
+}
+
 func parseOpenVPNTime(s string) (time.Time, error) {
 	s = strings.TrimSpace(s)
-	if t, err := time.Parse(timeFormat, s); err == nil {
+	if t, err := time.ParseInLocation(timeFormat, s, Location); err == nil {
 		return t.UTC(), nil
 	}
-	if t, err := time.Parse(timeFormatSpace, s); err == nil {
+	if t, err := time.ParseInLocation(timeFormatSpace, s, Location); err == nil {
 		return t.UTC(), nil
 	}
 	return time.Time{}, fmt.Errorf("неизвестный формат: %s", s)
 }
+
+func parseUnixTime(s string) (time.Time, error) {
+	sec, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	if err != nil || sec <= 0 {
+		return time.Time{}, fmt.Errorf("неизвестный формат: %s", s)
+	}
+	return time.Unix(sec, 0).UTC(), nil
+}
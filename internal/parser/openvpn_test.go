@@ -0,0 +1,198 @@
+package parser
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func readSample(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("..", "..", "config", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// inLocation задаёт часовой пояс строкового времени на время теста
+func inLocation(t *testing.T, loc *time.Location) {
+	old := Location
+	Location = loc
+	t.Cleanup(func() { Location = old })
+}
+
+func TestParseSamples(t *testing.T) {
+	inLocation(t, time.UTC)
+	updated := time.Date(2024, 2, 23, 12, 0, 0, 0, time.UTC)
+	since1 := time.Date(2024, 2, 23, 11, 30, 0, 0, time.UTC)
+	since2 := time.Date(2024, 2, 23, 11, 45, 0, 0, time.UTC)
+
+	tests := []struct {
+		file     string
+		version  int
+		extended bool // колонки status-version 2/3: IPv6, Username, Client ID, Peer ID, Cipher
+	}{
+		{"openvpn-status.sample", 1, false},
+		{"openvpn-status-v2.sample", 2, true},
+		{"openvpn-status-v3.sample", 3, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			s, err := ParseBytes(readSample(t, tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s.Version != tt.version {
+				t.Errorf("Version = %d, ожидалось %d", s.Version, tt.version)
+			}
+			if !s.UpdatedAt.Equal(updated) {
+				t.Errorf("UpdatedAt = %v, ожидалось %v", s.UpdatedAt, updated)
+			}
+			if s.GlobalStats == nil {
+				t.Error("нет GLOBAL STATS")
+			}
+
+			if len(s.Clients) != 2 {
+				t.Fatalf("клиентов %d, ожидалось 2", len(s.Clients))
+			}
+			c1, c2 := s.Clients[0], s.Clients[1]
+			if c1.CommonName != "user1" || c1.RealAddress != "192.168.1.100:51234" || c1.VirtualAddr != "10.8.0.2" {
+				t.Errorf("user1: %+v", c1)
+			}
+			if c1.BytesReceived != 15728640 || c1.BytesSent != 8388608 {
+				t.Errorf("user1 байты: %d/%d", c1.BytesReceived, c1.BytesSent)
+			}
+			if !c1.ConnectedSince.Equal(since1) || !c2.ConnectedSince.Equal(since2) {
+				t.Errorf("ConnectedSince = %v, %v", c1.ConnectedSince, c2.ConnectedSince)
+			}
+
+			if tt.extended {
+				if c1.Username != "alice" || c1.VirtualIPv6 != "fdde:1234::1000" || c1.Cipher != "AES-256-GCM" {
+					t.Errorf("user1 расширенные колонки: %+v", c1)
+				}
+				if c1.ClientID == nil || *c1.ClientID != 0 || c2.PeerID == nil || *c2.PeerID != 1 {
+					t.Errorf("Client ID/Peer ID: %v %v", c1.ClientID, c2.PeerID)
+				}
+				// UNDEF — клиент не передавал логин
+				if c2.Username != "" {
+					t.Errorf("user2 Username = %q, ожидалось пусто вместо UNDEF", c2.Username)
+				}
+			} else if c1.Username != "" || c1.ClientID != nil || c1.PeerID != nil {
+				t.Errorf("в status-version 1 нет расширенных колонок: %+v", c1)
+			}
+
+			wantRoutes := []Route{
+				{VirtualAddr: "10.8.0.2", CommonName: "user1", RealAddress: "192.168.1.100:51234", LastRef: updated},
+				{VirtualAddr: "192.168.50.0/24", CommonName: "user1", RealAddress: "192.168.1.100:51234", LastRef: updated.Add(-2 * time.Minute)},
+				{VirtualAddr: "10.8.0.3", CommonName: "user2", RealAddress: "192.168.1.101:51235", LastRef: updated},
+			}
+			if len(s.Routes) != len(wantRoutes) {
+				t.Fatalf("маршрутов %d, ожидалось %d", len(s.Routes), len(wantRoutes))
+			}
+			for i, want := range wantRoutes {
+				got := s.Routes[i]
+				if got.VirtualAddr != want.VirtualAddr || got.CommonName != want.CommonName ||
+					got.RealAddress != want.RealAddress || !got.LastRef.Equal(want.LastRef) {
+					t.Errorf("маршрут %d = %+v, ожидалось %+v", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseTimeTPreferred(t *testing.T) {
+	// Строковое время и time_t расходятся: берётся time_t, он не зависит от часового пояса сервера
+	data := "TIME,Tue Feb 23 15:00:00 2024,1708689600\n" +
+		"HEADER,CLIENT_LIST,Common Name,Real Address,Virtual Address,Bytes Received,Bytes Sent,Connected Since,Connected Since (time_t)\n" +
+		"CLIENT_LIST,user1,192.168.1.100:51234,10.8.0.2,1,2,Tue Feb 23 14:30:00 2024,1708687800\n" +
+		"HEADER,ROUTING_TABLE,Virtual Address,Common Name,Real Address,Last Ref,Last Ref (time_t)\n" +
+		"ROUTING_TABLE,10.8.0.2,user1,192.168.1.100:51234,Tue Feb 23 15:00:00 2024,1708689600\n" +
+		"END\n"
+	s, err := ParseBytes([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Unix(1708689600, 0); !s.UpdatedAt.Equal(want) {
+		t.Errorf("UpdatedAt = %v, ожидалось %v", s.UpdatedAt, want)
+	}
+	if want := time.Unix(1708687800, 0); len(s.Clients) != 1 || !s.Clients[0].ConnectedSince.Equal(want) {
+		t.Errorf("ConnectedSince: %+v", s.Clients)
+	}
+	if want := time.Unix(1708689600, 0); len(s.Routes) != 1 || !s.Routes[0].LastRef.Equal(want) {
+		t.Errorf("LastRef: %+v", s.Routes)
+	}
+}
+
+func TestParseLocalTime(t *testing.T) {
+	// В status-version 1 только строковое время — оно в часовом поясе сервера OpenVPN
+	inLocation(t, time.FixedZone("UTC-5", -5*3600))
+	s, err := ParseBytes(readSample(t, "openvpn-status.sample"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2024, 2, 23, 17, 0, 0, 0, time.UTC); !s.UpdatedAt.Equal(want) {
+		t.Errorf("UpdatedAt = %v, ожидалось %v", s.UpdatedAt, want)
+	}
+	if want := time.Date(2024, 2, 23, 16, 30, 0, 0, time.UTC); len(s.Clients) == 0 || !s.Clients[0].ConnectedSince.Equal(want) {
+		t.Errorf("ConnectedSince: %+v", s.Clients)
+	}
+
+	// time_t в status-version 2/3 от часового пояса не зависит
+	s, err = ParseBytes(readSample(t, "openvpn-status-v2.sample"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2024, 2, 23, 12, 0, 0, 0, time.UTC); !s.UpdatedAt.Equal(want) {
+		t.Errorf("v2 UpdatedAt = %v, ожидалось %v", s.UpdatedAt, want)
+	}
+}
+
+func TestParseSkipsInvalidNames(t *testing.T) {
+	data := "OpenVPN CLIENT LIST\n" +
+		"Updated,Tue Feb 23 12:00:00 2024\n" +
+		"Common Name,Real Address,Bytes Received,Bytes Sent,Connected Since\n" +
+		"user1,1.2.3.4:1000,1,1,Tue Feb 23 11:00:00 2024\n" +
+		",1.2.3.5:1000,1,1,Tue Feb 23 11:00:00 2024\n" +
+		"null,1.2.3.6:1000,1,1,Tue Feb 23 11:00:00 2024\n" +
+		"UNDEF,1.2.3.7:1000,1,1,Tue Feb 23 11:00:00 2024\n" +
+		"ROUTING TABLE\n" +
+		"Virtual Address,Common Name,Real Address,Last Ref\n" +
+		"10.8.0.9,undefined,1.2.3.6:1000,Tue Feb 23 12:00:00 2024\n" +
+		"END\n"
+	s, err := ParseBytes([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Clients) != 1 || s.Clients[0].CommonName != "user1" {
+		t.Errorf("клиенты: %+v", s.Clients)
+	}
+	if len(s.Routes) != 0 {
+		t.Errorf("маршруты: %+v", s.Routes)
+	}
+}
+
+func TestParseIncomplete(t *testing.T) {
+	full := string(readSample(t, "openvpn-status-v2.sample"))
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"пустой", ""},
+		{"только пробелы", "\n  \n"},
+		{"обрезанная первая строка", full[10:]},
+		{"мусор", "hello world\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := ParseBytes([]byte(tt.data))
+			if err != nil {
+				t.Fatalf("ошибка вместо пустого Status: %v", err)
+			}
+			if !s.Incomplete() || len(s.Clients) != 0 {
+				t.Errorf("ожидался пустой неполный Status: %+v", s)
+			}
+		})
+	}
+}
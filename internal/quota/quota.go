@@ -0,0 +1,133 @@
+// Package quota — проверка квот трафика после каждого снимка и действия при превышении.
+package quota
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"open-statistic/internal/collector"
+	"open-statistic/internal/database"
+	"open-statistic/internal/parser"
+	"open-statistic/internal/webhook"
+)
+
+// killInterval не чаще этого повторяется отключение одного клиента на инстансе:
+// пока OpenVPN не отключил клиента, он остаётся в снимках
+const killInterval = 30 * time.Second
+
+// Event тело webhook-а о превышении квоты
+type Event struct {
+	Event    string    `json:"event"` // quota_exceeded
+	Instance string    `json:"instance"`
+	At       time.Time `json:"at"`
+	*database.QuotaUsage
+}
+
+// Enforcer проверяет квоты подключённых клиентов после сохранения снимка
+type Enforcer struct {
+	db         *database.DB
+	collectors *collector.Registry
+	webhooks   *webhook.Sender
+
+	mu       sync.Mutex
+	lastKill map[string]time.Time // instance|common_name → время последнего kill
+}
+
+// New создаёт проверку квот. collectors нужен для действия disconnect
+func New(db *database.DB, collectors *collector.Registry, webhooks *webhook.Sender) *Enforcer {
+	return &Enforcer{db: db, collectors: collectors, webhooks: webhooks, lastKill: make(map[string]time.Time)}
+}
+
+// Check проверяет квоты клиентов снимка (collector.SaveHook). Действия log и webhook
+// выполняются один раз за период, disconnect — пока клиент остаётся подключённым
+func (e *Enforcer) Check(instance string, status *parser.Status) {
+	quotas, err := e.db.GetQuotas()
+	if err != nil {
+		log.Printf("Квоты: %v", err)
+		return
+	}
+	if len(quotas) == 0 {
+		return
+	}
+	now := time.Now().UTC()
+	seen := make(map[string]bool)
+	for _, cl := range status.Clients {
+		if seen[cl.CommonName] {
+			continue
+		}
+		seen[cl.CommonName] = true
+		if err := e.checkUser(instance, cl.CommonName, now); err != nil {
+			log.Printf("Квоты [%s] %s: %v", instance, cl.CommonName, err)
+		}
+	}
+}
+
+func (e *Enforcer) checkUser(instance, commonName string, now time.Time) error {
+	quotas, err := e.db.QuotasForUser(commonName)
+	if err != nil {
+		return err
+	}
+	for _, q := range quotas {
+		u, err := e.db.GetQuotaUsage(q, commonName, now)
+		if err != nil {
+			return err
+		}
+		if !u.Exceeded {
+			continue
+		}
+		first, err := e.db.MarkQuotaExceeded(u, now)
+		if err != nil {
+			return err
+		}
+		if first {
+			log.Printf("Квота %d [%s] %s: израсходовано %d из %d байт (%s), действие %s",
+				q.ID, instance, commonName, u.UsedBytes, q.LimitBytes, q.Period, q.Action)
+		}
+		switch q.Action {
+		case database.QuotaActionWebhook:
+			if first && e.webhooks != nil {
+				e.webhooks.Send(q.WebhookURL, Event{Event: "quota_exceeded", Instance: instance, At: now, QuotaUsage: u})
+			}
+		case database.QuotaActionDisconnect:
+			e.disconnect(instance, u, now)
+		}
+	}
+	return nil
+}
+
+// disconnect отключает клиента на инстансе и пишет попытку в журнал отключений
+func (e *Enforcer) disconnect(instance string, u *database.QuotaUsage, now time.Time) {
+	key := instance + "|" + u.CommonName
+	e.mu.Lock()
+	if now.Sub(e.lastKill[key]) < killInterval {
+		e.mu.Unlock()
+		return
+	}
+	e.lastKill[key] = now
+	e.mu.Unlock()
+
+	col := e.collectors.Get(instance)
+	if col == nil {
+		return
+	}
+	go func() {
+		rec := database.KillRecord{
+			At:          now,
+			Instance:    instance,
+			CommonName:  u.CommonName,
+			Reason:      fmt.Sprintf("квота %d: %d из %d байт (%s)", u.ID, u.UsedBytes, u.LimitBytes, u.Period),
+			RequestedBy: "quota",
+		}
+		var err error
+		if rec.Result, err = col.Kill(context.Background(), u.CommonName); err != nil {
+			rec.Error = err.Error()
+			log.Printf("Квота %d [%s]: отключение %s: %v", u.ID, instance, u.CommonName, err)
+		}
+		if _, err := e.db.LogKill(rec); err != nil {
+			log.Printf("Квота %d: журнал отключений: %v", u.ID, err)
+		}
+	}()
+}
@@ -0,0 +1,205 @@
+package quota
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"open-statistic/internal/collector"
+	"open-statistic/internal/database"
+	"open-statistic/internal/parser"
+	"open-statistic/internal/webhook"
+)
+
+func newTestDB(t *testing.T) *database.DB {
+	t.Helper()
+	db, err := database.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func save(t *testing.T, db *database.DB, instance string, at time.Time, clients ...parser.Client) *parser.Status {
+	t.Helper()
+	s := &parser.Status{Version: 2, UpdatedAt: at, Clients: clients}
+	if err := db.SaveSnapshot(instance, s); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func alice(since time.Time, received, sent int64) parser.Client {
+	return parser.Client{CommonName: "alice", RealAddress: "1.1.1.1:1000", ConnectedSince: since, BytesReceived: received, BytesSent: sent}
+}
+
+func TestPeriodBounds(t *testing.T) {
+	msk := time.FixedZone("MSK", 3*3600)
+	tests := []struct {
+		name       string
+		period     string
+		now        time.Time
+		start, end time.Time
+	}{
+		{"день", database.QuotaDaily, time.Date(2024, 2, 29, 12, 0, 0, 0, time.UTC),
+			time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC), time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{"последний день года", database.QuotaDaily, time.Date(2024, 12, 31, 23, 59, 59, 0, time.UTC),
+			time.Date(2024, 12, 31, 0, 0, 0, 0, time.UTC), time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
+		// Периоды по UTC: 1 марта 01:00 по Москве — ещё 29 февраля
+		{"день по UTC", database.QuotaDaily, time.Date(2024, 3, 1, 1, 0, 0, 0, msk),
+			time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC), time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{"високосный февраль", database.QuotaMonthly, time.Date(2024, 2, 29, 23, 0, 0, 0, time.UTC),
+			time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{"декабрь", database.QuotaMonthly, time.Date(2024, 12, 15, 0, 0, 0, 0, time.UTC),
+			time.Date(2024, 12, 1, 0, 0, 0, 0, time.UTC), time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := database.Quota{Period: tt.period}
+			start, reset := q.PeriodBounds(tt.now)
+			if !start.Equal(tt.start) || !reset.Equal(tt.end) {
+				t.Errorf("PeriodBounds = %v, %v; ожидалось %v, %v", start, reset, tt.start, tt.end)
+			}
+		})
+	}
+}
+
+// TestQuotaUsage расход суммируется по всем инстансам и только за текущий период
+func TestQuotaUsage(t *testing.T) {
+	db := newTestDB(t)
+	since := time.Date(2024, 2, 28, 22, 0, 0, 0, time.UTC)
+	save(t, db, "vpn1", time.Date(2024, 2, 28, 23, 0, 0, 0, time.UTC), alice(since, 100, 0))
+	save(t, db, "vpn1", time.Date(2024, 2, 29, 1, 0, 0, 0, time.UTC), alice(since, 400, 100))
+	save(t, db, "vpn2", time.Date(2024, 2, 29, 2, 0, 0, 0, time.UTC), alice(since, 50, 50))
+
+	tests := []struct {
+		name      string
+		period    string
+		now       time.Time
+		used      int64
+		remaining int64
+		exceeded  bool
+	}{
+		{"сутки", database.QuotaDaily, time.Date(2024, 2, 29, 12, 0, 0, 0, time.UTC), 500, 0, true},
+		{"вчера", database.QuotaDaily, time.Date(2024, 2, 28, 23, 30, 0, 0, time.UTC), 100, 400, false},
+		{"месяц", database.QuotaMonthly, time.Date(2024, 2, 29, 12, 0, 0, 0, time.UTC), 600, 0, true},
+		{"после сброса", database.QuotaMonthly, time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), 0, 500, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := database.Quota{ID: 1, CommonName: "alice", Period: tt.period, LimitBytes: 500, Action: database.QuotaActionLog}
+			u, err := db.GetQuotaUsage(q, "alice", tt.now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			start, reset := q.PeriodBounds(tt.now)
+			if !u.PeriodStart.Equal(start) || !u.ResetAt.Equal(reset) {
+				t.Errorf("период %v — %v", u.PeriodStart, u.ResetAt)
+			}
+			if u.UsedBytes != tt.used || u.RemainingBytes != tt.remaining || u.Exceeded != tt.exceeded {
+				t.Errorf("израсходовано %d, осталось %d, превышена %v", u.UsedBytes, u.RemainingBytes, u.Exceeded)
+			}
+		})
+	}
+}
+
+// TestCheckWebhookOncePerPeriod webhook о превышении отправляется один раз за период,
+// после изменения квоты — снова
+func TestCheckWebhookOncePerPeriod(t *testing.T) {
+	var mu sync.Mutex
+	var got []Event
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ev Event
+		if err := json.NewDecoder(r.Body).Decode(&ev); err != nil {
+			t.Error(err)
+		}
+		mu.Lock()
+		got = append(got, ev)
+		mu.Unlock()
+	}))
+	defer srv.Close()
+	received := func() []Event {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]Event(nil), got...)
+	}
+
+	db := newTestDB(t)
+	q := &database.Quota{CommonName: "alice", Period: database.QuotaMonthly, LimitBytes: 1000, Action: database.QuotaActionWebhook, WebhookURL: srv.URL}
+	if err := db.CreateQuota(q); err != nil {
+		t.Fatal(err)
+	}
+	e := New(db, collector.NewRegistry(db), webhook.New(time.Second, ""))
+	now := time.Now().UTC()
+	since := now.Add(-time.Hour)
+
+	e.Check("vpn1", save(t, db, "vpn1", now.Add(-2*time.Second), alice(since, 400, 100)))
+	e.Check("vpn1", save(t, db, "vpn1", now.Add(-time.Second), alice(since, 900, 200)))
+	e.Check("vpn1", save(t, db, "vpn1", now, alice(since, 1500, 300)))
+	waitFor(t, func() bool { return len(received()) == 1 })
+	if ev := received()[0]; ev.Event != "quota_exceeded" || ev.Instance != "vpn1" || ev.QuotaUsage == nil ||
+		ev.UsedBytes != 1100 || ev.CommonName != "alice" || ev.ResetAt.IsZero() {
+		t.Errorf("событие %+v", ev)
+	}
+
+	// Изменение квоты сбрасывает отметку о превышении
+	q.LimitBytes = 1500
+	if err := db.UpdateQuota(q); err != nil {
+		t.Fatal(err)
+	}
+	e.Check("vpn1", &parser.Status{Clients: []parser.Client{alice(since, 1500, 300)}})
+	waitFor(t, func() bool { return len(received()) == 2 })
+	time.Sleep(50 * time.Millisecond)
+	if n := len(received()); n != 2 {
+		t.Errorf("отправлено %d webhook-ов, ожидалось 2", n)
+	}
+}
+
+// TestCheckDisconnectInterval отключение повторяется не чаще killInterval; без management-интерфейса
+// попытка всё равно попадает в журнал
+func TestCheckDisconnectInterval(t *testing.T) {
+	db := newTestDB(t)
+	if err := db.CreateQuota(&database.Quota{CommonName: "alice", Period: database.QuotaMonthly, LimitBytes: 100, Action: database.QuotaActionDisconnect}); err != nil {
+		t.Fatal(err)
+	}
+	reg := collector.NewRegistry(db)
+	if _, err := reg.AddLocal("vpn1"); err != nil {
+		t.Fatal(err)
+	}
+	e := New(db, reg, nil)
+	now := time.Now().UTC()
+	status := save(t, db, "vpn1", now, alice(now.Add(-time.Hour), 500, 0))
+	e.Check("vpn1", status)
+	e.Check("vpn1", status)
+
+	var log []database.KillRecord
+	waitFor(t, func() bool {
+		var err error
+		log, err = db.GetKillLog("alice", database.Filter{}, 0)
+		return err == nil && len(log) > 0
+	})
+	time.Sleep(50 * time.Millisecond)
+	if log, _ = db.GetKillLog("alice", database.Filter{}, 0); len(log) != 1 {
+		t.Fatalf("журнал %+v", log)
+	}
+	if r := log[0]; r.RequestedBy != "quota" || r.Instance != "vpn1" || r.Error == "" {
+		t.Errorf("запись %+v", r)
+	}
+}
+
+// waitFor ждёт, пока cond не вернёт true
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("не дождались")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
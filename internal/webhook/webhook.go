@@ -0,0 +1,135 @@
+// Package webhook — отправка JSON-уведомлений (квоты, алерты) с повторами.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// SignatureHeader HMAC-SHA256 тела (hex) при заданном секрете
+const SignatureHeader = "X-Openstat-Signature"
+
+// ErrURLNotAllowed webhook_url не http(s) или его хоста нет среди разрешённых
+var ErrURLNotAllowed = errors.New("webhook_url не разрешён")
+
+// Sender отправляет webhook-и. Ошибки сети, 5xx и 429 повторяются с растущей паузой
+type Sender struct {
+	client  *http.Client
+	secret  string
+	retries int
+	backoff time.Duration
+	hosts   []string // разрешённые хосты; пусто — любые
+}
+
+// New создаёт отправителя. secret — ключ подписи (пусто — без подписи)
+func New(timeout time.Duration, secret string) *Sender {
+	return &Sender{
+		client:  &http.Client{Timeout: timeout},
+		secret:  secret,
+		retries: 5,
+		backoff: time.Second,
+	}
+}
+
+// SetAllowedHosts ограничивает адреса webhook-ов: имя хоста, host:port или *.domain.
+// Вызывается до первой отправки; пустой список — любой хост
+func (s *Sender) SetAllowedHosts(hosts []string) {
+	s.hosts = s.hosts[:0]
+	for _, h := range hosts {
+		s.hosts = append(s.hosts, strings.ToLower(h))
+	}
+}
+
+// CheckURL проверяет webhook_url: схема http или https и, если список задан, разрешённый хост
+func (s *Sender) CheckURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%w: нужен http(s) адрес", ErrURLNotAllowed)
+	}
+	if len(s.hosts) == 0 {
+		return nil
+	}
+	host, hostPort := strings.ToLower(u.Hostname()), strings.ToLower(u.Host)
+	for _, h := range s.hosts {
+		if h == host || h == hostPort || (strings.HasPrefix(h, "*.") && strings.HasSuffix(host, h[1:])) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: хоста %s нет в WEBHOOK_ALLOWED_HOSTS", ErrURLNotAllowed, u.Host)
+}
+
+// Send отправляет payload в фоне; итог попыток пишется в лог
+func (s *Sender) Send(url string, payload interface{}) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		defer cancel()
+		if err := s.Post(ctx, url, payload); err != nil {
+			log.Printf("Webhook %s: %v", url, err)
+		}
+	}()
+}
+
+// Post отправляет payload и ждёт результата с повторами
+func (s *Sender) Post(ctx context.Context, url string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	backoff := s.backoff
+	for attempt := 0; ; attempt++ {
+		retry, err := s.post(ctx, url, body)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= s.retries {
+			return fmt.Errorf("попытка %d: %w", attempt+1, err)
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("попытка %d: %w", attempt+1, err)
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (s *Sender) post(ctx context.Context, url string, body []byte) (retry bool, err error) {
+	// Правило могло быть создано до того, как задали список хостов
+	if err := s.CheckURL(url); err != nil {
+		return false, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "openstat")
+	if s.secret != "" {
+		mac := hmac.New(sha256.New, []byte(s.secret))
+		mac.Write(body)
+		req.Header.Set(SignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	if resp.StatusCode < 300 {
+		return false, nil
+	}
+	err = fmt.Errorf("HTTP %d: %s", resp.StatusCode, bytes.TrimSpace(msg))
+	return resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusRequestTimeout, err
+}
@@ -0,0 +1,43 @@
+package webhook
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCheckURL(t *testing.T) {
+	open := New(time.Second, "")
+	limited := New(time.Second, "")
+	limited.SetAllowedHosts([]string{"hooks.example.com", "10.0.0.5:8080", "*.Corp.Example"})
+
+	tests := []struct {
+		s   *Sender
+		url string
+		ok  bool
+	}{
+		{open, "https://anything.example.org/hook", true},
+		{open, "http://127.0.0.1:9000/", true},
+		{open, "file:///etc/passwd", false},
+		{open, "gopher://example.com/", false},
+		{open, "https:///no-host", false},
+		{open, "not a url", false},
+		{limited, "https://hooks.example.com/openstat", true},
+		{limited, "https://HOOKS.example.com:8443/openstat", true},
+		{limited, "http://10.0.0.5:8080/x", true},
+		{limited, "http://10.0.0.5:9090/x", false},
+		{limited, "https://alerts.corp.example/x", true},
+		{limited, "https://corp.example/x", false},
+		{limited, "https://evilcorp.example/x", false},
+		{limited, "http://169.254.169.254/latest/meta-data/", false},
+	}
+	for _, tt := range tests {
+		err := tt.s.CheckURL(tt.url)
+		if (err == nil) != tt.ok {
+			t.Errorf("CheckURL(%q) = %v", tt.url, err)
+		}
+		if err != nil && !errors.Is(err, ErrURLNotAllowed) {
+			t.Errorf("CheckURL(%q): ошибка не ErrURLNotAllowed: %v", tt.url, err)
+		}
+	}
+}